@@ -0,0 +1,110 @@
+package lion
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CheckPreconditions evaluates the conditional headers of req against the current etag and modification time of the resource,
+// following the order defined by RFC 7232.
+// It returns http.StatusNotModified if the client copy is still fresh, http.StatusPreconditionFailed if a precondition does not hold
+// and 0 if the request should be processed normally.
+// An empty etag or a zero modTime are considered unknown.
+func CheckPreconditions(req *http.Request, etag string, modTime time.Time) int {
+	modTime = modTime.Truncate(time.Second)
+
+	if im := req.Header.Get("If-Match"); im != "" {
+		if !matchETag(im, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := parseHTTPTime(req.Header.Get("If-Unmodified-Since")); !ius.IsZero() && !modTime.IsZero() {
+		if modTime.After(ius) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	safe := req.Method == GET || req.Method == HEAD
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		if matchETag(inm, etag, true) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims := parseHTTPTime(req.Header.Get("If-Modified-Since")); safe && !ims.IsZero() && !modTime.IsZero() {
+		if !modTime.After(ims) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// CheckModified sets the ETag and Last-Modified headers and evaluates the conditional headers of the request with CheckPreconditions.
+// It responds with 304 Not Modified or 412 Precondition Failed and returns true if the client copy is still fresh or if a precondition failed.
+// In this case, the handler should return immediately:
+//
+//	if c.CheckModified(post.ETag(), post.UpdatedAt) {
+//		return
+//	}
+func (c *ctx) CheckModified(etag string, modTime time.Time) bool {
+	if etag != "" {
+		c.Header().Set("ETag", etag)
+	}
+	if !modTime.IsZero() {
+		c.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+
+	code := CheckPreconditions(c.Request(), etag, modTime)
+	if code == 0 {
+		return false
+	}
+
+	if code == http.StatusNotModified {
+		h := c.Header()
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		c.WithStatus(code)
+		c.writeHeader()
+		return true
+	}
+	c.Error(ErrorPreconditionFailed)
+	return true
+}
+
+// matchETag checks if etag is listed in the value of an If-Match or If-None-Match header.
+// The weak comparison ignores the W/ prefix.
+func matchETag(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	if !weak && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHTTPTime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := http.ParseTime(s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
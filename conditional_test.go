@@ -0,0 +1,69 @@
+package lion
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/celrenheit/htest"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	modTime := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	before := "Fri, 01 Jan 2016 00:00:00 GMT"
+	after := "Sun, 03 Jan 2016 00:00:00 GMT"
+
+	tests := []struct {
+		method   string
+		headers  mss
+		etag     string
+		expected int
+	}{
+		{method: GET, headers: mss{}, etag: `"a"`, expected: 0},
+		{method: GET, headers: mss{"If-None-Match": `"a"`}, etag: `"a"`, expected: http.StatusNotModified},
+		{method: GET, headers: mss{"If-None-Match": `W/"a"`}, etag: `"a"`, expected: http.StatusNotModified},
+		{method: GET, headers: mss{"If-None-Match": `"b", "c"`}, etag: `"a"`, expected: 0},
+		{method: HEAD, headers: mss{"If-None-Match": "*"}, etag: `"a"`, expected: http.StatusNotModified},
+		{method: GET, headers: mss{"If-Modified-Since": after}, etag: `"a"`, expected: http.StatusNotModified},
+		{method: GET, headers: mss{"If-Modified-Since": before}, etag: `"a"`, expected: 0},
+		// If-None-Match takes precedence over If-Modified-Since
+		{method: GET, headers: mss{"If-None-Match": `"b"`, "If-Modified-Since": after}, etag: `"a"`, expected: 0},
+		{method: PUT, headers: mss{"If-Match": `"a"`}, etag: `"a"`, expected: 0},
+		{method: PUT, headers: mss{"If-Match": `W/"a"`}, etag: `W/"a"`, expected: http.StatusPreconditionFailed},
+		{method: PUT, headers: mss{"If-Match": `"b"`}, etag: `"a"`, expected: http.StatusPreconditionFailed},
+		{method: PUT, headers: mss{"If-Match": "*"}, etag: "", expected: http.StatusPreconditionFailed},
+		{method: PUT, headers: mss{"If-Unmodified-Since": before}, etag: `"a"`, expected: http.StatusPreconditionFailed},
+		{method: PUT, headers: mss{"If-Unmodified-Since": after}, etag: `"a"`, expected: 0},
+		{method: DELETE, headers: mss{"If-None-Match": `"a"`}, etag: `"a"`, expected: http.StatusPreconditionFailed},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, "/", nil)
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+		if got := CheckPreconditions(req, test.etag, modTime); got != test.expected {
+			t.Errorf("%s with %v and etag %s: got %d want %d", test.method, test.headers, test.etag, got, test.expected)
+		}
+	}
+}
+
+func TestContextCheckModified(t *testing.T) {
+	l := New()
+	l.GET("/post", func(c Context) {
+		if c.CheckModified(`"v1"`, time.Time{}) {
+			return
+		}
+		c.WithStatus(http.StatusOK).String("fresh")
+	})
+
+	test := htest.New(t, l)
+	test.Get("/post").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("ETag", `"v1"`).
+		ExpectHeader("Last-Modified", "").
+		ExpectBody("fresh")
+	test.Get("/post").AddHeader("If-None-Match", `"v1"`).Do().
+		ExpectStatus(http.StatusNotModified).
+		ExpectBody("")
+}
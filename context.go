@@ -6,11 +6,13 @@ import (
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/celrenheit/lion/internal/matcher"
 )
 
 type ctxKeyType int
@@ -44,6 +46,10 @@ type Context interface {
 
 	Request() *http.Request
 
+	// Route returns the route matched for the current request.
+	// It returns nil if no route has been matched.
+	Route() Route
+
 	// Request
 	Cookie(name string) (*http.Cookie, error)
 	Query(name string) string
@@ -53,6 +59,15 @@ type Context interface {
 	WithStatus(code int) Context
 	WithHeader(key, value string) Context
 	WithCookie(cookie *http.Cookie) Context
+	CheckModified(etag string, modTime time.Time) bool
+
+	// Logging
+
+	// Logger returns a logger carrying the route pattern, the remote IP and the attributes added with WithLogAttrs.
+	Logger() *slog.Logger
+	// WithLogAttrs adds attributes, as key-value pairs or slog.Attr, to the logger of the request.
+	// They replace attributes with the same key.
+	WithLogAttrs(args ...interface{}) Context
 
 	// Rendering
 	JSON(data interface{}) error
@@ -76,8 +91,18 @@ type ctx struct {
 	code          int
 	statusWritten bool
 
-	tags          matcher.Tags
-	searchHistory []string
+	// route is the route matched for the current request
+	route *route
+
+	baseLogger *slog.Logger
+	logger     *slog.Logger // built from baseLogger and logAttrs on first use
+	logAttrs   []slog.Attr
+
+	observers []LayerObserver
+
+	// pool receives the context once released by the router and the middlewares retaining it
+	pool *sync.Pool
+	refs int32
 }
 
 // newContext creates a new context instance
@@ -95,8 +120,6 @@ func newContextWithResReq(c context.Context, w http.ResponseWriter, r *http.Requ
 		parent:         c,
 		ResponseWriter: w,
 		req:            r,
-		tags:           make([]string, 1),
-		searchHistory:  make([]string, 0, 20), // Preallocate enough capacity. TODO: Make it depend on max nodes
 	}
 }
 
@@ -154,6 +177,9 @@ func (c *ctx) Clone() Context {
 	nc.parent = c.parent
 	nc.params = make([]parameter, len(c.params), cap(c.params))
 	copy(nc.params, c.params)
+	nc.route = c.route
+	nc.baseLogger = c.baseLogger
+	nc.logAttrs = append([]slog.Attr(nil), c.logAttrs...)
 
 	// shallow copy of request
 	nr := &c.req
@@ -162,16 +188,19 @@ func (c *ctx) Clone() Context {
 	return nc
 }
 
-func (c *ctx) SearchHistory() []string {
-	return c.searchHistory
-}
-
 ///////////// REQUEST UTILS ////////////////
 
 func (c *ctx) Request() *http.Request {
 	return c.req
 }
 
+func (c *ctx) Route() Route {
+	if c.route == nil {
+		return nil
+	}
+	return c.route
+}
+
 func (c *ctx) Cookie(name string) (*http.Cookie, error) {
 	return c.Request().Cookie(name)
 }
@@ -221,6 +250,62 @@ func (c *ctx) WithCookie(cookie *http.Cookie) Context {
 	return c
 }
 
+// Logger returns the logger of the request.
+// It is created from the logger configured with WithRequestLogger, or slog.Default().
+func (c *ctx) Logger() *slog.Logger {
+	if c.logger != nil {
+		return c.logger
+	}
+	base := c.baseLogger
+	if base == nil {
+		base = slog.Default()
+	}
+
+	args := make([]interface{}, 0, len(c.logAttrs)+2)
+	if c.route != nil && !hasLogAttr(c.logAttrs, "route") {
+		args = append(args, slog.String("route", c.route.Pattern()))
+	}
+	if c.req != nil && !hasLogAttr(c.logAttrs, "remote_ip") {
+		ip := c.req.RemoteAddr
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		args = append(args, slog.String("remote_ip", ip))
+	}
+	for _, a := range c.logAttrs {
+		args = append(args, a)
+	}
+	c.logger = base.With(args...)
+	return c.logger
+}
+
+func (c *ctx) WithLogAttrs(args ...interface{}) Context {
+	// Let slog convert key-value pairs to attributes
+	r := slog.NewRecord(time.Time{}, 0, "", 0)
+	r.Add(args...)
+	r.Attrs(func(a slog.Attr) bool {
+		for i := range c.logAttrs {
+			if c.logAttrs[i].Key == a.Key {
+				c.logAttrs[i] = a
+				return true
+			}
+		}
+		c.logAttrs = append(c.logAttrs, a)
+		return true
+	})
+	c.logger = nil
+	return c
+}
+
+func hasLogAttr(attrs []slog.Attr, key string) bool {
+	for _, a := range attrs {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
 ///////////// RESPONSE MODIFIERS /////////////
 
 ///////////// RESPONSE RENDERING /////////////
@@ -293,7 +378,11 @@ func (c *ctx) Reset() {
 	c.ResponseWriter = nil
 	c.code = 0
 	c.statusWritten = false
-	c.searchHistory = c.searchHistory[:0]
+	c.route = nil
+	c.baseLogger = nil
+	c.logger = nil
+	c.logAttrs = c.logAttrs[:0]
+	c.observers = c.observers[:0]
 }
 
 func (c *ctx) Remove(key string) {
@@ -330,6 +419,25 @@ func Param(req *http.Request, key string) string {
 	return C(req).Param(key)
 }
 
+// RetainContext prevents the Context of req from being reused for another request until release is called.
+// Middlewares running the next handlers in a goroutine which can outlive the request, e.g. after a timeout, must retain it.
+func RetainContext(req *http.Request) (release func()) {
+	c, ok := C(req).(*ctx)
+	if !ok {
+		return func() {}
+	}
+	atomic.AddInt32(&c.refs, 1)
+	var once sync.Once
+	return func() { once.Do(c.release) }
+}
+
+// release puts the context back in its pool once it is no longer retained
+func (c *ctx) release() {
+	if atomic.AddInt32(&c.refs, -1) == 0 && c.pool != nil {
+		c.pool.Put(c)
+	}
+}
+
 func setParamContext(req *http.Request, c *ctx) *http.Request {
 	c.parent = req.Context()
 	return req.WithContext(context.WithValue(req.Context(), ctxKey, c))
@@ -365,11 +473,23 @@ var (
 	ErrorNotFound HTTPError = httpError{http.StatusNotFound}
 	// ErrorMethodNotAllowed returns a MethodNotAllowed response with the corresponding body
 	ErrorMethodNotAllowed HTTPError = httpError{http.StatusMethodNotAllowed}
+	// ErrorPreconditionFailed returns a PreconditionFailed response with the corresponding body
+	ErrorPreconditionFailed HTTPError = httpError{http.StatusPreconditionFailed}
+	// ErrorRequestEntityTooLarge returns a RequestEntityTooLarge response with the corresponding body
+	ErrorRequestEntityTooLarge HTTPError = httpError{http.StatusRequestEntityTooLarge}
+	// ErrorUnsupportedMediaType returns a UnsupportedMediaType response with the corresponding body
+	ErrorUnsupportedMediaType HTTPError = httpError{http.StatusUnsupportedMediaType}
+	// ErrorTooManyRequests returns a TooManyRequests response with the corresponding body
+	ErrorTooManyRequests HTTPError = httpError{http.StatusTooManyRequests}
 
 	// 5xx
 
 	// ErrorInternalServer returns a InternalServerError response with the corresponding body
 	ErrorInternalServer HTTPError = httpError{http.StatusInternalServerError}
+	// ErrorServiceUnavailable returns a ServiceUnavailable response with the corresponding body
+	ErrorServiceUnavailable HTTPError = httpError{http.StatusServiceUnavailable}
+	// ErrorGatewayTimeout returns a GatewayTimeout response with the corresponding body
+	ErrorGatewayTimeout HTTPError = httpError{http.StatusGatewayTimeout}
 )
 
 type httpError struct{ code int }
@@ -1,9 +1,12 @@
 package lion
 
 import (
+	"bytes"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"math"
 	"net/http"
 	"net/http/httptest"
@@ -328,3 +331,84 @@ func newTestCtx() (c *ctx, w *httptest.ResponseRecorder) {
 
 	return
 }
+
+func TestContextLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := New(MiddlewareFunc(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			C(r).WithLogAttrs("user", "lion", slog.Int("attempt", 1))
+			C(r).WithLogAttrs("attempt", 2)
+			next.ServeHTTP(w, r)
+		})
+	}))
+	l.Configure(WithRequestLogger(slog.New(slog.NewJSONHandler(buf, nil))))
+	l.GetFunc("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		C(r).WithLogAttrs("user_id", Param(r, "id"))
+		C(r).Logger().Info("deep in the handler")
+	})
+
+	req := httptest.NewRequest("GET", "/users/42", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+	l.ServeHTTP(httptest.NewRecorder(), req)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"msg":       "deep in the handler",
+		"route":     "/users/:id",
+		"remote_ip": "192.168.1.1",
+		"user":      "lion",
+		"attempt":   float64(2),
+		"user_id":   "42",
+	}
+	for k, v := range expected {
+		if record[k] != v {
+			t.Errorf("Expected %s to be %v but got %v", k, v, record[k])
+		}
+	}
+
+	// The attributes of a request are not kept in the pooled context
+	buf.Reset()
+	l.GetFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		C(r).Logger().Info("plain")
+	})
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/plain", nil))
+	if bytes.Contains(buf.Bytes(), []byte(`"user_id"`)) {
+		t.Errorf("Attributes of a previous request should not be kept: %s", buf.String())
+	}
+	if bytes.Count(buf.Bytes(), []byte(`"attempt"`)) != 1 {
+		t.Errorf("Attributes should not be duplicated: %s", buf.String())
+	}
+}
+
+type taggingWriter struct {
+	http.ResponseWriter
+}
+
+func (w taggingWriter) WriteHeader(code int) {
+	w.Header().Set("X-Tagged", "true")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func TestContextualHandlerUsesMiddlewareWriter(t *testing.T) {
+	l := New(MiddlewareFunc(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(context.WithValue(r.Context(), "mwKey", "mwVal"))
+			next.ServeHTTP(taggingWriter{w}, r)
+		})
+	}))
+	l.GET("/", func(c Context) {
+		if c.Request().Context().Value("mwKey") != "mwVal" {
+			t.Errorf("The contextual handler should see the request of the middlewares")
+		}
+		c.WithStatus(http.StatusCreated).String("ok")
+	})
+
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusCreated || w.Header().Get("X-Tagged") != "true" || w.Body.String() != "ok" {
+		t.Errorf("The contextual handler should write through the writer of the middlewares: %d %v", w.Code, w.Header())
+	}
+}
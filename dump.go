@@ -0,0 +1,172 @@
+package lion
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+)
+
+// DumpFormat defines the output format used by Router.Dump
+type DumpFormat int
+
+// Available formats for Router.Dump
+const (
+	DumpText DumpFormat = iota
+	DumpJSON
+)
+
+// RouteInfo describes a handler registered for a single HTTP method on a Route.
+type RouteInfo struct {
+	Host    string `json:"host"`
+	Method  string `json:"method"`
+	Pattern string `json:"pattern"`
+	Name    string `json:"name,omitempty"`
+
+	// Origin is the module or resource that registered the handler.
+	// Nested registrations are separated by " > ".
+	Origin string `json:"origin,omitempty"`
+
+	// Middlewares lists the middlewares applied to the handler, outermost first.
+	// Middlewares pulled in through UseNamed are prefixed by their name.
+	Middlewares []string `json:"middlewares"`
+}
+
+// Infos returns a RouteInfo for each method registered on the routes.
+func (rs Routes) Infos() []RouteInfo {
+	infos := []RouteInfo{}
+	seen := make(map[Route]bool, len(rs))
+	for _, r := range rs {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+
+		rt, ok := r.(*route)
+		for _, method := range r.Methods() {
+			info := RouteInfo{
+				Host:        r.Host(),
+				Method:      method,
+				Pattern:     r.Pattern(),
+				Name:        r.Name(),
+				Middlewares: []string{},
+			}
+			if ok {
+				mws, origin := rt.info(method)
+				info.Origin = origin
+				info.Middlewares = append(info.Middlewares, mws...)
+			}
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// Table returns a text table listing each method registered on the routes.
+func (rs Routes) Table() string {
+	buf := new(bytes.Buffer)
+	tw := tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tMETHOD\tPATTERN\tNAME\tORIGIN\tMIDDLEWARES")
+	for _, i := range rs.Infos() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			orDash(i.Host), i.Method, i.Pattern, orDash(i.Name), orDash(i.Origin), orDash(strings.Join(i.Middlewares, " > ")))
+	}
+	tw.Flush()
+	return buf.String()
+}
+
+// Dump writes every route registered in the router, with their effective middleware chain, to w.
+//
+//	r := lion.New()
+//	// ... register routes
+//	r.Dump(os.Stdout, lion.DumpText)
+func (r *Router) Dump(w io.Writer, format DumpFormat) error {
+	routes := r.Routes()
+	switch format {
+	case DumpText:
+		_, err := io.WriteString(w, routes.Table())
+		return err
+	case DumpJSON:
+		return json.NewEncoder(w).Encode(routes.Infos())
+	default:
+		return fmt.Errorf("lion: unknown dump format %d", format)
+	}
+}
+
+// addOrigin records that the routes registered through this router come from a module or a resource.
+func (r *Router) addOrigin(kind string, v interface{}) {
+	desc := kind + " " + typeName(v)
+	if r.origin != "" {
+		desc = r.origin + " > " + desc
+	}
+	r.origin = desc
+}
+
+// chain describes the middlewares applied by buildMiddlewares, outermost first.
+func (r *Router) chain() []string {
+	var names []string
+	if !r.isRoot() {
+		names = r.parent.chain()
+	}
+	for i, mw := range r.middlewares {
+		for _, name := range describeMiddleware(mw) {
+			if label := r.middlewareLabels[i]; label != "" {
+				name = label + ":" + name
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// flattenMiddleware returns the middlewares grouped in mw, in the order they are applied
+func flattenMiddleware(mw Middleware) Middlewares {
+	m, ok := mw.(Middlewares)
+	if !ok {
+		return Middlewares{mw}
+	}
+	var flat Middlewares
+	for _, sub := range m {
+		flat = append(flat, flattenMiddleware(sub)...)
+	}
+	return flat
+}
+
+// describeMiddleware returns the name of a middleware.
+// Middlewares grouped together are flattened.
+func describeMiddleware(mw Middleware) []string {
+	switch m := mw.(type) {
+	case Middlewares:
+		var names []string
+		for _, sub := range m {
+			names = append(names, describeMiddleware(sub)...)
+		}
+		return names
+	case MiddlewareFunc:
+		return []string{funcName(m)}
+	}
+	return []string{typeName(mw)}
+}
+
+func typeName(v interface{}) string {
+	return reflect.TypeOf(v).String()
+}
+
+func funcName(fn interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,128 @@
+package lion
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type dumpResource struct{}
+
+func (dumpResource) Get(w http.ResponseWriter, r *http.Request) {}
+
+func (dumpResource) PostMiddlewares() Middlewares                { return Middlewares{fakeMW("post", "true")} }
+func (dumpResource) Post(w http.ResponseWriter, r *http.Request) {}
+
+type dumpModule struct{}
+
+func (dumpModule) Base() string                               { return "/admin" }
+func (dumpModule) Requires() []string                         { return []string{"auth"} }
+func (dumpModule) Get(w http.ResponseWriter, r *http.Request) {}
+func (dumpModule) Routes(r *Router) {
+	r.Resource("/users", dumpResource{})
+}
+
+func TestRoutesInfos(t *testing.T) {
+	l := New(fakeMW("root", "true"))
+	l.Define("auth", fakeMW("auth", "true"))
+	l.Get("/", fakeHandler()).WithName("home")
+
+	api := l.Group("/api", Middlewares{fakeMW("a", "1"), fakeMW("b", "2")})
+	api.Get("/users", fakeHandler())
+
+	l.Module(dumpModule{})
+
+	sub := New(fakeMW("sub", "true"))
+	sub.Get("/status", fakeHandler())
+	l.Mount("/v2", sub)
+
+	got := map[string]RouteInfo{}
+	for _, info := range l.Routes().Infos() {
+		got[info.Method+" "+info.Pattern] = info
+	}
+
+	tests := []struct {
+		key         string
+		name        string
+		origin      string
+		middlewares []string
+	}{
+		{key: "GET /", name: "home", middlewares: []string{"*lion.fakemw"}},
+		{key: "GET /api/users", middlewares: []string{"*lion.fakemw", "*lion.fakemw", "*lion.fakemw"}},
+		{key: "GET /admin", origin: "module lion.dumpModule", middlewares: []string{"*lion.fakemw", "auth:*lion.fakemw"}},
+		{key: "GET /admin/users", origin: "module lion.dumpModule > resource lion.dumpResource", middlewares: []string{"*lion.fakemw", "auth:*lion.fakemw"}},
+		{key: "POST /admin/users", origin: "module lion.dumpModule > resource lion.dumpResource", middlewares: []string{"*lion.fakemw", "auth:*lion.fakemw", "*lion.fakemw"}},
+		{key: "GET /v2/status", middlewares: []string{"*lion.fakemw", "*lion.fakemw"}},
+	}
+
+	if len(got) != len(tests) {
+		t.Fatalf("Expected %d route infos but got %d: %v", len(tests), len(got), got)
+	}
+
+	for _, test := range tests {
+		info, ok := got[test.key]
+		if !ok {
+			t.Errorf("Route info not found for %s", test.key)
+			continue
+		}
+		if info.Name != test.name {
+			t.Errorf("Incorrect name for %s: got '%s' want '%s'", test.key, info.Name, test.name)
+		}
+		if info.Origin != test.origin {
+			t.Errorf("Incorrect origin for %s: got '%s' want '%s'", test.key, info.Origin, test.origin)
+		}
+		if !reflect.DeepEqual(info.Middlewares, test.middlewares) {
+			t.Errorf("Incorrect middlewares for %s: got %v want %v", test.key, info.Middlewares, test.middlewares)
+		}
+	}
+}
+
+func TestRoutesInfosAny(t *testing.T) {
+	l := New(fakeMW("root", "true"))
+	l.Group("/api").Any("/any", fakeHandler())
+
+	infos := l.Routes().Infos()
+	if len(infos) != len(allowedHTTPMethods) {
+		t.Fatalf("Expected %d route infos but got %d", len(allowedHTTPMethods), len(infos))
+	}
+	for _, info := range infos {
+		if len(info.Middlewares) != 1 {
+			t.Errorf("Incorrect middlewares for %s: %v", info.Method, info.Middlewares)
+		}
+	}
+}
+
+func TestRouterDump(t *testing.T) {
+	l := New()
+	l.UseFunc(func(next http.Handler) http.Handler { return next })
+	l.Host("admin.example.com").Get("/users", fakeHandler()).WithName("users")
+
+	buf := new(bytes.Buffer)
+	if err := l.Dump(buf, DumpText); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"HOST", "admin.example.com", "GET", "/users", "users", "lion.TestRouterDump.func1"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Text dump should contain '%s': %s", expected, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := l.Dump(buf, DumpJSON); err != nil {
+		t.Fatal(err)
+	}
+	var infos []RouteInfo
+	if err := json.Unmarshal(buf.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Host != "admin.example.com" || infos[0].Name != "users" {
+		t.Errorf("Incorrect JSON dump: %s", buf.String())
+	}
+
+	if err := l.Dump(buf, DumpFormat(42)); err == nil {
+		t.Errorf("Should return an error for an unknown format")
+	}
+}
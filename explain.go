@@ -0,0 +1,222 @@
+package lion
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/celrenheit/lion/internal/matcher"
+)
+
+// ExplainResult is the outcome of the routing of a request explained by Router.Explain
+type ExplainResult string
+
+// Possible outcomes of Router.Explain
+const (
+	// ExplainMatched means that a handler has been found for the method and the path
+	ExplainMatched ExplainResult = "matched"
+	// ExplainNotFound means that no route matches the host or the path
+	ExplainNotFound ExplainResult = "not found"
+	// ExplainRedirect means that the request is redirected to the same path with or without a trailing slash
+	ExplainRedirect ExplainResult = "redirect (trailing slash)"
+	// ExplainMethodNotAllowed means that the path matches a route that has no handler for the method
+	ExplainMethodNotAllowed ExplainResult = "method not allowed"
+	// ExplainAutomaticOptions means that the router answers the OPTIONS request itself
+	ExplainAutomaticOptions ExplainResult = "automatic options"
+)
+
+// ExplainStep is a single step taken while matching the host or the path of a request
+type ExplainStep struct {
+	// Phase is either "host" or "path"
+	Phase string `json:"phase"`
+	// Action is one of "visit", "backtrack", "add param" or "remove param"
+	Action string `json:"action"`
+	// Node is the pattern of the tree node up to this step
+	Node   string `json:"node,omitempty"`
+	Search string `json:"search,omitempty"`
+	Param  string `json:"param,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+func (s ExplainStep) String() string {
+	switch s.Action {
+	case "add param", "remove param":
+		return fmt.Sprintf("[%s] %s %s=%q", s.Phase, s.Action, s.Param, s.Value)
+	}
+	return fmt.Sprintf("[%s] %s %q with %q remaining", s.Phase, s.Action, s.Node, s.Search)
+}
+
+// Explanation describes how the router handles a request
+type Explanation struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+
+	// Host is the host pattern chosen, empty if the router does not use hosts or if the default host has been chosen
+	Host string `json:"host"`
+	// Steps contains every node visited and every param captured or removed, in order
+	Steps []ExplainStep `json:"steps"`
+	// Params are the params available to the handler
+	Params map[string]string `json:"params"`
+
+	Result ExplainResult `json:"result"`
+	// Redirect is the location used when Result is ExplainRedirect
+	Redirect string `json:"redirect,omitempty"`
+
+	// Pattern and Name of the route found, if any
+	Pattern string `json:"pattern,omitempty"`
+	Name    string `json:"name,omitempty"`
+
+	// Middlewares that would run before the handler, outermost first
+	Middlewares []string `json:"middlewares,omitempty"`
+}
+
+func (e *Explanation) String() string {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%s %s: %s\n", e.Method, e.URL, e.Result)
+	if e.Host != "" {
+		fmt.Fprintf(buf, "host: %s\n", e.Host)
+	}
+	if e.Pattern != "" {
+		fmt.Fprintf(buf, "route: %s%s", e.Host, e.Pattern)
+		if e.Name != "" {
+			fmt.Fprintf(buf, " (%s)", e.Name)
+		}
+		fmt.Fprintln(buf)
+	}
+	if e.Redirect != "" {
+		fmt.Fprintf(buf, "redirect: %s\n", e.Redirect)
+	}
+	for _, s := range e.Steps {
+		fmt.Fprintf(buf, "  %s\n", s)
+	}
+	keys := make([]string, 0, len(e.Params))
+	for k := range e.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(buf, "param: %s=%q\n", k, e.Params[k])
+	}
+	for _, mw := range e.Middlewares {
+		fmt.Fprintf(buf, "middleware: %s\n", mw)
+	}
+	return buf.String()
+}
+
+// Explain describes how a request with the method and url provided would be routed:
+// the host pattern chosen, every tree node visited, the params captured and removed during backtracking,
+// the route found or the reason of the failure and the middlewares that would run.
+// The url can either be a path or an absolute url containing a host.
+func (r *Router) Explain(method, rawurl string) (*Explanation, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Explanation{
+		Method: method,
+		URL:    rawurl,
+		Params: map[string]string{},
+		Result: ExplainNotFound,
+	}
+	c := &explainCtx{ctx: newContext(), e: e}
+
+	hm := r.root().hostrm
+	rm := hm.defaultRM
+	if hm.multihost {
+		c.phase = "host"
+		value, _ := hm.matcher.GetWithContext(c, reverseHost(u.Host), nil)
+		if _, ok := c.ParamOk(defaultAnyHostKey); ok {
+			c.Remove(defaultAnyHostKey)
+		}
+
+		var ok bool
+		if rm, ok = value.(registerMatcher); !ok {
+			return e, nil
+		}
+		if c.lastNode != reverseHost(defaultAnyHostPattern) {
+			e.Host = reverseHost(c.lastNode)
+		}
+	}
+
+	pm, ok := rm.(*pathMatcher)
+	if !ok {
+		return e, nil
+	}
+
+	c.phase = "path"
+	p := cleanPath(u.Path)
+	store, err := matcher.Find(pm.matcher, c, p)
+	for _, param := range c.params {
+		e.Params[param.key] = param.val
+	}
+
+	switch err {
+	case matcher.ErrTSR:
+		e.Result = ExplainRedirect
+		if p[len(p)-1] == '/' {
+			e.Redirect = p[:len(p)-1]
+		} else {
+			e.Redirect = p + "/"
+		}
+		return e, nil
+	case matcher.ErrNotFound:
+		return e, nil
+	}
+
+	rt, ok := store.(*route)
+	if !ok || rt == nil {
+		return e, nil
+	}
+	e.Pattern = rt.pattern
+	e.Name = rt.name
+
+	if rt.getHandler(method) == nil {
+		e.Result = ExplainMethodNotAllowed
+		if method == OPTIONS && len(rt.Methods()) > 0 {
+			e.Result = ExplainAutomaticOptions
+		}
+		return e, nil
+	}
+
+	e.Result = ExplainMatched
+	e.Middlewares, _ = rt.info(method)
+	return e, nil
+}
+
+// explainCtx records the steps taken by the matcher
+type explainCtx struct {
+	*ctx
+	e        *Explanation
+	phase    string
+	lastNode string
+}
+
+var _ matcher.Tracer = (*explainCtx)(nil)
+
+func (c *explainCtx) Visit(node, search string) {
+	c.lastNode = node
+	c.step(ExplainStep{Action: "visit", Node: node, Search: search})
+}
+
+func (c *explainCtx) Backtrack(node, search string) {
+	c.lastNode = node
+	c.step(ExplainStep{Action: "backtrack", Node: node, Search: search})
+}
+
+func (c *explainCtx) AddParam(key, val string) {
+	c.ctx.AddParam(key, val)
+	c.step(ExplainStep{Action: "add param", Param: key, Value: val})
+}
+
+func (c *explainCtx) Remove(key string) {
+	val := c.Param(key)
+	c.ctx.Remove(key)
+	c.step(ExplainStep{Action: "remove param", Param: key, Value: val})
+}
+
+func (c *explainCtx) step(s ExplainStep) {
+	s.Phase = c.phase
+	c.e.Steps = append(c.e.Steps, s)
+}
@@ -0,0 +1,99 @@
+package lion
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	l := New(fakeMW("root", "true"))
+	l.Get("/hello/contact/named", fakeHandler())
+	l.Get("/hello/contact/:dest", fakeHandler()).WithName("contact")
+	l.Get("/hello/contact/:dest/static", fakeHandler())
+	l.Get("/hello/contact/:dest/*path", fakeHandler())
+	l.Post("/posts", fakeHandler())
+	l.Get("/folder/", fakeHandler())
+
+	tests := []struct {
+		method      string
+		url         string
+		result      ExplainResult
+		pattern     string
+		params      mss
+		redirect    string
+		middlewares []string
+		steps       []string
+	}{
+		{
+			method: GET, url: "/hello/contact/nameddd", result: ExplainMatched,
+			pattern: "/hello/contact/:dest", params: mss{"dest": "nameddd"}, middlewares: []string{"*lion.fakemw"},
+			steps: []string{"backtrack", "add param dest=\"nameddd\""},
+		},
+		{
+			method: GET, url: "/hello/contact/batman/folder/file", result: ExplainMatched,
+			pattern: "/hello/contact/:dest/*path", params: mss{"dest": "batman", "path": "folder/file"}, middlewares: []string{"*lion.fakemw"},
+			steps: []string{"add param dest=\"batman\"", "add param path=\"folder/file\""},
+		},
+		{method: GET, url: "/unknown", result: ExplainNotFound, params: mss{}},
+		{method: GET, url: "/folder", result: ExplainRedirect, redirect: "/folder/", params: mss{}},
+		{method: GET, url: "/posts", result: ExplainMethodNotAllowed, pattern: "/posts", params: mss{}},
+		{method: OPTIONS, url: "/posts", result: ExplainAutomaticOptions, pattern: "/posts", params: mss{}},
+	}
+
+	for _, test := range tests {
+		e, err := l.Explain(test.method, test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Result != test.result {
+			t.Errorf("Incorrect result for %s %s: got '%s' want '%s'", test.method, test.url, e.Result, test.result)
+		}
+		if e.Pattern != test.pattern {
+			t.Errorf("Incorrect pattern for %s %s: got '%s' want '%s'", test.method, test.url, e.Pattern, test.pattern)
+		}
+		if e.Redirect != test.redirect {
+			t.Errorf("Incorrect redirect for %s %s: got '%s' want '%s'", test.method, test.url, e.Redirect, test.redirect)
+		}
+		if !reflect.DeepEqual(e.Params, map[string]string(test.params)) {
+			t.Errorf("Incorrect params for %s %s: got %v want %v", test.method, test.url, e.Params, test.params)
+		}
+		if !reflect.DeepEqual(e.Middlewares, test.middlewares) {
+			t.Errorf("Incorrect middlewares for %s %s: got %v want %v", test.method, test.url, e.Middlewares, test.middlewares)
+		}
+		str := e.String()
+		for _, step := range test.steps {
+			if !strings.Contains(str, step) {
+				t.Errorf("Explanation for %s %s should contain '%s':\n%s", test.method, test.url, step, str)
+			}
+		}
+	}
+}
+
+func TestExplainHost(t *testing.T) {
+	l := New()
+	l.Host("$user.blog.com").Get("/posts/:id", fakeHandler())
+	l.Host("").Get("/", fakeHandler())
+
+	e, err := l.Explain(GET, "http://batman.blog.com/posts/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Result != ExplainMatched || e.Host != "$user.blog.com" || e.Pattern != "/posts/:id" {
+		t.Errorf("Incorrect explanation:\n%s", e)
+	}
+	if !reflect.DeepEqual(e.Params, map[string]string{"user": "batman", "id": "1"}) {
+		t.Errorf("Incorrect params: %v", e.Params)
+	}
+
+	e, _ = l.Explain(GET, "http://other.org/")
+	if e.Result != ExplainMatched || e.Host != "" || e.Pattern != "/" {
+		t.Errorf("Incorrect explanation for the default host:\n%s", e)
+	}
+	if len(e.Params) != 0 {
+		t.Errorf("The default host param should be removed: %v", e.Params)
+	}
+	if !strings.Contains(e.String(), "remove param "+defaultAnyHostKey) {
+		t.Errorf("The removal of the default host param should be recorded:\n%s", e)
+	}
+}
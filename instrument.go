@@ -0,0 +1,232 @@
+package lion
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithInstrumentation times each layer of the routes registered afterwards: each middleware, identified by its type name
+// or the name given to Define, and the handler. The timings are aggregated per route and available through Router.LayerStats.
+//
+// If serverTiming is set, the time spent in each layer before the response headers are written is sent in the Server-Timing header.
+// It is meant to be used during development, as it exposes the middlewares of the application.
+func WithInstrumentation(serverTiming bool) RouterOption {
+	return func(router *Router) {
+		router.instrumentation = &layerStatsRecorder{stats: make(map[layerKey]*LayerStats)}
+		router.serverTiming = serverTiming
+	}
+}
+
+// LayerStats are the timings of a layer of a route.
+// Durations are exclusive, i.e. they do not include the time spent in the next layers.
+type LayerStats struct {
+	Method string
+	Route  string
+	Layer  string
+	// Position is the position of the layer in the chain of the route
+	Position int
+	Count    int64
+	Total    time.Duration
+	Max      time.Duration
+}
+
+// Mean returns the mean duration of the layer
+func (s LayerStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// LayerStats returns the timings recorded for each layer of each route, sorted by route, method and position.
+// It returns nil if the instrumentation is not enabled with WithInstrumentation.
+func (r *Router) LayerStats() []LayerStats {
+	rec := r.root().instrumentation
+	if rec == nil {
+		return nil
+	}
+	rec.mu.Lock()
+	stats := make([]LayerStats, 0, len(rec.stats))
+	for _, s := range rec.stats {
+		stats = append(stats, *s)
+	}
+	rec.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Position < b.Position
+	})
+	return stats
+}
+
+// ResetLayerStats clears the timings recorded
+func (r *Router) ResetLayerStats() {
+	if rec := r.root().instrumentation; rec != nil {
+		rec.mu.Lock()
+		rec.stats = make(map[layerKey]*LayerStats)
+		rec.mu.Unlock()
+	}
+}
+
+type layerKey struct {
+	method, route, layer string
+	position             int
+}
+
+type layerStatsRecorder struct {
+	mu    sync.Mutex
+	stats map[layerKey]*LayerStats
+}
+
+func (rec *layerStatsRecorder) record(method, route string, t *layerTimer) {
+	now := time.Now()
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for i, d := range t.durations(now) {
+		key := layerKey{method, route, t.frames[i].name, i}
+		s, ok := rec.stats[key]
+		if !ok {
+			s = &LayerStats{Method: method, Route: route, Layer: key.layer, Position: i}
+			rec.stats[key] = s
+		}
+		s.Count++
+		s.Total += d
+		if d > s.Max {
+			s.Max = d
+		}
+	}
+}
+
+// layerTimer times the layers of a request
+type layerTimer struct {
+	mu     sync.Mutex
+	frames []layerFrame
+	stack  []int
+}
+
+type layerFrame struct {
+	name   string
+	start  time.Time
+	end    time.Time
+	parent int
+}
+
+func (t *layerTimer) observe(r *http.Request, layer string) (*http.Request, func()) {
+	t.mu.Lock()
+	parent := -1
+	if len(t.stack) > 0 {
+		parent = t.stack[len(t.stack)-1]
+	}
+	i := len(t.frames)
+	t.frames = append(t.frames, layerFrame{name: layer, start: time.Now(), parent: parent})
+	t.stack = append(t.stack, i)
+	t.mu.Unlock()
+
+	return r, func() {
+		t.mu.Lock()
+		t.frames[i].end = time.Now()
+		if n := len(t.stack); n > 0 && t.stack[n-1] == i {
+			t.stack = t.stack[:n-1]
+		}
+		t.mu.Unlock()
+	}
+}
+
+// durations returns the exclusive duration of each layer at now, layers still running being timed until now
+func (t *layerTimer) durations(now time.Time) []time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	totals := make([]time.Duration, len(t.frames))
+	for i, f := range t.frames {
+		end := f.end
+		if end.IsZero() {
+			end = now
+		}
+		totals[i] = end.Sub(f.start)
+	}
+	durations := make([]time.Duration, len(t.frames))
+	copy(durations, totals)
+	for i, f := range t.frames {
+		if f.parent >= 0 {
+			durations[f.parent] -= totals[i]
+		}
+	}
+	return durations
+}
+
+// serverTiming returns the Server-Timing header of the layers at now
+func (t *layerTimer) serverTiming(now time.Time) string {
+	durations := t.durations(now)
+	metrics := make([]string, len(durations))
+	for i, d := range durations {
+		name := t.frames[i].name
+		metrics[i] = fmt.Sprintf("%s;desc=%q;dur=%.3f", serverTimingToken(i, name), name, float64(d)/float64(time.Millisecond))
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// serverTimingToken returns a valid Server-Timing metric name for the layer at position i
+func serverTimingToken(i int, name string) string {
+	token := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("!#$%&'*+-.^_`|~", r):
+			return r
+		}
+		return '_'
+	}, name)
+	return fmt.Sprintf("%d-%s", i, token)
+}
+
+// serverTimingWriter sets the Server-Timing header before the response headers are written
+type serverTimingWriter struct {
+	http.ResponseWriter
+	timer       *layerTimer
+	wroteHeader bool
+}
+
+func (w *serverTimingWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set("Server-Timing", w.timer.serverTiming(time.Now()))
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *serverTimingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *serverTimingWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *serverTimingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("lion: %T does not implement http.Hijacker", w.ResponseWriter)
+}
+
+func (w *serverTimingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,105 @@
+package lion
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sleepyMiddleware struct {
+	d time.Duration
+}
+
+func (m sleepyMiddleware) ServeNext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(m.d)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestInstrumentation(t *testing.T) {
+	l := New()
+	l.Configure(WithInstrumentation(true))
+	l.Define("auth", sleepyMiddleware{5 * time.Millisecond})
+	l.Use(observedMiddleware{})
+	l.UseNamed("auth")
+	l.GetFunc("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("user"))
+	})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, httptest.NewRequest("GET", "/users/42", nil))
+		timing := w.Header().Get("Server-Timing")
+		for _, metric := range []string{`0-lion.observedMiddleware;desc="lion.observedMiddleware";dur=`, `1-auth_lion.sleepyMiddleware;desc="auth:lion.sleepyMiddleware";dur=`, `2-handler;desc="handler";dur=`} {
+			if !strings.Contains(timing, metric) {
+				t.Errorf("Expected Server-Timing to contain %s: %s", metric, timing)
+			}
+		}
+	}
+
+	stats := l.LayerStats()
+	if len(stats) != 3 {
+		t.Fatalf("Expected stats for 3 layers but got %v", stats)
+	}
+	for i, layer := range []string{"lion.observedMiddleware", "auth:lion.sleepyMiddleware", "handler"} {
+		if s := stats[i]; s.Layer != layer || s.Route != "/users/:id" || s.Method != "GET" || s.Count != 2 {
+			t.Errorf("Incorrect stats: %+v", s)
+		}
+	}
+	if auth := stats[1].Mean(); auth < 5*time.Millisecond || auth >= 20*time.Millisecond {
+		t.Errorf("The duration of a layer should exclude the next layers: %v", auth)
+	}
+	if handler := stats[2]; handler.Mean() < 20*time.Millisecond || handler.Max < handler.Mean() {
+		t.Errorf("Incorrect handler stats: %+v", handler)
+	}
+
+	l.ResetLayerStats()
+	if len(l.LayerStats()) != 0 {
+		t.Errorf("Stats should be cleared")
+	}
+}
+
+func TestInstrumentationAny(t *testing.T) {
+	l := New(observedMiddleware{})
+	l.Configure(WithInstrumentation(true))
+	l.AnyFunc("/any", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("any"))
+	})
+	l.ANY("/ctx", func(c Context) {
+		c.WithStatus(http.StatusOK).String("any")
+	})
+
+	for _, path := range []string{"/any", "/ctx"} {
+		for _, method := range []string{"GET", "POST", "DELETE"} {
+			w := httptest.NewRecorder()
+			l.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+			if timing := w.Header().Get("Server-Timing"); !strings.Contains(timing, `1-handler;desc="handler";dur=`) {
+				t.Errorf("%s %s: expected Server-Timing to contain the handler: %s", method, path, timing)
+			}
+		}
+	}
+
+	handlers := 0
+	for _, s := range l.LayerStats() {
+		if s.Layer == "handler" && s.Count == 1 {
+			handlers++
+		}
+	}
+	if handlers != 6 {
+		t.Errorf("Expected handler stats for 6 routes and methods but got %v", l.LayerStats())
+	}
+}
+
+func TestInstrumentationDisabled(t *testing.T) {
+	l := New(observedMiddleware{})
+	l.GetFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("Server-Timing") != "" || l.LayerStats() != nil {
+		t.Errorf("The instrumentation should be opt-in")
+	}
+}
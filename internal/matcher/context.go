@@ -12,7 +12,15 @@ type Context interface {
 	AddParam(key, value string)
 	Remove(key string)
 	Reset()
-	SearchHistory() []string
+}
+
+// Tracer can be implemented by a Context to follow the nodes visited while matching a path.
+// Parameters added and removed along the way go through the Context's AddParam and Remove methods.
+type Tracer interface {
+	// Visit is called when entering a node with the path remaining to be matched
+	Visit(node, search string)
+	// Backtrack is called when going back to a parent node to try another child
+	Backtrack(node, search string)
 }
 
 type ctx struct {
@@ -34,10 +42,6 @@ func NewContextWithParent(c context.Context) Context {
 	}
 }
 
-func (c *ctx) SearchHistory() []string {
-	return []string{}
-}
-
 // Value returns the value for the passed key. If it is not found in the url params it returns parent's context Value
 func (p *ctx) Value(key interface{}) interface{} {
 	if k, ok := key.(string); ok {
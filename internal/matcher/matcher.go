@@ -97,8 +97,7 @@ func (m *matcher) findDuplicateParamNames(n *node, pattern string, pnames []stri
 		m.findDuplicateParamNames(sc, pattern, pnames)
 	}
 
-	if n.paramChild != nil {
-		nn := n.paramChild
+	for _, nn := range n.paramChildren {
 		m.validateParamNode(nn, pattern, pnames)
 		m.findDuplicateParamNames(nn, pattern, append(pnames, nn.pname))
 	}
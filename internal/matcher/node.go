@@ -26,7 +26,7 @@ type node struct {
 	parent *node
 
 	staticChildren nodes
-	paramChild     *node
+	paramChildren  nodes // params with a regex first, then the one without regex
 	anyChild       *node
 }
 
@@ -35,12 +35,12 @@ func (n *node) longestPrefix(pattern string) int {
 }
 
 func (n *node) children() nodes {
-	children := make([]*node, 0, len(n.staticChildren)+2)
+	children := make([]*node, 0, len(n.staticChildren)+len(n.paramChildren)+1)
 	for _, staticChild := range n.staticChildren {
 		children = append(children, staticChild)
 	}
-	if n.paramChild != nil {
-		children = append(children, n.paramChild)
+	for _, paramChild := range n.paramChildren {
+		children = append(children, paramChild)
 	}
 	if n.anyChild != nil {
 		children = append(children, n.anyChild)
@@ -105,14 +105,56 @@ func (n *node) getStaticChild(label byte) (child *node, ok bool) {
 	return nil, false
 }
 
+// getParamChild returns the param child equivalent to cn, which has the same name and the same regex.
+// It panics if a param child with the same regex but a different name exists since they could not be distinguished.
+func (n *node) getParamChild(cn *node) *node {
+	for _, pc := range n.paramChildren {
+		if pc.regexString() != cn.regexString() {
+			continue
+		}
+
+		// Check conflicting parameter name
+		if pc.pname != cn.pname {
+			panicm("Conflicting parameter name '%s' with '%s' for pattern: '%s'",
+				pc.pname, cn.pname, pc.path())
+		}
+		return pc
+	}
+	return nil
+}
+
+// addParamChild adds a param child.
+// Params with a regex are tried first, in the order they have been added.
+// The param without regex, if any, is always tried last.
+func (n *node) addParamChild(child *node) {
+	i := len(n.paramChildren)
+	if child.re != nil {
+		for i > 0 && n.paramChildren[i-1].re == nil {
+			i--
+		}
+	}
+
+	n.paramChildren = append(n.paramChildren, nil)
+	copy(n.paramChildren[i+1:], n.paramChildren[i:])
+	n.paramChildren[i] = child
+	n.calculatePriority()
+}
+
+func (n *node) regexString() string {
+	if n.re == nil {
+		return ""
+	}
+	return n.re.String()
+}
+
 func (n *node) calculatePriority() int {
 	n.priority = 1
 	for _, sc := range n.staticChildren {
 		n.priority += sc.calculatePriority()
 	}
 
-	if n.paramChild != nil {
-		n.priority += n.paramChild.calculatePriority()
+	for _, pc := range n.paramChildren {
+		n.priority += pc.calculatePriority()
 	}
 
 	if n.anyChild != nil {
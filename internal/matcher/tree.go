@@ -86,152 +86,175 @@ func (t *tree) isLeaf(n *node, tags Tags) bool {
 	return t.getValue(n, tags) != nil
 }
 
-func (tree *tree) findNode(c Context, path string, tags Tags) (out *node, err error) {
-	n := tree.root
-	search := path
-	searchHistory := c.SearchHistory()
-	for {
+func (tree *tree) findNode(c Context, path string, tags Tags) (*node, error) {
+	tracer, _ := c.(Tracer)
+	return tree.match(c, tracer, tree.root, path)
+}
 
-		if search == "" && n.store != nil {
-			out = n
-			break
-		}
+// match looks for the node matching search among n's descendants.
+// Static children are tried first, then param children in order and finally the wildcard child.
+// When a child does not lead to a match, the params it added are removed and the next one is tried.
+func (tree *tree) match(c Context, tracer Tracer, n *node, search string) (*node, error) {
+	if search == "" && n.store != nil {
+		return n, nil
+	}
 
-		var label byte
-		if search != "" {
-			label = search[0]
+	sep := tree.MainSeparators()[0]
+
+	var label byte
+	if search != "" {
+		label = search[0]
+	}
+
+	// We check if there is a present route starting with label byte
+	if nn, ok := n.getStaticChild(label); ok && stringsHasPrefix(search, nn.pattern) {
+		tree.visit(tracer, nn, search)
+
+		rest := search[len(nn.pattern):]
+		if rest == tree.MainSeparators() {
+			return nil, ErrTSR
 		}
 
-		// We check if there is a present route starting with label byte
-		if nn, ok := n.getStaticChild(label); ok && stringsHasPrefix(search, nn.pattern) {
+		if out, err := tree.match(c, tracer, nn, rest); out != nil || err != nil {
+			return out, err
+		}
 
-			n = nn
+		// Case where the current path starts with and is longer than the found node's (nn) static path
+		// Check the tests, for example if we define:
+		// 		/hello/contact/named
+		// 		/hello/contact/:param
+		// and the user tries to fetch:
+		// 		/hello/contact/nameddd
+		// it should go the second registered pattern (the one that has :param)
+		tree.backtrack(tracer, n, search)
+	} else if ok && nn.endinglabel == sep && len(search) < len(nn.pattern) && search == nn.pattern[:len(nn.pattern)-1] && nn.store != nil {
+		return nil, ErrTSR
+	}
 
-			searchHistory = append(searchHistory, search)
-			search = search[len(nn.pattern):]
-			if search == tree.MainSeparators() {
-				err = ErrTSR
-				break
-			}
+	// Param children are sorted: the ones with a regex come first
+	for _, pn := range n.paramChildren {
+		pval, p, ok := tree.paramValue(pn, search)
+		if !ok {
 			continue
-		} else if ok && nn.endinglabel == tree.MainSeparators()[0] && len(search) < len(nn.pattern) && search == nn.pattern[:len(nn.pattern)-1] && nn.store != nil {
-			err = ErrTSR
-			break
 		}
 
-	PARAM:
-		// If there is a param child then we go for it.
-		if n.paramChild != nil {
-			pn := n.paramChild
-			p := -1
-
-			var pval string
-			if pn.re == nil { // normal parameter
-				var char byte
-				if pn.endinglabel > 0 {
-					char = pn.endinglabel
-				} else {
-					char = tree.MainSeparators()[0]
-				}
+		c.AddParam(pn.pname, pval)
+		tree.visit(tracer, pn, search)
 
-				p = stringsIndex(search, char)
-				if p < 0 {
-					p = len(search)
-				}
-
-				pval = tree.cfg.ParamTransformer.Transform(search[:p])
-			} else { // regex
-				pval = pn.re.FindString(tree.cfg.ParamTransformer.Transform(search))
-				p = len(pval)
+		rest := search[p:]
+		if rest == tree.MainSeparators() {
+			// here we only have a '/' left, so we check if we have a static child with a '/' label that have a wildcard child
+			if staticChild, ok := pn.getStaticChild(sep); !ok || staticChild.anyChild == nil {
+				return nil, ErrTSR
 			}
+		}
 
-			c.AddParam(pn.pname, pval)
-			searchHistory = append(searchHistory, search)
+		if out, err := tree.match(c, tracer, pn, rest); out != nil || err != nil {
+			return out, err
+		}
 
-			n = n.paramChild
-			search = search[p:]
+		// This param does not lead to a route, we remove it and try the next one
+		c.Remove(pn.pname)
+		tree.backtrack(tracer, n, search)
+	}
 
-			if search == tree.MainSeparators() {
-				// here we only have a '/' left, so we check if we have a static child with a '/' label that have a wildcard child
-				if staticChild, ok := n.getStaticChild(tree.MainSeparators()[0]); ok && staticChild.anyChild != nil {
-					continue
-				}
+	// Finally, if there is a wildcard child then we go for it.
+	if an := n.anyChild; an != nil {
+		return tree.matchWildcard(c, tracer, n, an, search)
+	}
 
-				err = ErrTSR
-				break
+	return nil, nil
+}
+
+// matchWildcard tries to match search with the wildcard node an.
+// If an has children (a suffix), the longest value followed by something matching one of them is chosen.
+// Otherwise, or if none of them matches, the wildcard captures the rest of search.
+func (tree *tree) matchWildcard(c Context, tracer Tracer, n, an *node, search string) (*node, error) {
+	var tsr error
+	// Since a wildcard's name ends at a separator, its children are always static
+	if len(an.staticChildren) > 0 {
+		for p := len(search) - 1; p >= 0; p-- {
+			if _, ok := an.getStaticChild(search[p]); !ok {
+				continue
+			}
+
+			out, err := tree.matchWildcardValue(c, tracer, n, an, search, p)
+			if out != nil {
+				return out, nil
+			}
+			if err != nil {
+				tsr = err
 			}
-			continue
 		}
+	}
 
-	WILDCARD:
-		// If there is a wildcard child then we go for it.
-		if n.anyChild != nil {
-			n = n.anyChild
+	out, err := tree.matchWildcardValue(c, tracer, n, an, search, len(search))
+	if out != nil {
+		return out, nil
+	}
+	if err != nil {
+		tsr = err
+	}
+	return nil, tsr
+}
 
-			pval := tree.cfg.ParamTransformer.Transform(search)
-			c.AddParam(n.pname, pval)
+// matchWildcardValue matches search with the wildcard node an capturing the first p bytes
+func (tree *tree) matchWildcardValue(c Context, tracer Tracer, n, an *node, search string, p int) (*node, error) {
+	c.AddParam(an.pname, tree.cfg.ParamTransformer.Transform(search[:p]))
+	tree.visit(tracer, an, search)
 
-			searchHistory = append(searchHistory, search)
-			search = search[len(search):]
-			continue
-		}
+	out, err := tree.match(c, tracer, an, search[p:])
+	if out != nil {
+		return out, nil
+	}
 
-		if search == "" && n.store != nil {
-			nn, ok := n.getStaticChild(tree.MainSeparators()[0])
-			if ok && nn.store != nil {
-				err = ErrTSR
-				break
-			}
-		}
+	c.Remove(an.pname)
+	tree.backtrack(tracer, n, search)
+	return nil, err
+}
 
-		// Case where the current path starts with and is longer than the found node's (nn) static path
-		// Check the tests, for example if we define:
-		// 		/hello/contact/named
-		// 		/hello/contact/:param
-		// and the user tries to fetch:
-		// 		/hello/contact/nameddd
-		// it should go the second registered pattern (the one that has :param)
-		if search != "" && n.parent != nil && n.nodeType == static {
-			if n.parent.paramChild != nil && len(searchHistory) > 0 {
-				// Going to parent
-				n = n.parent
-
-				// Rollback search
-				search = searchHistory[len(searchHistory)-1]
-				searchHistory = searchHistory[:len(searchHistory)-1]
-				goto PARAM
-			}
+// paramValue returns the value captured by the param node pn and the length of search it consumes.
+// It returns false if pn has a regex that does not match search.
+func (tree *tree) paramValue(pn *node, search string) (string, int, bool) {
+	if pn.re != nil { // regex
+		loc := pn.re.FindStringIndex(tree.cfg.ParamTransformer.Transform(search))
+		if loc == nil {
+			return "", 0, false
 		}
-
-		// Finally if we were previously in a param node and there is no matched routes.
-		// We go back to the parent node and the previous search path.
-		// We then jump to the parent's wildcard node.
-		// If there was a previously registered param in the previous param node, we remove it.
-		if search != "" && n.parent != nil {
-			// Walk back up the tree to find if there is a wildcard node
-			for n.anyChild == nil && n.parent != nil && len(searchHistory) > 0 {
-				prevparam := n.pname
-
-				// Going to parent
-				n = n.parent
-
-				// Rollback search
-				search = searchHistory[len(searchHistory)-1]
-				searchHistory = searchHistory[:len(searchHistory)-1]
-
-				// Remove parameters added along the way
-				if prevparam != "" {
-					c.Remove(prevparam)
-				}
-				if n.anyChild != nil {
-					goto WILDCARD
-				}
-			}
+		// The value must correspond to the beginning of search once transformed back
+		p := loc[1] - loc[0]
+		pval := tree.cfg.ParamTransformer.Transform(search[:p])
+		if pn.re.FindString(pval) != pval {
+			return "", 0, false
 		}
-		break
+		return pval, p, true
+	}
+
+	// normal parameter
+	var char byte
+	if pn.endinglabel > 0 {
+		char = pn.endinglabel
+	} else {
+		char = tree.MainSeparators()[0]
+	}
+
+	p := stringsIndex(search, char)
+	if p < 0 {
+		p = len(search)
 	}
+	return tree.cfg.ParamTransformer.Transform(search[:p]), p, true
+}
+
+func (tree *tree) visit(tracer Tracer, n *node, search string) {
+	if tracer != nil {
+		tracer.Visit(n.path(), search)
+	}
+}
 
-	return out, err
+func (tree *tree) backtrack(tracer Tracer, n *node, search string) {
+	if tracer != nil {
+		tracer.Backtrack(n.path(), search)
+	}
 }
 
 func (tree *tree) addRoute(n *node, pattern string, values interface{}, tags Tags) Store {
@@ -243,18 +266,14 @@ func (tree *tree) addRoute(n *node, pattern string, values interface{}, tags Tag
 	CONTINUE:
 		switch {
 		case cn.nodeType == param:
-			if n.paramChild == nil {
-				n.paramChild = cn
-			} else {
-				// Check conflicting parameter name
-				if n.paramChild.pname != cn.pname {
-					panicm("Conflicting parameter name '%s' with '%s' for pattern: '%s'",
-						n.paramChild.pname, cn.pname, n.paramChild.path())
-				}
+			pn := n.getParamChild(cn)
+			if pn == nil {
+				cn.parent = n
+				n.addParamChild(cn)
+				pn = cn
 			}
 
-			cn.parent = n
-			n = n.paramChild
+			n = pn
 
 			lcp := n.longestPrefix(pattern)
 			pattern = pattern[lcp:]
@@ -436,19 +455,23 @@ func (tree *tree) split(pattern string) (out []*node) {
 
 			out = append(out, child)
 		case tree.WildcardChar():
-			pname := pattern[1:]
+			// The wildcard's name ends at the next separator, what follows is a suffix
+			end = strings.IndexAny(pattern[1:], tree.Separators()) + 1
+			if end == 0 {
+				end = len(pattern)
+			}
+
+			pname := pattern[1:end]
 			if pname == "" {
 				pname = "*"
 			}
 			child = &node{
-				pattern:  pattern,
+				pattern:  pattern[:end],
 				nodeType: wildcard,
 				pname:    pname,
 			}
 
 			out = append(out, child)
-			pattern = ""
-			continue
 		default:
 			charIdx := stringsIndexAnyNotEscaped(pattern, tree.AllChars())
 			if charIdx < 0 {
@@ -496,9 +519,9 @@ func (tree *tree) printTree(n *node, decalage int) (out string) {
 	for _, sc := range n.staticChildren {
 		out += tree.printTree(sc, decalage+1)
 	}
-	if n.paramChild != nil {
+	for _, pn := range n.paramChildren {
 		out += dec + "\tParam Node\n"
-		out += tree.printTree(n.paramChild, decalage+1)
+		out += tree.printTree(pn, decalage+1)
 	}
 	if n.anyChild != nil {
 		out += dec + "\tAny Node\n"
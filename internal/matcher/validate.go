@@ -0,0 +1,133 @@
+package matcher
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// Overlap describes a static path that would also be matched by a sibling regex parameter.
+// Since static nodes are tried first, the parameter can never capture that value.
+type Overlap struct {
+	Static string
+	Param  string
+}
+
+// Find returns the store of the node matching path, regardless of its tags.
+// It returns ErrTSR if the path would be redirected and ErrNotFound if no node matches.
+func Find(ma Matcher, c Context, path string) (Store, error) {
+	m := ma.(*matcher)
+	n, err := m.tree.findNode(c, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, ErrNotFound
+	}
+	return n.store, nil
+}
+
+// Example builds a path that pattern should match.
+// Each parameter is replaced by a sample value: the shortest string matching its regex if it has one,
+// an underscore followed by its name otherwise. Wildcards are replaced by two such segments.
+func Example(ma Matcher, pattern string) (string, error) {
+	m := ma.(*matcher)
+	params := map[string]string{}
+	for _, n := range m.tree.split(pattern) {
+		switch n.nodeType {
+		case param:
+			if n.re == nil {
+				params[n.pname] = "_" + n.pname
+				continue
+			}
+			sample, err := shortestMatch(n.re)
+			if err != nil {
+				return "", err
+			}
+			params[n.pname] = sample
+		case wildcard:
+			// Use several segments since a single one could be legitimately captured by a parameter
+			segment := "_" + strings.TrimLeft(n.pname, string(m.tree.WildcardChar()))
+			params[n.pname] = segment + m.tree.MainSeparators() + segment
+		}
+	}
+	return m.Eval(pattern, params)
+}
+
+// Overlaps walks the tree and returns every static path that is also matched entirely by a regex parameter registered at the same position.
+func Overlaps(ma Matcher) []Overlap {
+	m := ma.(*matcher)
+	return m.tree.overlaps(m.tree.root, nil)
+}
+
+func (t *tree) overlaps(n *node, out []Overlap) []Overlap {
+	for _, pn := range n.paramChildren {
+		if pn.re == nil {
+			continue
+		}
+		for _, sc := range n.staticChildren {
+			t.staticSegments(sc, "", func(segment string, leaf *node) {
+				if pn.re.FindString(segment) == segment {
+					out = append(out, Overlap{Static: leaf.path(), Param: pn.path()})
+				}
+			})
+		}
+	}
+
+	for _, c := range n.children() {
+		out = t.overlaps(c, out)
+	}
+	return out
+}
+
+// staticSegments calls fn for each static node holding a value that can be reached from n without crossing a separator.
+func (t *tree) staticSegments(n *node, prefix string, fn func(segment string, leaf *node)) {
+	segment := prefix + n.pattern
+	if stringsIndexAny(segment, t.MainSeparators()) >= 0 {
+		return
+	}
+	if n.store != nil {
+		fn(segment, n)
+	}
+	for _, sc := range n.staticChildren {
+		t.staticSegments(sc, segment, fn)
+	}
+}
+
+// shortestMatch returns a short string matched entirely by re.
+func shortestMatch(re *regexp.Regexp) (string, error) {
+	r, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	return sampleRegexp(r.Simplify()), nil
+}
+
+func sampleRegexp(r *syntax.Regexp) string {
+	switch r.Op {
+	case syntax.OpLiteral:
+		return string(r.Rune)
+	case syntax.OpCharClass:
+		if len(r.Rune) > 0 {
+			return string(r.Rune[0])
+		}
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		return "a"
+	case syntax.OpCapture:
+		return sampleRegexp(r.Sub[0])
+	case syntax.OpPlus:
+		return sampleRegexp(r.Sub[0])
+	case syntax.OpRepeat:
+		return strings.Repeat(sampleRegexp(r.Sub[0]), r.Min)
+	case syntax.OpConcat:
+		var s string
+		for _, sub := range r.Sub {
+			s += sampleRegexp(sub)
+		}
+		return s
+	case syntax.OpAlternate:
+		return sampleRegexp(r.Sub[0])
+	}
+	// OpStar, OpQuest, OpEmptyMatch and anchors match the empty string
+	return ""
+}
@@ -50,9 +50,7 @@ func (d *pathMatcher) Register(method, pattern string, handler http.Handler) *ro
 func (d *pathMatcher) Match(c *ctx, r *http.Request) (*ctx, http.Handler) {
 	p := cleanPath(r.URL.Path)
 
-	c.tags[0] = r.Method
-
-	h, err := d.matcher.GetWithContext(c, p, c.tags)
+	store, err := matcher.Find(d.matcher, c, p)
 	if err == matcher.ErrTSR {
 		if p[len(p)-1] == '/' {
 			p = p[:len(p)-1]
@@ -66,25 +64,28 @@ func (d *pathMatcher) Match(c *ctx, r *http.Request) (*ctx, http.Handler) {
 		})
 	}
 
-	if err == matcher.ErrNotFound {
+	rt, ok := store.(*route)
+	if err == matcher.ErrNotFound || !ok || rt == nil {
 		return c, nil
 	}
+	c.route = rt
 
-	if err == matcher.ErrTagsNotAllowed {
+	if h := rt.getHandler(r.Method); h != nil {
+		return c, h
+	}
 
-		// Automatic OPTIONS
-		if r.Method == OPTIONS {
-			hh := d.automaticOptionsHandler(c, p)
-			return c, hh
+	// Automatic OPTIONS
+	if r.Method == OPTIONS {
+		if len(rt.Methods()) == 0 { // There is no method allowed
+			return c, nil
 		}
-
-		// Method not allowed
-		return c, wrap(func(c Context) {
-			c.Error(ErrorMethodNotAllowed)
-		})
+		return c, rt.automaticOptions
 	}
 
-	return c, h.(http.Handler)
+	// Method not allowed
+	return c, wrap(func(c Context) {
+		c.Error(ErrorMethodNotAllowed)
+	})
 }
 
 func (d *pathMatcher) prevalidation(method, pattern string) {
@@ -98,29 +99,19 @@ func (d *pathMatcher) prevalidation(method, pattern string) {
 	}
 }
 
-func (d *pathMatcher) automaticOptionsHandler(c *ctx, path string) http.Handler {
-	allowed := make([]string, 0, len(allowedHTTPMethods))
-	for _, method := range allowedHTTPMethods {
-		if method == OPTIONS {
-			continue
-		}
-
-		c.tags[0] = method
-		h, _ := d.matcher.GetWithContext(c, path, c.tags)
-		if h != nil {
-			allowed = append(allowed, method)
+// automaticOptionsHandler answers OPTIONS requests on routes that do not have an OPTIONS handler.
+// The allowed methods are listed in the Accept header.
+func automaticOptionsHandler(rt *route) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := make([]string, 0, len(allowedHTTPMethods))
+		for _, method := range allowedHTTPMethods {
+			if method != OPTIONS && rt.getHandler(method) != nil {
+				allowed = append(allowed, method)
+			}
 		}
-	}
+		allowed = append(allowed, OPTIONS)
 
-	if len(allowed) == 0 { // There is no method allowed
-		return nil
-	}
-
-	allowed = append(allowed, OPTIONS)
-
-	joined := strings.Join(allowed, ",")
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Accept", joined)
+		w.Header().Set("Accept", strings.Join(allowed, ","))
 		w.WriteHeader(http.StatusOK)
 	})
 }
@@ -0,0 +1,304 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celrenheit/lion"
+)
+
+type ctxAccessLogKeyType int
+
+const ctxAccessLogKey ctxAccessLogKeyType = 0
+
+// AccessLogFormat is the output format of an AccessLogger
+type AccessLogFormat int
+
+const (
+	// AccessLogJSON writes one JSON object per request
+	AccessLogJSON AccessLogFormat = iota
+	// AccessLogLogfmt writes one logfmt line per request
+	AccessLogLogfmt
+	// AccessLogCommon writes requests in the Common Log Format
+	AccessLogCommon
+	// AccessLogCombined writes requests in the Combined Log Format
+	AccessLogCombined
+)
+
+// AccessLogField selects the optional fields logged by an AccessLogger in the JSON and logfmt formats and with slog handlers.
+// The method, path and status are always logged.
+type AccessLogField int
+
+const (
+	AccessLogRequestID AccessLogField = 1 << iota
+	AccessLogRoute
+	AccessLogBytes
+	AccessLogLatency
+	AccessLogRemoteAddr
+	AccessLogUserAgent
+	AccessLogReferer
+	// AccessLogSubject logs the subject of the token validated by the JWT middleware
+	AccessLogSubject
+
+	// DefaultAccessLogFields are the fields logged by NewAccessLogger
+	DefaultAccessLogFields = AccessLogRequestID | AccessLogRoute | AccessLogBytes | AccessLogLatency | AccessLogRemoteAddr | AccessLogSubject
+)
+
+// AccessLogger is a middleware writing one structured record per request.
+//
+// Records are written to Output in Format, or passed to Handler if set, at the Info level, Warn for 4xx responses and Error for 5xx.
+// The matched route is logged, unlike Logger which logs the path only, so the AccessLogger must be used in the router or the group whose routes are logged.
+type AccessLogger struct {
+	// Format is the output format. Fields is ignored for the Common and Combined formats.
+	Format AccessLogFormat
+	// Output is where records are written, os.Stdout by default
+	Output io.Writer
+	// Handler, if set, receives the records instead of Output
+	Handler slog.Handler
+	// Fields selects the optional fields to log
+	Fields AccessLogField
+
+	// Sampler, if set, reports whether a request is logged, see AccessLogSampleRate
+	Sampler func(r *http.Request, status int) bool
+	// ExcludeRoutes lists the names or patterns of the routes which are not logged, e.g. health checks
+	ExcludeRoutes []string
+	// Exclude, if set, reports whether a request is not logged
+	Exclude func(r *http.Request) bool
+
+	once   sync.Once
+	logger *slog.Logger
+	mu     sync.Mutex
+}
+
+// NewAccessLogger creates an AccessLogger writing the DefaultAccessLogFields to os.Stdout in format
+func NewAccessLogger(format AccessLogFormat) *AccessLogger {
+	return &AccessLogger{
+		Format: format,
+		Output: os.Stdout,
+		Fields: DefaultAccessLogFields,
+	}
+}
+
+// AccessLogSampleRate returns a sampler logging a fraction rate of the requests, and every request whose status is 5xx
+func AccessLogSampleRate(rate float64) func(r *http.Request, status int) bool {
+	return func(r *http.Request, status int) bool {
+		return status >= 500 || rand.Float64() < rate
+	}
+}
+
+// accessLogState is shared with inner middlewares through the request context
+type accessLogState struct {
+	subject   string
+	requestID string
+}
+
+// setAccessLogSubject records the subject of the authenticated client of r for the AccessLogger, if any
+func setAccessLogSubject(r *http.Request, subject string) {
+	if st, ok := r.Context().Value(ctxAccessLogKey).(*accessLogState); ok {
+		st.subject = subject
+	}
+}
+
+// setAccessLogRequestID records the ID of r for the AccessLogger, if any
+func setAccessLogRequestID(r *http.Request, id string) {
+	if st, ok := r.Context().Value(ctxAccessLogKey).(*accessLogState); ok {
+		st.requestID = id
+	}
+}
+
+// ServeNext implements the Middleware interface for AccessLogger.
+func (l *AccessLogger) ServeNext(next http.Handler) http.Handler {
+	l.once.Do(l.init)
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if (l.Exclude != nil && l.Exclude(r)) || matchRoute(r, l.ExcludeRoutes) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		st := &accessLogState{}
+		r = r.WithContext(context.WithValue(r.Context(), ctxAccessLogKey, st))
+		res := wrapResponseWriter(w)
+		start := time.Now()
+
+		next.ServeHTTP(res, r)
+
+		status := res.Status()
+		if status == 0 {
+			// Nothing written, net/http responds with 200
+			status = http.StatusOK
+		}
+		if l.Sampler != nil && !l.Sampler(r, status) {
+			return
+		}
+		latency := time.Since(start)
+		switch {
+		case l.Handler == nil && l.Format == AccessLogCommon:
+			l.writeLine(clfLine(r, st, res, status, start))
+		case l.Handler == nil && l.Format == AccessLogCombined:
+			l.writeLine(fmt.Sprintf("%s %q %q", clfLine(r, st, res, status, start), r.Referer(), r.UserAgent()))
+		default:
+			l.logger.LogAttrs(r.Context(), accessLogLevel(status), "request", l.attrs(r, st, res, status, latency)...)
+		}
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func (l *AccessLogger) init() {
+	h := l.Handler
+	if h == nil {
+		out := l.Output
+		if out == nil {
+			out = os.Stdout
+		}
+		if l.Format == AccessLogLogfmt {
+			h = slog.NewTextHandler(out, nil)
+		} else {
+			h = slog.NewJSONHandler(out, nil)
+		}
+	}
+	l.logger = slog.New(h)
+}
+
+func (l *AccessLogger) writeLine(line string) {
+	out := l.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	l.mu.Lock()
+	io.WriteString(out, line+"\n")
+	l.mu.Unlock()
+}
+
+func (l *AccessLogger) attrs(r *http.Request, st *accessLogState, res ResponseWriter, status int, latency time.Duration) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", status),
+	}
+	if l.Fields&AccessLogRequestID != 0 {
+		if id := accessLogRequestID(r, st); id != "" {
+			attrs = append(attrs, slog.String("request_id", id))
+		}
+	}
+	if l.Fields&AccessLogRoute != 0 {
+		if c := lion.C(r); c != nil && c.Route() != nil {
+			attrs = append(attrs, slog.String("route", c.Route().Pattern()))
+			if name := c.Route().Name(); name != "" {
+				attrs = append(attrs, slog.String("route_name", name))
+			}
+		}
+	}
+	if l.Fields&AccessLogBytes != 0 {
+		attrs = append(attrs, slog.Int("bytes", res.BytesWritten()))
+	}
+	if l.Fields&AccessLogLatency != 0 {
+		attrs = append(attrs, slog.Duration("latency", latency))
+	}
+	if l.Fields&AccessLogRemoteAddr != 0 {
+		attrs = append(attrs, slog.String("remote_addr", r.RemoteAddr))
+	}
+	if l.Fields&AccessLogUserAgent != 0 {
+		attrs = append(attrs, slog.String("user_agent", r.UserAgent()))
+	}
+	if l.Fields&AccessLogReferer != 0 {
+		attrs = append(attrs, slog.String("referer", r.Referer()))
+	}
+	if l.Fields&AccessLogSubject != 0 && st.subject != "" {
+		attrs = append(attrs, slog.String("subject", st.subject))
+	}
+	return attrs
+}
+
+// accessLogRequestID returns the ID set by the RequestID middleware, before or after the AccessLogger
+func accessLogRequestID(r *http.Request, st *accessLogState) string {
+	if id := GetRequestID(r.Context()); id != "" {
+		return id
+	}
+	return st.requestID
+}
+
+func accessLogLevel(status int) slog.Level {
+	switch {
+	case status >= 500:
+		return slog.LevelError
+	case status >= 400:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// clfLine formats a request in the Common Log Format
+func clfLine(r *http.Request, st *accessLogState, res ResponseWriter, status int, start time.Time) string {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	user := st.subject
+	if user == "" {
+		if u, _, ok := r.BasicAuth(); ok {
+			user = u
+		}
+	}
+	bytes := "-"
+	if res.BytesWritten() > 0 {
+		bytes = strconv.Itoa(res.BytesWritten())
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+		clfValue(host), clfValue(user), start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method, r.URL.RequestURI(), r.Proto, status, bytes)
+}
+
+// clfValue formats a field of a Common Log Format line.
+// As Apache does, quotes, backslashes and control characters are escaped so that
+// a client-supplied value, e.g. a Basic auth username, cannot forge log lines.
+func clfValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == ' ':
+			b.WriteByte('_')
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// matchRoute reports whether the route matched for r has one of the names or patterns in routes
+func matchRoute(r *http.Request, routes []string) bool {
+	if len(routes) == 0 {
+		return false
+	}
+	ctx := lion.C(r)
+	if ctx == nil || ctx.Route() == nil {
+		return false
+	}
+	rt := ctx.Route()
+	for _, route := range routes {
+		if route == rt.Name() || route == rt.Pattern() {
+			return true
+		}
+	}
+	return false
+}
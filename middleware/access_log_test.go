@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func newTestAccessLogRouter(logger *AccessLogger) http.Handler {
+	l := lion.New(NewRequestID(), logger, NewJWT([]byte("secret")))
+	l.GetFunc("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user"))
+	}).WithName("user")
+	l.GetFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	l.GetFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = "192.168.1.1:1234"
+		l.ServeHTTP(w, r)
+	})
+}
+
+func TestAccessLoggerJSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := NewAccessLogger(AccessLogJSON)
+	logger.Output = buf
+	logger.Fields |= AccessLogUserAgent
+	logger.ExcludeRoutes = []string{"/health"}
+	test := htest.New(t, newTestAccessLogRouter(logger))
+
+	token := signToken(t, jwt.SigningMethodHS256, "", []byte("secret"), jwt.MapClaims{"sub": "lion"})
+	test.Get("/users/42").
+		SetHeader("Authorization", "Bearer "+token).
+		SetHeader("User-Agent", "htest").
+		Do().ExpectStatus(http.StatusOK)
+	test.Get("/health").Do()
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a single JSON record: %v %s", err, buf.String())
+	}
+	expected := map[string]interface{}{
+		"level":       "INFO",
+		"msg":         "request",
+		"method":      "GET",
+		"path":        "/users/42",
+		"route":       "/users/:id",
+		"route_name":  "user",
+		"status":      float64(200),
+		"bytes":       float64(4),
+		"remote_addr": "192.168.1.1:1234",
+		"user_agent":  "htest",
+		"subject":     "lion",
+	}
+	for k, v := range expected {
+		if record[k] != v {
+			t.Errorf("Expected %s to be %v but got %v", k, v, record[k])
+		}
+	}
+	if id, _ := record["request_id"].(string); id == "" {
+		t.Errorf("No request ID")
+	}
+	if _, ok := record["referer"]; ok {
+		t.Errorf("The referer should not be logged")
+	}
+}
+
+func TestAccessLoggerLogfmt(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := NewAccessLogger(AccessLogLogfmt)
+	logger.Output = buf
+	logger.Fields = AccessLogRoute
+	logger.Sampler = AccessLogSampleRate(0)
+	test := htest.New(t, newTestAccessLogRouter(logger))
+
+	test.Get("/users/42").Do()
+	test.Get("/fail").Do()
+
+	line := buf.String()
+	if strings.Count(line, "\n") != 1 {
+		t.Fatalf("Only errors should be sampled: %s", line)
+	}
+	if !strings.Contains(line, "level=ERROR msg=request method=GET path=/fail status=500 route=/fail\n") {
+		t.Errorf("Incorrect logfmt record: %s", line)
+	}
+}
+
+func TestAccessLoggerCombined(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := NewAccessLogger(AccessLogCombined)
+	logger.Output = buf
+	test := htest.New(t, newTestAccessLogRouter(logger))
+
+	test.Get("/users/42?q=1").
+		SetHeader("Referer", "http://example.com/").
+		SetHeader("User-Agent", "htest").
+		Do()
+	test.Get("/health").Do()
+
+	re := regexp.MustCompile(`^192\.168\.1\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /users/42\?q=1 HTTP/1\.1" 200 4 "http://example\.com/" "htest"\n` +
+		`192\.168\.1\.1 - - \[.*\] "GET /health HTTP/1\.1" 200 - "" ""\n$`)
+	if !re.MatchString(buf.String()) {
+		t.Errorf("Incorrect combined log: %s", buf.String())
+	}
+}
+
+func TestAccessLoggerCommonEscaping(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := NewAccessLogger(AccessLogCommon)
+	logger.Output = buf
+	test := htest.New(t, newTestAccessLogRouter(logger))
+
+	// The username of Basic auth cannot forge another line
+	username := "a b\"\\\n10.0.0.1 - admin [01/Jan/2016] \"GET / HTTP/1.1\" 200 -\x7f"
+	test.Get("/health").
+		SetHeader("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":password"))).
+		Do()
+
+	expected := `192.168.1.1 - a_b\"\\\x0a10.0.0.1_-_admin_[01/Jan/2016]_\"GET_/_HTTP/1.1\"_200_-\x7f [`
+	if !strings.HasPrefix(buf.String(), expected) || strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("Incorrect common log: %s", buf.String())
+	}
+}
+
+func TestAccessLoggerSlogHandler(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := NewAccessLogger(AccessLogCommon)
+	logger.Fields = 0
+	logger.Handler = slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelWarn})
+	test := htest.New(t, newTestAccessLogRouter(logger))
+
+	test.Get("/users/42").Do()
+	test.Get("/fail").Do()
+
+	if line := buf.String(); !strings.HasSuffix(line, "level=ERROR msg=request method=GET path=/fail status=500\n") || strings.Count(line, "\n") != 1 {
+		t.Errorf("Incorrect slog records: %s", line)
+	}
+}
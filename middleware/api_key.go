@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+
+	"github.com/celrenheit/lion"
+)
+
+var (
+	// DefaultAPIKeyContextKey is the context key under which APIKey stores the principal associated to a key
+	DefaultAPIKeyContextKey = "user"
+	// DefaultAPIKeyHeader is the header from which APIKey reads keys by default
+	DefaultAPIKeyHeader = "X-API-Key"
+
+	// ErrAPIKeyNotFound should be returned by an APIKeyStore when a key is unknown or revoked
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// APIKeyStore finds the principal, e.g. a user or an application, owning an API key
+type APIKeyStore interface {
+	// Lookup returns the principal owning key or ErrAPIKeyNotFound
+	Lookup(ctx context.Context, key string) (interface{}, error)
+}
+
+// APIKeyStoreFunc is an adapter to use a function as an APIKeyStore
+type APIKeyStoreFunc func(ctx context.Context, key string) (interface{}, error)
+
+// Lookup calls fn(ctx, key)
+func (fn APIKeyStoreFunc) Lookup(ctx context.Context, key string) (interface{}, error) {
+	return fn(ctx, key)
+}
+
+// APIKey is a middleware authenticating requests with API keys.
+//
+// The key is read from Header, then Query, then Cookie, the empty ones being skipped.
+// The principal returned by Store is stored in the request context under ContextKey, in the same way JWT stores the claims of a token.
+// Requests without a key or with an unknown key are answered with 401 Unauthorized, unless Optional is set in which case requests without a key are passed to the next handler.
+//
+//	keys := middleware.NewMemoryAPIKeyStore(map[string]interface{}{
+//		"8d2f0c...": "billing-service",
+//	})
+//	api := l.Group("/api", middleware.NewAPIKey(keys))
+type APIKey struct {
+	// Header is the name of the header containing the key
+	Header string
+	// Query is the name of the query parameter containing the key
+	Query string
+	// Cookie is the name of the cookie containing the key
+	Cookie string
+
+	// Store validates the keys
+	Store APIKeyStore
+	// ContextKey is the key under which the principal is stored in the request context
+	ContextKey interface{}
+	// Optional lets requests without a key through. Use JWTEnsureAuthenticated(ContextKey) to protect some routes.
+	Optional bool
+
+	// ErrorHandler responds to requests which could not be authenticated.
+	// err is ErrAPIKeyNotFound for missing or unknown keys, or the error returned by Store.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// NewAPIKey creates an APIKey reading keys from the X-API-Key header and validating them with store
+func NewAPIKey(store APIKeyStore) *APIKey {
+	return &APIKey{Header: DefaultAPIKeyHeader, Store: store, ContextKey: DefaultAPIKeyContextKey}
+}
+
+// ServeNext implements the Middleware interface for APIKey.
+func (a *APIKey) ServeNext(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		key := a.extract(r)
+		if key == "" {
+			if a.Optional {
+				next.ServeHTTP(w, r)
+				return
+			}
+			a.error(w, r, ErrAPIKeyNotFound)
+			return
+		}
+
+		if a.Store == nil {
+			a.error(w, r, ErrAPIKeyNotFound)
+			return
+		}
+		principal, err := a.Store.Lookup(r.Context(), key)
+		if err == nil && principal == nil {
+			err = ErrAPIKeyNotFound
+		}
+		if err != nil {
+			a.error(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), a.ContextKey, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// EnsureAuthenticated returns a middleware rejecting requests without a valid key when APIKey is Optional
+func (a *APIKey) EnsureAuthenticated() lion.Middleware {
+	return JWTEnsureAuthenticated(a.ContextKey)
+}
+
+func (a *APIKey) extract(r *http.Request) string {
+	if a.Header != "" {
+		if key := r.Header.Get(a.Header); key != "" {
+			return key
+		}
+	}
+	if a.Query != "" {
+		if key := r.URL.Query().Get(a.Query); key != "" {
+			return key
+		}
+	}
+	if a.Cookie != "" {
+		if cookie, err := r.Cookie(a.Cookie); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func (a *APIKey) error(w http.ResponseWriter, r *http.Request, err error) {
+	if a.ErrorHandler != nil {
+		a.ErrorHandler(w, r, err)
+		return
+	}
+	if err == ErrAPIKeyNotFound {
+		writeError(w, lion.ErrorUnauthorized)
+		return
+	}
+	writeError(w, lion.ErrorInternalServer)
+}
+
+// MemoryAPIKeyStore is an APIKeyStore holding keys in memory.
+// Keys are indexed by their SHA-256 hash so that looking them up does not leak their content through timing.
+type MemoryAPIKeyStore struct {
+	keys map[[sha256.Size]byte]interface{}
+}
+
+// NewMemoryAPIKeyStore creates a MemoryAPIKeyStore from a map of keys to principals
+func NewMemoryAPIKeyStore(keys map[string]interface{}) *MemoryAPIKeyStore {
+	s := &MemoryAPIKeyStore{keys: make(map[[sha256.Size]byte]interface{}, len(keys))}
+	for key, principal := range keys {
+		s.keys[sha256.Sum256([]byte(key))] = principal
+	}
+	return s
+}
+
+// Lookup implements APIKeyStore
+func (s *MemoryAPIKeyStore) Lookup(ctx context.Context, key string) (interface{}, error) {
+	principal, ok := s.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	return principal, nil
+}
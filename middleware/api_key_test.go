@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+)
+
+func TestAPIKey(t *testing.T) {
+	store := NewMemoryAPIKeyStore(map[string]interface{}{"secret-key": "billing"})
+	hello := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello %v", r.Context().Value(DefaultAPIKeyContextKey))
+	}
+
+	l := lion.New()
+	l.Group("/api", NewAPIKey(store)).GetFunc("/", hello)
+	l.Group("/query", &APIKey{Query: "api_key", Cookie: "api_key", Store: store, ContextKey: DefaultAPIKeyContextKey}).GetFunc("/", hello)
+	optional := &APIKey{Header: DefaultAPIKeyHeader, Store: store, ContextKey: DefaultAPIKeyContextKey, Optional: true}
+	public := l.Group("/public", optional)
+	public.GetFunc("/", hello)
+	public.Group("/private", optional.EnsureAuthenticated()).GetFunc("/", hello)
+
+	test := htest.New(t, l)
+	test.Get("/api").Do().
+		ExpectStatus(http.StatusUnauthorized)
+	test.Get("/api").SetHeader("X-API-Key", "wrong").Do().
+		ExpectStatus(http.StatusUnauthorized)
+	test.Get("/api").SetHeader("X-API-Key", "secret-key").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("Hello billing")
+
+	test.Get("/query?api_key=secret-key").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("Hello billing")
+	test.Get("/query").SetHeader("Cookie", "api_key=secret-key").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("Hello billing")
+	test.Get("/query").SetHeader("X-API-Key", "secret-key").Do().
+		ExpectStatus(http.StatusUnauthorized)
+
+	test.Get("/public").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("Hello <nil>")
+	test.Get("/public/private").Do().
+		ExpectStatus(http.StatusUnauthorized)
+	test.Get("/public/private").SetHeader("X-API-Key", "secret-key").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("Hello billing")
+}
+
+func TestAPIKeyStoreError(t *testing.T) {
+	errStore := errors.New("database unavailable")
+	a := NewAPIKey(APIKeyStoreFunc(func(ctx context.Context, key string) (interface{}, error) {
+		return nil, errStore
+	}))
+
+	test := htest.New(t, a.ServeNext(fakeHandler()))
+	test.Get("/").SetHeader("X-API-Key", "key").Do().
+		ExpectStatus(http.StatusInternalServerError)
+
+	a.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if err != errStore {
+			t.Errorf("Expected the error of the store but got %v", err)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	test.Get("/").SetHeader("X-API-Key", "key").Do().
+		ExpectStatus(http.StatusServiceUnavailable)
+}
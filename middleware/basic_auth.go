@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/celrenheit/lion"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// DefaultBasicAuthContextKey is the context key under which BasicAuth stores the authenticated user name
+	DefaultBasicAuthContextKey = "user"
+
+	errHtpasswdMalformed = errors.New("Malformed htpasswd line")
+	errHtpasswdHash      = errors.New("Unsupported htpasswd hash: only bcrypt and {SHA} are supported")
+)
+
+// BasicAuthValidator validates the credentials sent by a client
+type BasicAuthValidator interface {
+	Validate(user, password string) bool
+}
+
+// BasicAuthValidatorFunc is an adapter to use a function as a BasicAuthValidator
+type BasicAuthValidatorFunc func(user, password string) bool
+
+// Validate calls fn(user, password)
+func (fn BasicAuthValidatorFunc) Validate(user, password string) bool {
+	return fn(user, password)
+}
+
+// BasicAuth is a middleware implementing HTTP Basic authentication (RFC 7617).
+//
+// The name of the authenticated user is stored in the request context under ContextKey.
+// Requests with missing or invalid credentials are answered with 401 Unauthorized and a WWW-Authenticate challenge.
+//
+//	users, err := middleware.LoadHtpasswd("/etc/lion/.htpasswd")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	admin := l.Group("/admin", middleware.NewBasicAuth(users))
+type BasicAuth struct {
+	// Realm is sent to the client in the WWW-Authenticate header. Defaults to "Restricted".
+	Realm string
+	// Validator validates the credentials
+	Validator BasicAuthValidator
+	// ContextKey is the key under which the user name is stored in the request context
+	ContextKey interface{}
+	// Handler responds to unauthenticated requests, after the WWW-Authenticate header has been set.
+	// It responds with 401 Unauthorized by default.
+	Handler http.Handler
+}
+
+// NewBasicAuth creates a BasicAuth using validator to check credentials
+func NewBasicAuth(validator BasicAuthValidator) *BasicAuth {
+	return &BasicAuth{Realm: "Restricted", Validator: validator, ContextKey: DefaultBasicAuthContextKey}
+}
+
+// ServeNext implements the Middleware interface for BasicAuth.
+func (b *BasicAuth) ServeNext(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || b.Validator == nil || !b.Validator.Validate(user, password) {
+			b.unauthorized(w, r)
+			return
+		}
+
+		if b.ContextKey != nil {
+			r = r.WithContext(context.WithValue(r.Context(), b.ContextKey, user))
+		}
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func (b *BasicAuth) unauthorized(w http.ResponseWriter, r *http.Request) {
+	realm := b.Realm
+	if realm == "" {
+		realm = "Restricted"
+	}
+	realm = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(realm)
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, realm))
+
+	if b.Handler != nil {
+		b.Handler.ServeHTTP(w, r)
+		return
+	}
+	writeError(w, lion.ErrorUnauthorized)
+}
+
+// BasicAuthUsers returns a validator checking credentials against a map of user names to plain text passwords.
+// Comparisons are made in constant time.
+func BasicAuthUsers(users map[string]string) BasicAuthValidator {
+	hashed := make(map[string][32]byte, len(users))
+	for user, password := range users {
+		hashed[user] = sha256.Sum256([]byte(password))
+	}
+
+	return BasicAuthValidatorFunc(func(user, password string) bool {
+		expected, found := hashed[user]
+		// Hashing both sides prevents leaking the length of the password
+		given := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(expected[:], given[:]) == 1 && found
+	})
+}
+
+// Htpasswd is a BasicAuthValidator using credentials in the htpasswd format generated by Apache's htpasswd tool.
+// Passwords hashed with bcrypt (htpasswd -B) and SHA-1 (htpasswd -s) are supported.
+type Htpasswd struct {
+	users map[string]string
+}
+
+// LoadHtpasswd loads the htpasswd file located at path
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHtpasswd(f)
+}
+
+// ParseHtpasswd parses htpasswd entries from r.
+// Empty lines and lines starting with # are ignored.
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	h := &Htpasswd{users: make(map[string]string)}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		i := strings.IndexByte(entry, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("htpasswd: line %d: %v", line, errHtpasswdMalformed)
+		}
+		user, hash := entry[:i], entry[i+1:]
+		if !isBcryptHash(hash) && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("htpasswd: line %d: %v", line, errHtpasswdHash)
+		}
+		h.users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// dummyBcryptHash is compared to the password of unknown users so that they take as long to reject as known ones
+var dummyBcryptHash = []byte("$2a$10$u8TO4OC3ieW02LMmDa785e.kOyuD2L0bO7z/9Vi6mrQPaABd0kWiq")
+
+// Validate implements BasicAuthValidator
+func (h *Htpasswd) Validate(user, password string) bool {
+	hash, found := h.users[user]
+	if !found {
+		bcrypt.CompareHashAndPassword(dummyBcryptHash, []byte(password))
+		return false
+	}
+
+	if isBcryptHash(hash) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	expected := base64.StdEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(hash[len("{SHA}"):]), []byte(expected)) == 1
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$")
+}
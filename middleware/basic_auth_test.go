@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuth(t *testing.T) {
+	l := lion.New()
+	admin := l.Group("/admin", NewBasicAuth(BasicAuthUsers(map[string]string{"lion": "argh"})))
+	admin.GetFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello %s", r.Context().Value(DefaultBasicAuthContextKey))
+	})
+
+	test := htest.New(t, l)
+	test.Get("/admin").Do().
+		ExpectStatus(http.StatusUnauthorized).
+		ExpectHeader("WWW-Authenticate", `Basic realm="Restricted", charset="UTF-8"`)
+	test.Get("/admin").SetHeader("Authorization", basicAuthHeader("lion", "wrong")).Do().
+		ExpectStatus(http.StatusUnauthorized)
+	test.Get("/admin").SetHeader("Authorization", basicAuthHeader("tiger", "argh")).Do().
+		ExpectStatus(http.StatusUnauthorized)
+	test.Get("/admin").SetHeader("Authorization", basicAuthHeader("lion", "argh")).Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("Hello lion")
+}
+
+func TestHtpasswd(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := strings.Join([]string{
+		"# users",
+		"",
+		"bcrypt:" + strings.Replace(string(bcryptHash), "$2a$", "$2y$", 1),
+		// htpasswd -nbs sha sha-pass
+		"sha:{SHA}xO2etOilyqtV8o1RvvnmkeBx7QI=",
+	}, "\n")
+
+	h, err := ParseHtpasswd(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user, password string
+		expected       bool
+	}{
+		{"bcrypt", "bcrypt-pass", true},
+		{"bcrypt", "sha-pass", false},
+		{"sha", "sha-pass", true},
+		{"sha", "bcrypt-pass", false},
+		{"unknown", "sha-pass", false},
+	}
+	for _, test := range tests {
+		if got := h.Validate(test.user, test.password); got != test.expected {
+			t.Errorf("Validate(%q, %q): got %v want %v", test.user, test.password, got, test.expected)
+		}
+	}
+
+	if _, err := ParseHtpasswd(strings.NewReader("md5:$apr1$salt$hash")); err == nil {
+		t.Errorf("Expected an error for unsupported hashes")
+	}
+	if _, err := ParseHtpasswd(strings.NewReader("no-separator")); err == nil {
+		t.Errorf("Expected an error for malformed lines")
+	}
+
+	auth := &BasicAuth{Realm: `Lion "admin"`, Validator: h}
+	test := htest.New(t, auth.ServeNext(fakeHandler()))
+	test.Get("/").Do().
+		ExpectStatus(http.StatusUnauthorized).
+		ExpectHeader("WWW-Authenticate", `Basic realm="Lion \"admin\"", charset="UTF-8"`)
+	test.Get("/").SetHeader("Authorization", basicAuthHeader("sha", "sha-pass")).Do().
+		ExpectStatus(http.StatusOK)
+}
+
+func basicAuthHeader(user, password string) string {
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.SetBasicAuth(user, password)
+	return r.Header.Get("Authorization")
+}
@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/celrenheit/lion"
+)
+
+type ctxBodyLimitKeyType int
+
+const ctxBodyLimitKey ctxBodyLimitKeyType = 0
+
+// BodyLimit is a middleware that limits the size of request bodies.
+//
+// Requests with a Content-Length larger than the limit are rejected before reaching the handler.
+// Otherwise, reading more than the limit returns an *http.MaxBytesError to the handler
+// and the response is replaced by 413 Request Entity Too Large unless the handler has already written its header.
+//
+// A BodyLimit used in a group or for a route overrides the one applied by a parent router:
+//
+//	l := lion.New(middleware.NewBodyLimit(1 << 20))
+//	uploads := l.Group("/uploads", &middleware.BodyLimit{MultipartLimit: 100 << 20})
+type BodyLimit struct {
+	// Limit is the maximum size of a request body in bytes. Zero means no limit.
+	Limit int64
+	// MultipartLimit replaces Limit for multipart requests, if set
+	MultipartLimit int64
+
+	// Decompress decodes request bodies encoded with gzip or deflate.
+	// Other encodings are rejected with 415 Unsupported Media Type.
+	Decompress bool
+	// DecodedLimit is the maximum size of a decoded request body, to guard against decompression bombs.
+	// It defaults to Limit.
+	DecodedLimit int64
+
+	// Handler responds to requests exceeding the limit. It responds with 413 Request Entity Too Large by default.
+	Handler http.Handler
+}
+
+// NewBodyLimit creates a BodyLimit limiting request bodies to limit bytes
+func NewBodyLimit(limit int64) *BodyLimit {
+	return &BodyLimit{Limit: limit}
+}
+
+// ServeNext implements the Middleware interface for BodyLimit.
+func (b *BodyLimit) ServeNext(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Replace the limits of a parent router by using the original body
+		if st, ok := r.Context().Value(ctxBodyLimitKey).(*bodyLimitState); ok {
+			r.Body = st.body
+			r.ContentLength = st.contentLength
+			if st.encoding != "" {
+				r.Header.Set("Content-Encoding", st.encoding)
+			}
+		} else {
+			st = &bodyLimitState{body: r.Body, contentLength: r.ContentLength, encoding: r.Header.Get("Content-Encoding")}
+			r = r.WithContext(context.WithValue(r.Context(), ctxBodyLimitKey, st))
+		}
+
+		limit := b.limit(r)
+		if limit > 0 && r.ContentLength > limit {
+			b.reject(w, r)
+			return
+		}
+
+		lr := &limitedReader{r: r.Body, closer: r.Body, n: limit}
+		r.Body = lr
+
+		if b.Decompress {
+			if enc := r.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+				body, err := decodeBody(enc, lr)
+				if err == errUnsupportedEncoding {
+					writeError(w, lion.ErrorUnsupportedMediaType)
+					return
+				}
+				if err != nil {
+					if lr.exceeded {
+						b.reject(w, r)
+						return
+					}
+					writeError(w, lion.ErrorBadRequest)
+					return
+				}
+
+				decodedLimit := b.DecodedLimit
+				if decodedLimit == 0 {
+					decodedLimit = limit
+				}
+				r.Body = &limitedReader{r: body, closer: lr, n: decodedLimit, parent: lr}
+				r.Header.Del("Content-Encoding")
+				r.Header.Del("Content-Length")
+				r.ContentLength = -1
+			}
+		}
+
+		bw := &bodyLimitWriter{ResponseWriter: wrapResponseWriter(w), body: r.Body.(*limitedReader), limit: b, req: r}
+		next.ServeHTTP(bw, r)
+		bw.finish()
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func (b *BodyLimit) limit(r *http.Request) int64 {
+	if b.MultipartLimit > 0 {
+		if mediatype, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && strings.HasPrefix(mediatype, "multipart/") {
+			return b.MultipartLimit
+		}
+	}
+	return b.Limit
+}
+
+func (b *BodyLimit) reject(w http.ResponseWriter, r *http.Request) {
+	// Do not reuse a connection whose body has not been read
+	w.Header().Set("Connection", "close")
+	if b.Handler != nil {
+		b.Handler.ServeHTTP(w, r)
+		return
+	}
+	writeError(w, lion.ErrorRequestEntityTooLarge)
+}
+
+// bodyLimitState keeps the original body of the request so that nested BodyLimit middlewares can replace the limits
+type bodyLimitState struct {
+	body          io.ReadCloser
+	contentLength int64
+	encoding      string
+}
+
+var errUnsupportedEncoding = errors.New("Unsupported content encoding")
+
+func decodeBody(encoding string, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		return zlib.NewReader(r)
+	}
+	return nil, errUnsupportedEncoding
+}
+
+// limitedReader returns an *http.MaxBytesError when more than n bytes are read
+type limitedReader struct {
+	r      io.Reader
+	closer io.Closer
+	n      int64
+	read   int64
+	// parent is the reader of the encoded body
+	parent   *limitedReader
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		n, err := l.r.Read(p)
+		return n, l.check(err)
+	}
+	if l.read > l.n {
+		l.exceeded = true
+		return 0, &http.MaxBytesError{Limit: l.n}
+	}
+	// Read one more byte than allowed to detect bodies exceeding the limit
+	if max := l.n - l.read + 1; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.n {
+		l.exceeded = true
+		return n - int(l.read-l.n), &http.MaxBytesError{Limit: l.n}
+	}
+	return n, l.check(err)
+}
+
+// check reports errors of the encoded body exceeding its own limit
+func (l *limitedReader) check(err error) error {
+	if l.parent != nil && l.parent.exceeded {
+		l.exceeded = true
+		return &http.MaxBytesError{Limit: l.parent.n}
+	}
+	return err
+}
+
+func (l *limitedReader) isExceeded() bool {
+	return l.exceeded || (l.parent != nil && l.parent.exceeded)
+}
+
+func (l *limitedReader) Close() error {
+	return l.closer.Close()
+}
+
+var _ ResponseWriter = (*bodyLimitWriter)(nil)
+
+// bodyLimitWriter replaces the response by the one of BodyLimit if the limit has been exceeded before the header is written
+type bodyLimitWriter struct {
+	ResponseWriter
+	body     *limitedReader
+	limit    *BodyLimit
+	req      *http.Request
+	rejected bool
+}
+
+func (bw *bodyLimitWriter) WriteHeader(code int) {
+	if bw.rejected {
+		return
+	}
+	if bw.Status() == 0 && bw.body.isExceeded() {
+		bw.rejected = true
+		bw.limit.reject(bw.ResponseWriter, bw.req)
+		return
+	}
+	bw.ResponseWriter.WriteHeader(code)
+}
+
+func (bw *bodyLimitWriter) Write(b []byte) (int, error) {
+	if bw.Status() == 0 {
+		bw.WriteHeader(http.StatusOK)
+	}
+	if bw.rejected {
+		return len(b), nil
+	}
+	return bw.ResponseWriter.Write(b)
+}
+
+func (bw *bodyLimitWriter) finish() {
+	if bw.Status() == 0 && bw.body.isExceeded() {
+		bw.WriteHeader(http.StatusOK)
+	}
+}
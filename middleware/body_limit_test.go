@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+)
+
+func TestBodyLimit(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if !errors.As(err, &maxErr) {
+				t.Errorf("Expected a MaxBytesError but got %v", err)
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(b)
+	})
+
+	l := lion.New(NewBodyLimit(10))
+	l.Post("/echo", echo)
+	uploads := l.Group("/uploads", &BodyLimit{Limit: 10, MultipartLimit: 1000})
+	uploads.Post("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 10); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, r.FormValue("name"))
+	}))
+	l.Group("/large", NewBodyLimit(100)).Post("/", echo)
+
+	test := htest.New(t, l)
+	test.Post("/echo").SendString("small").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("small")
+
+	// Rejected early with Content-Length
+	test.Post("/echo").SendString("this body is too large").Do().
+		ExpectStatus(http.StatusRequestEntityTooLarge).
+		ExpectBody("Request Entity Too Large")
+
+	// Enforced while streaming
+	test.RequestWithBody("POST", "/echo", io.MultiReader(strings.NewReader("this body "), strings.NewReader("is too large"))).Do().
+		ExpectStatus(http.StatusRequestEntityTooLarge).
+		ExpectBody("Request Entity Too Large")
+
+	// Overridden by a group
+	test.Post("/large").SendString("this body is not too large").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("this body is not too large")
+
+	body, contentType := multipartBody(t, strings.Repeat("a", 100))
+	test.Post("/uploads").SetHeader("Content-Type", contentType).SendBytes(body).Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody(strings.Repeat("a", 100))
+
+	body, contentType = multipartBody(t, strings.Repeat("a", 2000))
+	test.Post("/uploads").SetHeader("Content-Type", contentType).SendBytes(body).Do().
+		ExpectStatus(http.StatusRequestEntityTooLarge)
+}
+
+func TestBodyLimitDecompress(t *testing.T) {
+	handler := (&BodyLimit{Limit: 100, DecodedLimit: 1000, Decompress: true}).ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "" {
+			t.Errorf("Content-Encoding should be removed")
+		}
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		w.Write(b)
+	}))
+	test := htest.New(t, handler)
+
+	test.Post("/").SetHeader("Content-Encoding", "gzip").SendBytes(gzipped(t, "hello")).Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("hello")
+
+	// Decompression bomb
+	test.Post("/").SetHeader("Content-Encoding", "gzip").SendBytes(gzipped(t, strings.Repeat("a", 10000))).Do().
+		ExpectStatus(http.StatusRequestEntityTooLarge)
+
+	test.Post("/").SetHeader("Content-Encoding", "br").SendString("hello").Do().
+		ExpectStatus(http.StatusUnsupportedMediaType)
+
+	test.Post("/").SetHeader("Content-Encoding", "gzip").SendString("not gzip").Do().
+		ExpectStatus(http.StatusBadRequest)
+}
+
+func multipartBody(t *testing.T, value string) ([]byte, string) {
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+	if err := mw.WriteField("name", value); err != nil {
+		t.Fatal(err)
+	}
+	mw.Close()
+	return buf.Bytes(), mw.FormDataContentType()
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	if _, err := io.WriteString(gw, s); err != nil {
+		t.Fatal(err)
+	}
+	gw.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,328 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressContentTypes are the content types compressed by default.
+// Entries ending with "/" match every subtype.
+var DefaultCompressContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/problem+json",
+	"image/svg+xml",
+}
+
+// Compress is a middleware that compresses responses with gzip or deflate depending on the Accept-Encoding header of the request.
+//
+// The response is buffered until MinSize bytes have been written or until the handler flushes it.
+// Responses that are smaller, already encoded or whose content type is not listed in ContentTypes are sent as is.
+type Compress struct {
+	// Level is the compression level, between gzip.BestSpeed and gzip.BestCompression
+	Level int
+	// MinSize is the minimum size of a response body to compress it
+	MinSize int
+	// ContentTypes lists the content types to compress
+	ContentTypes []string
+
+	pools map[string]*sync.Pool
+	once  sync.Once
+}
+
+// NewCompress creates a new Compress middleware with default settings
+func NewCompress() *Compress {
+	return &Compress{
+		Level:        gzip.DefaultCompression,
+		MinSize:      1024,
+		ContentTypes: DefaultCompressContentTypes,
+	}
+}
+
+// ServeNext implements the Middleware interface for Compress.
+func (c *Compress) ServeNext(next http.Handler) http.Handler {
+	c.once.Do(c.init)
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == "HEAD" || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: wrapResponseWriter(w),
+			compress:       c,
+			encoding:       encoding,
+		}
+		defer cw.Close()
+
+		next.ServeHTTP(cw, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func (c *Compress) init() {
+	level := c.Level
+	c.pools = map[string]*sync.Pool{
+		"gzip": {New: func() interface{} {
+			w, err := gzip.NewWriterLevel(io.Discard, level)
+			if err != nil {
+				w = gzip.NewWriter(io.Discard)
+			}
+			return w
+		}},
+		"deflate": {New: func() interface{} {
+			w, err := zlib.NewWriterLevel(io.Discard, level)
+			if err != nil {
+				w = zlib.NewWriter(io.Discard)
+			}
+			return w
+		}},
+	}
+}
+
+// shouldCompress checks the headers set by the handler and the body buffered so far
+func (c *Compress) shouldCompress(h http.Header, code int, body []byte) bool {
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil && n < c.MinSize {
+			return false
+		}
+	}
+
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(body)
+		h.Set("Content-Type", ct)
+	}
+	mediatype, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range c.ContentTypes {
+		if mediatype == allowed || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediatype, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+type encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+	Flush() error
+}
+
+var _ ResponseWriter = (*compressWriter)(nil)
+
+// compressWriter buffers the beginning of the response until it can decide whether to compress it
+type compressWriter struct {
+	ResponseWriter
+	compress *Compress
+	encoding string
+
+	code     int
+	buf      []byte
+	decided  bool
+	hijacked bool
+	enc      encoder
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.code == 0 {
+		cw.code = code
+	}
+}
+
+func (cw *compressWriter) Status() int {
+	if cw.code != 0 {
+		return cw.code
+	}
+	return cw.ResponseWriter.Status()
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.code == 0 {
+		cw.code = http.StatusOK
+	}
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.compress.MinSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide writes the header and the buffered body, compressed if needed.
+// Small bodies are only compressed when more data can follow, i.e. when the response is flushed.
+func (cw *compressWriter) decide(compressSmall bool) error {
+	if cw.decided {
+		return nil
+	}
+	cw.decided = true
+	if cw.code == 0 {
+		cw.code = http.StatusOK
+	}
+
+	h := cw.Header()
+	if (compressSmall || len(cw.buf) >= cw.compress.MinSize) && cw.compress.shouldCompress(h, cw.code, cw.buf) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+
+		cw.enc = cw.compress.pools[cw.encoding].Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.code)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (cw *compressWriter) Flush() {
+	if cw.code == 0 {
+		// Nothing has been written yet
+		return
+	}
+	cw.decide(true)
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	cw.ResponseWriter.Flush()
+}
+
+// ReadFrom keeps the optimizations of the underlying http.ResponseWriter when the response is not compressed
+func (cw *compressWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !cw.decided && len(cw.buf) == 0 {
+		// The size is known, no need to buffer
+		if cl := cw.Header().Get("Content-Length"); cl != "" {
+			cw.decide(true)
+		}
+	}
+	if cw.decided && cw.enc == nil {
+		if rf, ok := cw.ResponseWriter.(io.ReaderFrom); ok {
+			return rf.ReadFrom(r)
+		}
+	}
+	return io.Copy(writerOnly{cw}, r)
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := cw.ResponseWriter.Hijack()
+	if err == nil {
+		cw.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Close writes what remains of the response
+func (cw *compressWriter) Close() error {
+	if cw.hijacked || cw.code == 0 {
+		return nil
+	}
+	if !cw.decided {
+		if cw.Header().Get("Content-Length") == "" && cw.code != http.StatusNoContent && cw.code != http.StatusNotModified {
+			cw.Header().Set("Content-Length", strconv.Itoa(len(cw.buf)))
+		}
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+	if cw.enc == nil {
+		return nil
+	}
+	err := cw.enc.Close()
+	cw.enc.Reset(io.Discard)
+	cw.compress.pools[cw.encoding].Put(cw.enc)
+	cw.enc = nil
+	return err
+}
+
+// writerOnly hides the ReadFrom method to avoid infinite recursion in io.Copy
+type writerOnly struct {
+	io.Writer
+}
+
+// negotiateEncoding returns the encoding with the highest quality value in an Accept-Encoding header.
+// gzip is preferred over deflate when both have the same quality.
+// It returns an empty string if none of them is acceptable.
+func negotiateEncoding(header string) string {
+	qs := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		coding, q := parseQuality(part)
+		if coding != "" {
+			qs[coding] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := qs[coding]
+		if !ok {
+			q, ok = qs["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// parseQuality parses a single element of an Accept-* header such as "gzip;q=0.8"
+func parseQuality(s string) (string, float64) {
+	params := strings.Split(s, ";")
+	value := strings.ToLower(strings.TrimSpace(params[0]))
+	q := 1.0
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(param, "q=") {
+			continue
+		}
+		f, err := strconv.ParseFloat(param[2:], 64)
+		if err != nil {
+			return "", 0
+		}
+		q = f
+	}
+	return value, q
+}
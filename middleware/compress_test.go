@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celrenheit/htest"
+)
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("lion ", 1000)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "5000")
+		io.WriteString(w, large)
+	})
+	mux.HandleFunc("/small", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "small")
+	})
+	mux.HandleFunc("/png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, large)
+	})
+	mux.HandleFunc("/encoded", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		io.WriteString(w, large)
+	})
+	mux.HandleFunc("/created", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, large)
+	})
+	test := htest.New(t, NewCompress().ServeNext(mux))
+
+	res := test.Get("/large").AddHeader("Accept-Encoding", "gzip").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Content-Encoding", "gzip").
+		ExpectHeader("Content-Length", "").
+		ExpectHeader("Vary", "Accept-Encoding").
+		Recorder()
+	if body := gunzip(t, res.Body); body != large {
+		t.Errorf("Incorrect decompressed body of length %d", len(body))
+	}
+
+	res = test.Get("/created").AddHeader("Accept-Encoding", "gzip;q=0.5, deflate").Do().
+		ExpectStatus(http.StatusCreated).
+		ExpectHeader("Content-Encoding", "deflate").
+		Recorder()
+	zr, err := zlib.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(zr); string(b) != large {
+		t.Errorf("Incorrect inflated body of length %d", len(b))
+	}
+
+	test.Get("/small").AddHeader("Accept-Encoding", "gzip").Do().
+		ExpectHeader("Content-Encoding", "").
+		ExpectHeader("Content-Length", "5").
+		ExpectBody("small")
+
+	test.Get("/png").AddHeader("Accept-Encoding", "gzip").Do().
+		ExpectHeader("Content-Encoding", "").
+		ExpectBody(large)
+
+	test.Get("/encoded").AddHeader("Accept-Encoding", "gzip").Do().
+		ExpectHeader("Content-Encoding", "br").
+		ExpectBody(large)
+
+	test.Get("/large").AddHeader("Accept-Encoding", "gzip;q=0, deflate;q=0").Do().
+		ExpectHeader("Content-Encoding", "").
+		ExpectHeader("Vary", "Accept-Encoding").
+		ExpectBody(large)
+
+	test.Get("/large").Do().
+		ExpectHeader("Content-Encoding", "").
+		ExpectBody(large)
+}
+
+func TestCompressFlush(t *testing.T) {
+	handler := NewCompress().ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "data: second\n\n")
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(w, r)
+
+	if !w.Flushed {
+		t.Errorf("Response should have been flushed")
+	}
+	if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Errorf("Incorrect Content-Encoding: %s", enc)
+	}
+	if body := gunzip(t, w.Body); body != "data: first\n\ndata: second\n\n" {
+		t.Errorf("Incorrect body: %q", body)
+	}
+}
+
+func TestCompressReadFrom(t *testing.T) {
+	large := strings.Repeat("lion ", 1000)
+	handler := NewCompress().ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(large))
+	}))
+
+	test := htest.New(t, handler)
+	res := test.Get("/").AddHeader("Accept-Encoding", "gzip").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Content-Encoding", "gzip").
+		Recorder()
+	if body := gunzip(t, res.Body); body != large {
+		t.Errorf("Incorrect decompressed body of length %d", len(body))
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header, expected string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.2, deflate;q=0.8", "deflate"},
+		{"br, *;q=0.1", "gzip"},
+		{"gzip;q=0, *", "deflate"},
+		{"identity", ""},
+	}
+	for _, test := range tests {
+		if got := negotiateEncoding(test.header); got != test.expected {
+			t.Errorf("negotiateEncoding(%q): got %q want %q", test.header, got, test.expected)
+		}
+	}
+}
+
+func gunzip(t *testing.T, body *bytes.Buffer) string {
+	gr, err := gzip.NewReader(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/celrenheit/lion"
+)
+
+// CORS is a middleware that implements Cross-Origin Resource Sharing.
+//
+// Preflight requests are answered directly with the methods registered for the matched route.
+// Since automatic OPTIONS responses go through the middlewares of the router that registered the route,
+// different policies can be used for each group or resource:
+//
+//	api := l.Group("/api", middleware.NewCORS())
+//	admin := l.Group("/admin", &middleware.CORS{
+//		AllowedOrigins:   []string{"https://admin.example.com"},
+//		AllowCredentials: true,
+//	})
+//
+// Preflight requests from an origin, for a method or with headers that are not allowed are answered with 403 Forbidden and without CORS headers.
+type CORS struct {
+	// AllowedOrigins is a list of origins allowed to make cross-origin requests.
+	// "*" allows any origin and a single "*" can be used inside an origin to match a part of it, e.g. "https://*.example.com".
+	AllowedOrigins []string
+	// AllowedOriginsRegexp is a list of regular expressions matched against the origin.
+	AllowedOriginsRegexp []*regexp.Regexp
+	// AllowOriginFunc, if set, is called for origins that do not match AllowedOrigins and AllowedOriginsRegexp.
+	AllowOriginFunc func(r *http.Request, origin string) bool
+
+	// AllowedMethods is only used when the request has not been routed by lion.
+	// Otherwise the methods registered for the matched route are used.
+	AllowedMethods []string
+	// AllowedHeaders is a list of headers the client may use. "*" allows any header.
+	AllowedHeaders []string
+	// ExposedHeaders is a list of response headers the client can read.
+	ExposedHeaders []string
+	// AllowCredentials allows cookies and authorization headers to be sent.
+	// The allowed origins must then be listed explicitly: ServeNext panics if AllowedOrigins contains "*",
+	// as any site could make credentialed requests.
+	AllowCredentials bool
+	// MaxAge is how long the results of a preflight request can be cached.
+	MaxAge time.Duration
+
+	// OptionsPassthrough passes preflight requests to the next handler after setting the CORS headers.
+	OptionsPassthrough bool
+}
+
+// NewCORS returns a CORS middleware allowing any origin to use simple headers.
+func NewCORS() *CORS {
+	return &CORS{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{lion.GET, lion.HEAD, lion.POST},
+		AllowedHeaders: []string{"Accept", "Accept-Language", "Content-Language", "Content-Type", "Authorization", "X-Requested-With"},
+	}
+}
+
+func (c *CORS) ServeNext(next http.Handler) http.Handler {
+	if c.AllowCredentials && containsFold(c.AllowedOrigins, "*") {
+		panic(`lion: CORS cannot allow credentials from any origin, list the allowed origins instead of "*"`)
+	}
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// Shared caches must not serve this response to cross-origin requests
+			if !c.anyOrigin() {
+				w.Header().Add("Vary", "Origin")
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == lion.OPTIONS && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !c.preflight(w, r, origin) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if c.OptionsPassthrough {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		c.actual(w, r, origin)
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// preflight sets the headers of a preflight response.
+// It returns false if the request is not allowed.
+func (c *CORS) preflight(w http.ResponseWriter, r *http.Request, origin string) bool {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	if !c.isOriginAllowed(r, origin) {
+		return false
+	}
+
+	methods := c.methods(r)
+	if !containsFold(methods, r.Header.Get("Access-Control-Request-Method")) {
+		return false
+	}
+
+	headers := parseHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+	if !containsFold(c.AllowedHeaders, "*") {
+		for _, header := range headers {
+			if !containsFold(c.AllowedHeaders, header) {
+				return false
+			}
+		}
+	}
+
+	h.Set("Access-Control-Allow-Origin", c.allowOrigin(origin))
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+	}
+	return true
+}
+
+// actual sets the headers of a response to a cross-origin request that is not a preflight.
+func (c *CORS) actual(w http.ResponseWriter, r *http.Request, origin string) {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+
+	if !c.isOriginAllowed(r, origin) {
+		return
+	}
+
+	h.Set("Access-Control-Allow-Origin", c.allowOrigin(origin))
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(c.ExposedHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+	}
+}
+
+// methods returns the methods registered for the route matched by lion
+func (c *CORS) methods(r *http.Request) []string {
+	if ctx := lion.C(r); ctx != nil {
+		if route := ctx.Route(); route != nil {
+			return route.Methods()
+		}
+	}
+	return c.AllowedMethods
+}
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin header.
+func (c *CORS) allowOrigin(origin string) string {
+	if c.anyOrigin() {
+		return "*"
+	}
+	return origin
+}
+
+// anyOrigin reports whether the Access-Control-Allow-Origin header is always "*"
+func (c *CORS) anyOrigin() bool {
+	return containsFold(c.AllowedOrigins, "*")
+}
+
+func (c *CORS) isOriginAllowed(r *http.Request, origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+	for _, re := range c.AllowedOriginsRegexp {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	if c.AllowOriginFunc != nil {
+		return c.AllowOriginFunc(r, origin)
+	}
+	return false
+}
+
+// matchOrigin checks if origin matches pattern.
+// A single "*" in pattern matches any non empty string.
+func matchOrigin(pattern, origin string) bool {
+	pattern = strings.ToLower(pattern)
+	origin = strings.ToLower(origin)
+
+	i := strings.IndexByte(pattern, '*')
+	if i < 0 {
+		return pattern == origin
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) &&
+		strings.HasSuffix(origin, suffix)
+}
+
+func parseHeaderList(s string) []string {
+	var headers []string
+	for _, header := range strings.Split(s, ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, http.CanonicalHeaderKey(header))
+		}
+	}
+	return headers
+}
+
+func containsFold(slice []string, s string) bool {
+	for _, val := range slice {
+		if strings.EqualFold(val, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+)
+
+type corsResource struct{}
+
+func (corsResource) Uses() lion.Middlewares {
+	return lion.Middlewares{&CORS{AllowedOrigins: []string{"https://resource.example.com"}}}
+}
+func (corsResource) Get(w http.ResponseWriter, r *http.Request)    {}
+func (corsResource) Delete(w http.ResponseWriter, r *http.Request) {}
+func (corsResource) DeleteMiddlewares() lion.Middlewares {
+	return lion.Middlewares{lion.MiddlewareFunc(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	})}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	l := lion.New()
+	api := l.Group("/api", NewCORS())
+	api.Get("/users", fakeHandler())
+	api.Post("/users", fakeHandler())
+	api.Get("/status", fakeHandler())
+
+	admin := l.Group("/admin", &CORS{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowedHeaders:   []string{"*"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+	admin.Put("/users/:id", fakeHandler())
+
+	l.Resource("/items", corsResource{})
+
+	test := htest.New(t, l)
+	test.Options("/api/users").
+		AddHeader("Origin", "https://foo.com").
+		AddHeader("Access-Control-Request-Method", "POST").
+		AddHeader("Access-Control-Request-Headers", "content-type").
+		Do().
+		ExpectStatus(http.StatusNoContent).
+		ExpectHeader("Access-Control-Allow-Origin", "*").
+		ExpectHeader("Access-Control-Allow-Methods", "GET, POST").
+		ExpectHeader("Access-Control-Allow-Headers", "Content-Type").
+		ExpectHeader("Access-Control-Allow-Credentials", "").
+		ExpectHeader("Access-Control-Max-Age", "")
+
+	// Only the methods of the matched route are allowed
+	test.Options("/api/status").
+		AddHeader("Origin", "https://foo.com").
+		AddHeader("Access-Control-Request-Method", "POST").
+		Do().
+		ExpectStatus(http.StatusForbidden).
+		ExpectHeader("Access-Control-Allow-Origin", "")
+
+	test.Options("/api/users").
+		AddHeader("Origin", "https://foo.com").
+		AddHeader("Access-Control-Request-Method", "GET").
+		AddHeader("Access-Control-Request-Headers", "X-Custom").
+		Do().
+		ExpectStatus(http.StatusForbidden)
+
+	test.Options("/admin/users/123").
+		AddHeader("Origin", "https://admin.example.com").
+		AddHeader("Access-Control-Request-Method", "PUT").
+		AddHeader("Access-Control-Request-Headers", "X-Custom, Authorization").
+		Do().
+		ExpectStatus(http.StatusNoContent).
+		ExpectHeader("Access-Control-Allow-Origin", "https://admin.example.com").
+		ExpectHeader("Access-Control-Allow-Methods", "PUT").
+		ExpectHeader("Access-Control-Allow-Headers", "X-Custom, Authorization").
+		ExpectHeader("Access-Control-Allow-Credentials", "true").
+		ExpectHeader("Access-Control-Max-Age", "600")
+
+	test.Options("/admin/users/123").
+		AddHeader("Origin", "https://example.com").
+		AddHeader("Access-Control-Request-Method", "PUT").
+		Do().
+		ExpectStatus(http.StatusForbidden)
+
+	// Method specific middlewares of resources do not apply to preflight requests
+	test.Options("/items").
+		AddHeader("Origin", "https://resource.example.com").
+		AddHeader("Access-Control-Request-Method", "DELETE").
+		Do().
+		ExpectStatus(http.StatusNoContent).
+		ExpectHeader("Access-Control-Allow-Origin", "https://resource.example.com").
+		ExpectHeader("Access-Control-Allow-Methods", "GET, DELETE")
+
+	// Plain OPTIONS requests still use the automatic OPTIONS handler
+	test.Options("/api/users").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Accept", "GET,POST,OPTIONS")
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	cors := &CORS{
+		AllowedOrigins:       []string{"https://foo.com"},
+		AllowedOriginsRegexp: []*regexp.Regexp{regexp.MustCompile(`^https://[a-z]+\.bar\.com$`)},
+		ExposedHeaders:       []string{"X-Total-Count"},
+	}
+	test := htest.New(t, cors.ServeNext(fakeHandler()))
+
+	// Caches must not serve the response without CORS headers to cross-origin requests
+	test.Get("/").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Access-Control-Allow-Origin", "").
+		ExpectHeader("Vary", "Origin")
+
+	test.Get("/").AddHeader("Origin", "https://foo.com").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Access-Control-Allow-Origin", "https://foo.com").
+		ExpectHeader("Access-Control-Expose-Headers", "X-Total-Count").
+		ExpectHeader("Vary", "Origin")
+
+	test.Get("/").AddHeader("Origin", "https://api.bar.com").Do().
+		ExpectHeader("Access-Control-Allow-Origin", "https://api.bar.com")
+
+	test.Get("/").AddHeader("Origin", "https://evil.com").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Access-Control-Allow-Origin", "")
+
+	// The response does not depend on the origin if any origin is allowed without credentials
+	htest.New(t, NewCORS().ServeNext(fakeHandler())).Get("/").Do().
+		ExpectHeader("Vary", "")
+
+	// Without lion, the allowed methods are used
+	cors.AllowedMethods = []string{"GET"}
+	test.Options("/").
+		AddHeader("Origin", "https://foo.com").
+		AddHeader("Access-Control-Request-Method", "GET").
+		Do().
+		ExpectStatus(http.StatusNoContent).
+		ExpectHeader("Access-Control-Allow-Methods", "GET")
+}
+
+func TestCORSCredentialsAnyOrigin(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Credentials from any origin should be rejected")
+		}
+	}()
+	(&CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true}).ServeNext(fakeHandler())
+}
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		pattern, origin string
+		expected        bool
+	}{
+		{"*", "https://foo.com", true},
+		{"https://foo.com", "https://FOO.com", true},
+		{"https://foo.com", "https://bar.com", false},
+		{"https://*.example.com", "https://api.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "http://api.example.com", false},
+	}
+	for _, test := range tests {
+		if got := matchOrigin(test.pattern, test.origin); got != test.expected {
+			t.Errorf("matchOrigin(%q, %q): got %v want %v", test.pattern, test.origin, got, test.expected)
+		}
+	}
+}
+
+func fakeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+}
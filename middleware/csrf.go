@@ -0,0 +1,330 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/celrenheit/lion"
+)
+
+type ctxCSRFKeyType int
+
+const ctxCSRFKey ctxCSRFKeyType = 0
+
+const (
+	csrfSecretLength  = 32
+	csrfSessionKey    = "_csrf"
+	csrfDefaultCookie = "lion_csrf"
+	csrfDefaultField  = "csrf_token"
+)
+
+var (
+	// ErrCSRFMissingToken is the failure reason of unsafe requests without token nor same origin Origin or Referer header
+	ErrCSRFMissingToken = errors.New("CSRF token missing")
+	// ErrCSRFInvalidToken is the failure reason of unsafe requests with an invalid token
+	ErrCSRFInvalidToken = errors.New("CSRF token invalid")
+	// ErrCSRFBadOrigin is the failure reason of unsafe requests coming from another origin
+	ErrCSRFBadOrigin = errors.New("CSRF origin not allowed")
+)
+
+// CSRF is a middleware protecting against cross-site request forgery.
+//
+// Each client receives a secret, stored in a cookie (double-submit) or, if PerSession is set, in its session.
+// Handlers and templates get a token derived from this secret with CSRFToken or CSRFTemplateField.
+// Requests with unsafe methods must send the token in the FieldName form field or the HeaderName header.
+// Tokens are masked with a random value on each request to protect them from BREACH attacks.
+//
+// Requests with an Origin or Referer header from another origin than the request's host or TrustedOrigins are rejected.
+// Requests without a token are accepted if their Origin or Referer header is from the same origin, so that browsers' requests built without templates, e.g. with fetch, are not rejected.
+type CSRF struct {
+	// PerSession stores the secret in the session provided by the Sessions middleware, which must be used before CSRF.
+	PerSession bool
+	// Key, if set, signs the secret stored in the cookie, so that it cannot be replaced, e.g. by a subdomain.
+	Key []byte
+
+	// Cookie attributes for double-submit secrets
+	CookieName string
+	Path       string
+	Domain     string
+	MaxAge     time.Duration
+	Secure     bool
+	SameSite   http.SameSite
+
+	// FieldName is the name of the form field containing the token
+	FieldName string
+	// HeaderName is the name of the header containing the token
+	HeaderName string
+
+	// TrustedOrigins lists other hosts, e.g. "admin.example.com", allowed to send unsafe requests
+	TrustedOrigins []string
+	// ExemptRoutes lists the names or patterns of the routes which are not protected, e.g. webhooks
+	ExemptRoutes []string
+	// Exempt, if set, reports whether a request is not protected
+	Exempt func(r *http.Request) bool
+
+	// FailureHandler responds to rejected requests. The reason is available with CSRFFailureReason.
+	// It responds with 403 Forbidden by default.
+	FailureHandler http.Handler
+}
+
+// NewCSRF creates a CSRF middleware using double-submit cookies
+func NewCSRF() *CSRF {
+	return &CSRF{
+		CookieName: csrfDefaultCookie,
+		Path:       "/",
+		MaxAge:     365 * 24 * time.Hour,
+		SameSite:   http.SameSiteLaxMode,
+		FieldName:  csrfDefaultField,
+		HeaderName: "X-CSRF-Token",
+	}
+}
+
+// ServeNext implements the Middleware interface for CSRF.
+func (c *CSRF) ServeNext(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		st := &csrfState{field: c.FieldName}
+		st.secret = c.secret(w, r)
+		r = r.WithContext(context.WithValue(r.Context(), ctxCSRFKey, st))
+
+		switch r.Method {
+		case lion.GET, lion.HEAD, lion.OPTIONS, "TRACE":
+			next.ServeHTTP(w, r)
+			return
+		}
+		if c.isExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := c.verify(r, st.secret); err != nil {
+			st.reason = err
+			if c.FailureHandler != nil {
+				c.FailureHandler.ServeHTTP(w, r)
+				return
+			}
+			writeError(w, lion.ErrorForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func (c *CSRF) verify(r *http.Request, secret []byte) error {
+	sameOrigin := false
+	if origin := requestOrigin(r); origin != "" {
+		if !c.isTrusted(r, origin) {
+			return ErrCSRFBadOrigin
+		}
+		sameOrigin = true
+	}
+
+	token := r.Header.Get(c.HeaderName)
+	if token == "" && c.FieldName != "" {
+		token = r.PostFormValue(c.FieldName)
+	}
+	if token == "" {
+		if sameOrigin {
+			return nil
+		}
+		return ErrCSRFMissingToken
+	}
+
+	if !validCSRFToken(token, secret) {
+		return ErrCSRFInvalidToken
+	}
+	return nil
+}
+
+// requestOrigin returns the host of the Origin header or, if absent, of the Referer header
+func requestOrigin(r *http.Request) string {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return ""
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		// Opaque origins are never trusted
+		return "invalid"
+	}
+	return u.Host
+}
+
+func (c *CSRF) isTrusted(r *http.Request, origin string) bool {
+	if strings.EqualFold(origin, r.Host) {
+		return true
+	}
+	for _, trusted := range c.TrustedOrigins {
+		if u, err := url.Parse(trusted); err == nil && u.Host != "" {
+			trusted = u.Host
+		}
+		if strings.EqualFold(origin, trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CSRF) isExempt(r *http.Request) bool {
+	if c.Exempt != nil && c.Exempt(r) {
+		return true
+	}
+	return matchRoute(r, c.ExemptRoutes)
+}
+
+// secret returns the secret of the client, issuing a new one if needed
+func (c *CSRF) secret(w http.ResponseWriter, r *http.Request) []byte {
+	if c.PerSession {
+		session := GetSession(r)
+		if session == nil {
+			panic("lion: the CSRF middleware requires the Sessions middleware when PerSession is set")
+		}
+		if s, ok := session.Get(csrfSessionKey).([]byte); ok && len(s) == csrfSecretLength {
+			return s
+		}
+		secret := newCSRFSecret()
+		session.Set(csrfSessionKey, secret)
+		return secret
+	}
+
+	if cookie, err := r.Cookie(c.CookieName); err == nil {
+		if secret := c.openCookie(cookie.Value); secret != nil {
+			return secret
+		}
+	}
+	secret := newCSRFSecret()
+	cookie := &http.Cookie{
+		Name:     c.CookieName,
+		Value:    c.sealCookie(secret),
+		Path:     c.Path,
+		Domain:   c.Domain,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	}
+	if c.MaxAge > 0 {
+		cookie.MaxAge = int(c.MaxAge / time.Second)
+		cookie.Expires = time.Now().Add(c.MaxAge)
+	}
+	http.SetCookie(w, cookie)
+	return secret
+}
+
+func (c *CSRF) sealCookie(secret []byte) string {
+	value := base64.RawURLEncoding.EncodeToString(secret)
+	if len(c.Key) == 0 {
+		return value
+	}
+	return value + "." + base64.RawURLEncoding.EncodeToString(c.sign(secret))
+}
+
+func (c *CSRF) openCookie(value string) []byte {
+	var signature []byte
+	if len(c.Key) > 0 {
+		i := strings.IndexByte(value, '.')
+		if i < 0 {
+			return nil
+		}
+		var err error
+		if signature, err = base64.RawURLEncoding.DecodeString(value[i+1:]); err != nil {
+			return nil
+		}
+		value = value[:i]
+	}
+
+	secret, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(secret) != csrfSecretLength {
+		return nil
+	}
+	if len(c.Key) > 0 && !hmac.Equal(signature, c.sign(secret)) {
+		return nil
+	}
+	return secret
+}
+
+func (c *CSRF) sign(secret []byte) []byte {
+	mac := hmac.New(sha256.New, c.Key)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// csrfState holds the secret of the client for the current request
+type csrfState struct {
+	secret []byte
+	token  string
+	field  string
+	reason error
+}
+
+// CSRFToken returns the token to send along with unsafe requests, or an empty string if the CSRF middleware is not used
+func CSRFToken(r *http.Request) string {
+	st, ok := r.Context().Value(ctxCSRFKey).(*csrfState)
+	if !ok {
+		return ""
+	}
+	if st.token == "" {
+		st.token = maskCSRFToken(st.secret)
+	}
+	return st.token
+}
+
+// CSRFTemplateField returns a hidden input containing the token, to be used in forms of html/template templates
+func CSRFTemplateField(r *http.Request) template.HTML {
+	field := csrfDefaultField
+	if st, ok := r.Context().Value(ctxCSRFKey).(*csrfState); ok && st.field != "" {
+		field = st.field
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(field) + `" value="` + template.HTMLEscapeString(CSRFToken(r)) + `">`)
+}
+
+// CSRFFailureReason returns the reason why the CSRF middleware rejected a request
+func CSRFFailureReason(r *http.Request) error {
+	st, ok := r.Context().Value(ctxCSRFKey).(*csrfState)
+	if !ok {
+		return nil
+	}
+	return st.reason
+}
+
+func newCSRFSecret() []byte {
+	secret := make([]byte, csrfSecretLength)
+	rand.Read(secret)
+	return secret
+}
+
+// maskCSRFToken returns a random pad followed by the secret xored with the pad
+func maskCSRFToken(secret []byte) string {
+	token := make([]byte, 2*len(secret))
+	pad := token[:len(secret)]
+	rand.Read(pad)
+	for i := range secret {
+		token[len(secret)+i] = secret[i] ^ pad[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+func validCSRFToken(token string, secret []byte) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != 2*len(secret) {
+		return false
+	}
+	pad, masked := b[:len(secret)], b[len(secret):]
+	for i := range masked {
+		masked[i] ^= pad[i]
+	}
+	return subtle.ConstantTimeCompare(masked, secret) == 1
+}
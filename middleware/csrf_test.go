@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+)
+
+func newTestCSRFRouter(csrf *CSRF, mws ...lion.Middleware) *lion.Router {
+	l := lion.New(mws...)
+	l.Use(csrf)
+	l.GetFunc("/form", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFToken(r)))
+	})
+	l.PostFunc("/form", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("posted"))
+	})
+	l.PostFunc("/webhooks/:provider", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hooked"))
+	})
+	return l
+}
+
+func TestCSRF(t *testing.T) {
+	csrf := NewCSRF()
+	csrf.Key = []byte("signing key")
+	csrf.TrustedOrigins = []string{"https://admin.example.com"}
+	csrf.ExemptRoutes = []string{"/webhooks/:provider"}
+	l := newTestCSRFRouter(csrf)
+	test := htest.New(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Host = "example.com"
+		l.ServeHTTP(w, r)
+	}))
+
+	res := test.Get("/form").Do().ExpectStatus(http.StatusOK)
+	var cookie string
+	for _, c := range res.Recorder().Result().Cookies() {
+		if c.Name == "lion_csrf" {
+			cookie = c.Name + "=" + c.Value
+		}
+	}
+	if cookie == "" {
+		t.Fatal("No CSRF cookie")
+	}
+	token := res.Recorder().Body.String()
+
+	// Tokens are masked differently on each request
+	other := test.Get("/form").SetHeader("Cookie", cookie).Do().
+		ExpectHeader("Set-Cookie", "").
+		Recorder().Body.String()
+	if other == token {
+		t.Errorf("Tokens should be masked")
+	}
+
+	test.Post("/form").SetHeader("Cookie", cookie).Do().
+		ExpectStatus(http.StatusForbidden)
+	test.Post("/form").SetHeader("Cookie", cookie).SetHeader("X-CSRF-Token", token).Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("posted")
+	test.Post("/form").SetHeader("Cookie", cookie).SetHeader("X-CSRF-Token", other).Do().
+		ExpectStatus(http.StatusOK)
+	test.Post("/form").SetHeader("Cookie", cookie).
+		SetHeader("Content-Type", "application/x-www-form-urlencoded").
+		SendString(url.Values{"csrf_token": {token}}.Encode()).Do().
+		ExpectStatus(http.StatusOK)
+
+	// Tokens of another client are invalid
+	test.Post("/form").SetHeader("X-CSRF-Token", token).Do().
+		ExpectStatus(http.StatusForbidden)
+	// Unsigned cookies are rejected
+	test.Post("/form").SetHeader("Cookie", strings.Split(cookie, ".")[0]).SetHeader("X-CSRF-Token", token).Do().
+		ExpectStatus(http.StatusForbidden)
+
+	// Origin checks
+	test.Post("/form").SetHeader("Cookie", cookie).SetHeader("X-CSRF-Token", token).SetHeader("Origin", "https://evil.com").Do().
+		ExpectStatus(http.StatusForbidden)
+	test.Post("/form").SetHeader("Origin", "https://admin.example.com").Do().
+		ExpectStatus(http.StatusOK)
+	test.Post("/form").SetHeader("Referer", "http://example.com/form").Do().
+		ExpectStatus(http.StatusOK)
+
+	test.Post("/webhooks/github").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("hooked")
+}
+
+func TestCSRFPerSession(t *testing.T) {
+	csrf := NewCSRF()
+	csrf.PerSession = true
+	csrf.FailureHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(CSRFFailureReason(r).Error()))
+	})
+	test := htest.New(t, newTestCSRFRouter(csrf, NewSessions(NewCookieSessionStore([]byte("key")))))
+
+	res := test.Get("/form").Do()
+	cookie := sessionCookie(t, res)
+	token := res.Recorder().Body.String()
+
+	test.Post("/form").SetHeader("Cookie", cookie).SetHeader("X-CSRF-Token", token).Do().
+		ExpectStatus(http.StatusOK)
+	test.Post("/form").SetHeader("Cookie", cookie).SetHeader("X-CSRF-Token", token[1:]).Do().
+		ExpectStatus(http.StatusBadRequest).
+		ExpectBody(ErrCSRFInvalidToken.Error())
+	test.Post("/form").SetHeader("Cookie", cookie).Do().
+		ExpectStatus(http.StatusBadRequest).
+		ExpectBody(ErrCSRFMissingToken.Error())
+}
+
+func TestCSRFTemplateField(t *testing.T) {
+	handler := NewCSRF().ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFTemplateField(r)))
+	}))
+	body := htest.New(t, handler).Get("/").Do().Recorder().Body.String()
+	if !strings.HasPrefix(body, `<input type="hidden" name="csrf_token" value="`) {
+		t.Errorf("Incorrect template field: %s", body)
+	}
+}
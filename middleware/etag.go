@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/celrenheit/lion"
+)
+
+// ETag is a middleware that adds an ETag header to GET and HEAD responses and answers conditional requests.
+//
+// Successful responses are buffered to compute their ETag, unless the handler sets one itself, for example with lion.Context.CheckModified.
+// Requests with a matching If-None-Match or If-Modified-Since header receive 304 Not Modified,
+// and requests with a failing If-Match or If-Unmodified-Since header receive 412 Precondition Failed.
+// Streamed responses, i.e. flushed by the handler, are sent as is.
+//
+// Requests with other methods carrying If-Match or If-Unmodified-Since headers are answered with 412 Precondition Failed
+// if the current validators of the resource, returned by Validator, do not match.
+// Without Validator, these preconditions are left to the handler, for example with lion.Context.CheckModified.
+type ETag struct {
+	// Weak generates weak ETags, prefixed by W/
+	Weak bool
+	// Validator returns the current ETag and modification time of the resource targeted by a request with another method than GET and HEAD.
+	// An empty etag or a zero modTime are considered unknown.
+	Validator func(r *http.Request) (etag string, modTime time.Time)
+}
+
+// NewETag creates a new ETag middleware generating strong ETags
+func NewETag() *ETag {
+	return &ETag{}
+}
+
+// ServeNext implements the Middleware interface for ETag.
+func (e *ETag) ServeNext(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != lion.GET && r.Method != lion.HEAD {
+			if !e.checkPreconditions(w, r) {
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ew := &etagWriter{ResponseWriter: wrapResponseWriter(w)}
+		next.ServeHTTP(ew, r)
+		e.finish(ew, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// finish writes the buffered response, 304 Not Modified or 412 Precondition Failed
+func (e *ETag) finish(ew *etagWriter, r *http.Request) {
+	if ew.streaming {
+		return
+	}
+
+	h := ew.Header()
+	if ew.code == 0 {
+		ew.code = http.StatusOK
+	}
+	if ew.code == http.StatusOK {
+		etag := h.Get("ETag")
+		if etag == "" && ew.buf.Len() > 0 {
+			etag = e.generate(ew.buf.Bytes())
+			h.Set("ETag", etag)
+		}
+
+		switch lion.CheckPreconditions(r, etag, parseLastModified(h)) {
+		case http.StatusNotModified:
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			ew.ResponseWriter.WriteHeader(http.StatusNotModified)
+			return
+		case http.StatusPreconditionFailed:
+			h.Del("Content-Length")
+			writeError(ew.ResponseWriter, lion.ErrorPreconditionFailed)
+			return
+		}
+	}
+
+	ew.ResponseWriter.WriteHeader(ew.code)
+	ew.ResponseWriter.Write(ew.buf.Bytes())
+}
+
+func (e *ETag) generate(body []byte) string {
+	h := fnv.New64a()
+	h.Write(body)
+	etag := fmt.Sprintf(`"%x-%x"`, len(body), h.Sum64())
+	if e.Weak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+// checkPreconditions evaluates If-Match and If-Unmodified-Since against the validators returned by Validator.
+// It returns false if a response has been written.
+func (e *ETag) checkPreconditions(w http.ResponseWriter, r *http.Request) bool {
+	if e.Validator == nil || r.Header.Get("If-Match") == "" && r.Header.Get("If-Unmodified-Since") == "" {
+		return true
+	}
+
+	etag, modTime := e.Validator(r)
+	if lion.CheckPreconditions(r, etag, modTime) == http.StatusPreconditionFailed {
+		writeError(w, lion.ErrorPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func parseLastModified(h http.Header) time.Time {
+	t, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+var _ ResponseWriter = (*etagWriter)(nil)
+
+// etagWriter buffers the response until the handler returns or flushes it
+type etagWriter struct {
+	ResponseWriter
+	buf       bytes.Buffer
+	code      int
+	streaming bool
+}
+
+func (ew *etagWriter) WriteHeader(code int) {
+	if ew.streaming {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if ew.code == 0 {
+		ew.code = code
+	}
+}
+
+func (ew *etagWriter) Status() int {
+	if ew.code != 0 {
+		return ew.code
+	}
+	return ew.ResponseWriter.Status()
+}
+
+func (ew *etagWriter) Write(b []byte) (int, error) {
+	if ew.streaming {
+		return ew.ResponseWriter.Write(b)
+	}
+	if ew.code == 0 {
+		ew.code = http.StatusOK
+	}
+	return ew.buf.Write(b)
+}
+
+// stream sends what has been buffered and stops buffering
+func (ew *etagWriter) stream() {
+	if ew.streaming {
+		return
+	}
+	ew.streaming = true
+	if ew.code != 0 {
+		ew.ResponseWriter.WriteHeader(ew.code)
+	}
+	if ew.buf.Len() > 0 {
+		ew.ResponseWriter.Write(ew.buf.Bytes())
+		ew.buf.Reset()
+	}
+}
+
+func (ew *etagWriter) Flush() {
+	ew.stream()
+	ew.ResponseWriter.Flush()
+}
+
+func (ew *etagWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	ew.streaming = true
+	return ew.ResponseWriter.Hijack()
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+)
+
+func TestETag(t *testing.T) {
+	body := "hello lion"
+	updated := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	gets := 0
+	e := NewETag()
+	e.Validator = func(r *http.Request) (string, time.Time) {
+		if r.URL.Path == "/post" {
+			return `"v1"`, updated
+		}
+		return "", time.Time{}
+	}
+	l := lion.New(e)
+	l.GetFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, body)
+	})
+	l.GET("/post", func(c lion.Context) {
+		gets++
+		if c.CheckModified(`"v1"`, updated) {
+			return
+		}
+		c.WithStatus(http.StatusOK).String("expensive")
+	})
+	l.PUT("/post", func(c lion.Context) {
+		c.WithStatus(http.StatusCreated).String("updated")
+	})
+	l.GetFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "second")
+	})
+
+	test := htest.New(t, l)
+	etag := test.Get("/hello").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody(body).
+		Recorder().Header().Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("Incorrect ETag: %s", etag)
+	}
+
+	test.Get("/hello").AddHeader("If-None-Match", etag).Do().
+		ExpectStatus(http.StatusNotModified).
+		ExpectHeader("ETag", etag).
+		ExpectHeader("Content-Type", "").
+		ExpectBody("")
+	test.Get("/hello").AddHeader("If-None-Match", `"other", W/`+etag).Do().
+		ExpectStatus(http.StatusNotModified)
+	test.Get("/hello").AddHeader("If-None-Match", `"other"`).Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody(body)
+
+	// The handler skips the expensive work
+	test.Get("/post").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("ETag", `"v1"`).
+		ExpectHeader("Last-Modified", "Sat, 02 Jan 2016 03:04:05 GMT").
+		ExpectBody("expensive")
+	test.Get("/post").AddHeader("If-None-Match", `"v1"`).Do().
+		ExpectStatus(http.StatusNotModified).
+		ExpectBody("")
+	test.Get("/post").AddHeader("If-Modified-Since", "Sat, 02 Jan 2016 03:04:05 GMT").Do().
+		ExpectStatus(http.StatusNotModified)
+
+	// Failing preconditions on GET requests
+	test.Get("/hello").AddHeader("If-Match", `"other"`).Do().
+		ExpectStatus(http.StatusPreconditionFailed).
+		ExpectHeader("Content-Type", "text/plain; charset=utf-8").
+		ExpectBody(http.StatusText(http.StatusPreconditionFailed))
+	test.Get("/hello").AddHeader("If-Match", etag).Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody(body)
+	test.Get("/post").AddHeader("If-Unmodified-Since", "Fri, 01 Jan 2016 00:00:00 GMT").Do().
+		ExpectStatus(http.StatusPreconditionFailed)
+
+	// Preconditions on mutating methods use the Validator, without running the GET handler
+	gets = 0
+	test.Put("/post").AddHeader("If-Match", `"v1"`).Do().
+		ExpectStatus(http.StatusCreated).
+		ExpectBody("updated")
+	test.Put("/post").AddHeader("If-Match", `"v0"`).Do().
+		ExpectStatus(http.StatusPreconditionFailed)
+	test.Put("/post").AddHeader("If-Unmodified-Since", "Fri, 01 Jan 2016 00:00:00 GMT").Do().
+		ExpectStatus(http.StatusPreconditionFailed)
+	test.Put("/post").AddHeader("If-Unmodified-Since", "Sun, 03 Jan 2016 00:00:00 GMT").Do().
+		ExpectStatus(http.StatusCreated)
+	if gets != 0 {
+		t.Errorf("The GET handler should not be called for PUT requests but was called %d times", gets)
+	}
+
+	test.Get("/stream").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("ETag", "").
+		ExpectBody("firstsecond")
+}
+
+func TestETagWeak(t *testing.T) {
+	handler := (&ETag{Weak: true}).ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "weak")
+	}))
+
+	test := htest.New(t, handler)
+	etag := test.Get("/").Do().Recorder().Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("Incorrect weak ETag: %s", etag)
+	}
+	test.Get("/").AddHeader("If-None-Match", strings.TrimPrefix(etag, "W/")).Do().
+		ExpectStatus(http.StatusNotModified)
+}
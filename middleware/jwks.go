@@ -0,0 +1,286 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultJWKSRefreshInterval is the interval after which a JWKS is reloaded
+	DefaultJWKSRefreshInterval = time.Hour
+	// DefaultJWKSMinRefreshInterval is the minimum interval between two reloads of a JWKS triggered by an unknown kid
+	DefaultJWKSMinRefreshInterval = time.Minute
+
+	errJWKSSource = errors.New("JWKS: no URL or Path")
+)
+
+// JWTKeySet provides the keys used by JWT to verify tokens
+type JWTKeySet interface {
+	// Key returns the key identified by kid to verify a token signed with alg.
+	// kid is empty if the token has no kid header.
+	Key(kid, alg string) (interface{}, error)
+}
+
+// JWKS is a JSON Web Key Set (RFC 7517) loaded from a URL or a file.
+//
+// Keys are loaded on first use and reloaded in the background every RefreshInterval, cached keys being served meanwhile.
+// A token signed with an unknown key triggers a reload so that rotated keys are picked up,
+// at most once every MinRefreshInterval. Concurrent reloads are merged and the previous keys are kept if a reload fails.
+//
+// RSA, EC (P-256, P-384 and P-521) and symmetric (oct) keys are supported.
+//
+//	keys := middleware.NewJWKS("https://auth.example.com/.well-known/jwks.json")
+//	l.Use(&middleware.JWT{KeySet: keys, SigningMethods: []string{"RS256", "ES256"}, ContextKey: middleware.DefaultJWTContextKey})
+type JWKS struct {
+	// URL from which the key set is fetched
+	URL string
+	// Path of the file containing the key set, used if URL is empty
+	Path string
+	// Client fetches the key set. It defaults to an http.Client with a 10 seconds timeout.
+	Client *http.Client
+
+	// RefreshInterval is the interval after which the key set is reloaded. Zero disables periodic reloads.
+	RefreshInterval time.Duration
+	// MinRefreshInterval is the minimum interval between two reloads
+	MinRefreshInterval time.Duration
+
+	mu          sync.RWMutex
+	keys        map[string]jwk
+	lastAttempt time.Time
+	// reload is the reload in progress, if any
+	reload *jwksReload
+}
+
+type jwksReload struct {
+	done chan struct{}
+	err  error
+}
+
+// NewJWKS creates a JWKS fetched from url
+func NewJWKS(url string) *JWKS {
+	return &JWKS{
+		URL:                url,
+		RefreshInterval:    DefaultJWKSRefreshInterval,
+		MinRefreshInterval: DefaultJWKSMinRefreshInterval,
+	}
+}
+
+// LoadJWKS loads the JWKS contained in the file located at path
+func LoadJWKS(path string) (*JWKS, error) {
+	s := &JWKS{Path: path, MinRefreshInterval: DefaultJWKSMinRefreshInterval}
+	if err := s.Refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Key implements JWTKeySet
+func (s *JWKS) Key(kid, alg string) (interface{}, error) {
+	s.mu.RLock()
+	loaded := s.keys != nil
+	stale := s.RefreshInterval > 0 && time.Since(s.lastAttempt) > s.RefreshInterval
+	k, ok := s.find(kid)
+	s.mu.RUnlock()
+
+	if !loaded {
+		// Nothing can be served until the first load
+		if err := s.refresh(s.MinRefreshInterval); err != nil {
+			return nil, err
+		}
+	} else if stale {
+		go s.refresh(s.RefreshInterval)
+	}
+
+	if !ok {
+		if loaded {
+			s.refresh(s.MinRefreshInterval)
+		}
+		s.mu.RLock()
+		k, ok = s.find(kid)
+		s.mu.RUnlock()
+	}
+	if !ok {
+		return nil, ErrJWTUnknownKey
+	}
+	if k.alg != "" && k.alg != alg {
+		return nil, ErrJWTWrongAlg
+	}
+	return k.key, nil
+}
+
+// Refresh reloads the key set
+func (s *JWKS) Refresh() error {
+	return s.refresh(0)
+}
+
+// find must be called with s.mu held
+func (s *JWKS) find(kid string) (jwk, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+// refresh reloads the key set unless it has been attempted less than minInterval ago.
+// It waits for the reload in progress, if any, instead of starting another one.
+// The lock is not held while loading, so that cached keys can still be served.
+func (s *JWKS) refresh(minInterval time.Duration) error {
+	s.mu.Lock()
+	if r := s.reload; r != nil {
+		s.mu.Unlock()
+		<-r.done
+		return r.err
+	}
+	now := time.Now()
+	if !s.lastAttempt.IsZero() && now.Sub(s.lastAttempt) < minInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	r := &jwksReload{done: make(chan struct{})}
+	s.reload = r
+	s.lastAttempt = now
+	s.mu.Unlock()
+
+	var keys map[string]jwk
+	data, err := s.load()
+	if err == nil {
+		keys, err = parseJWKS(data)
+	}
+
+	s.mu.Lock()
+	if err == nil {
+		s.keys = keys
+	}
+	s.reload = nil
+	s.mu.Unlock()
+
+	r.err = err
+	close(r.done)
+	return err
+}
+
+func (s *JWKS) load() ([]byte, error) {
+	if s.URL == "" {
+		if s.Path == "" {
+			return nil, errJWKSSource
+		}
+		return os.ReadFile(s.Path)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	res, err := client.Get(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS: unexpected status %d from %s", res.StatusCode, s.URL)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
+
+// jwk is a parsed JSON Web Key
+type jwk struct {
+	alg string
+	key interface{}
+}
+
+// parseJWKS parses a key set, ignoring keys of unsupported types and encryption keys
+func parseJWKS(data []byte) (map[string]jwk, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			// RSA
+			N string `json:"n"`
+			E string `json:"e"`
+			// EC
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			// Symmetric
+			K string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("JWKS: %v", err)
+	}
+
+	keys := make(map[string]jwk, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key interface{}
+		var err error
+		switch k.Kty {
+		case "RSA":
+			var n, e *big.Int
+			if n, err = decodeJWKInt(k.N); err == nil {
+				if e, err = decodeJWKInt(k.E); err == nil {
+					key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+				}
+			}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			var x, y *big.Int
+			if x, err = decodeJWKInt(k.X); err == nil {
+				if y, err = decodeJWKInt(k.Y); err == nil {
+					key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+				}
+			}
+		case "oct":
+			key, err = decodeJWKBytes(k.K)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("JWKS: key %q: %v", k.Kid, err)
+		}
+		keys[k.Kid] = jwk{alg: k.Alg, key: key}
+	}
+	return keys, nil
+}
+
+func decodeJWKBytes(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := decodeJWKBytes(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
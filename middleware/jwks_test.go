@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celrenheit/htest"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var fetches int
+	keys := []map[string]string{rsaJWK("rsa", rsaKey), ecJWK("ec", ecKey)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer server.Close()
+
+	set := NewJWKS(server.URL)
+	set.MinRefreshInterval = 0
+	j := &JWT{KeySet: set, SigningMethods: []string{"RS256", "ES256"}, ContextKey: DefaultJWTContextKey}
+	test := htest.New(t, j.ServeNext(j.EnsureAuthenticated().ServeNext(fakeHandler())))
+
+	test.Get("/").SetHeader("Authorization", "Bearer "+signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{})).Do().
+		ExpectStatus(http.StatusOK)
+	test.Get("/").SetHeader("Authorization", "Bearer "+signToken(t, jwt.SigningMethodES256, "ec", ecKey, jwt.MapClaims{})).Do().
+		ExpectStatus(http.StatusOK)
+	// The key of kid "ec" cannot verify RS256 signatures
+	test.Get("/").SetHeader("Authorization", "Bearer "+signToken(t, jwt.SigningMethodRS256, "ec", rsaKey, jwt.MapClaims{})).Do().
+		ExpectStatus(http.StatusBadRequest)
+	// Not an accepted algorithm
+	test.Get("/").SetHeader("Authorization", "Bearer "+signToken(t, jwt.SigningMethodRS384, "rsa", rsaKey, jwt.MapClaims{})).Do().
+		ExpectStatus(http.StatusBadRequest).
+		ExpectBody(ErrJWTWrongAlg.Error())
+
+	// Rotated keys are fetched when an unknown kid is seen
+	rotated := signToken(t, jwt.SigningMethodRS256, "rotated", rotatedKey, jwt.MapClaims{})
+	test.Get("/").SetHeader("Authorization", "Bearer "+rotated).Do().
+		ExpectStatus(http.StatusBadRequest).
+		ExpectBody(ErrJWTUnknownKey.Error())
+	mu.Lock()
+	keys = append(keys, rsaJWK("rotated", rotatedKey))
+	mu.Unlock()
+	test.Get("/").SetHeader("Authorization", "Bearer "+rotated).Do().
+		ExpectStatus(http.StatusOK)
+
+	// Known keys do not trigger a reload
+	mu.Lock()
+	before := fetches
+	mu.Unlock()
+	test.Get("/").SetHeader("Authorization", "Bearer "+rotated).Do().
+		ExpectStatus(http.StatusOK)
+	mu.Lock()
+	if fetches != before {
+		t.Errorf("The key set should not be reloaded for known keys")
+	}
+	mu.Unlock()
+}
+
+func TestJWKSFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data := `{"keys": [{"kty": "oct", "alg": "HS256", "k": "` + base64.RawURLEncoding.EncodeToString([]byte("secret")) + `"}, {"kty": "RSA", "use": "enc", "kid": "enc"}]}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := LoadJWKS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A single key is used for tokens without kid
+	key, err := set.Key("", "HS256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key.([]byte)) != "secret" {
+		t.Errorf("Incorrect key: %s", key)
+	}
+	if _, err := set.Key("", "HS512"); err != ErrJWTWrongAlg {
+		t.Errorf("Expected ErrJWTWrongAlg but got %v", err)
+	}
+
+	if _, err := LoadJWKS(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.Claims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"alg": "ES256",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+	}
+}
+
+func TestJWKSSlowReload(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var fetches int
+	unblock := make(chan struct{})
+	blocking := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		block := blocking
+		mu.Unlock()
+		if block {
+			<-unblock
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{rsaJWK("rsa", rsaKey)}})
+	}))
+	defer server.Close()
+	countFetches := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetches
+	}
+
+	set := NewJWKS(server.URL)
+	set.RefreshInterval = time.Millisecond
+	set.MinRefreshInterval = time.Hour
+	if _, err := set.Key("rsa", "RS256"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown kids do not trigger reloads more often than MinRefreshInterval
+	for i := 0; i < 5; i++ {
+		if _, err := set.Key("unknown", "RS256"); err != ErrJWTUnknownKey {
+			t.Errorf("Expected ErrJWTUnknownKey but got %v", err)
+		}
+	}
+	if n := countFetches(); n != 1 {
+		t.Errorf("Expected 1 fetch but got %d", n)
+	}
+
+	// Cached keys are served while the endpoint is slow
+	mu.Lock()
+	blocking = true
+	mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	done := make(chan error)
+	go func() {
+		_, err := set.Key("rsa", "RS256")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Cached keys should not wait for the reload")
+	}
+
+	// Concurrent reloads are merged
+	set.MinRefreshInterval = 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			set.Key("unknown", "RS256")
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+	if n := countFetches(); n > 3 {
+		t.Errorf("Concurrent reloads should be merged, got %d fetches", n)
+	}
+}
@@ -2,8 +2,13 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/celrenheit/lion"
 	jwt "github.com/dgrijalva/jwt-go"
@@ -11,17 +16,63 @@ import (
 )
 
 var (
-	DefaultJWTContextKey   = "user"
-	errJWTTokenExpired     = errors.New("Token expired")
-	errJWTTokenNotValidYet = errors.New("Token not valid yet")
-	errJWTTokenMalformed   = errors.New("Token malformed")
-	errJWTWrongAlg         = errors.New("Wrong algorithm")
+	DefaultJWTContextKey = "user"
+
+	ErrJWTTokenExpired     = errors.New("Token expired")
+	ErrJWTTokenNotValidYet = errors.New("Token not valid yet")
+	ErrJWTTokenMalformed   = errors.New("Token malformed")
+	ErrJWTWrongAlg         = errors.New("Wrong algorithm")
+	ErrJWTInvalidIssuer    = errors.New("Invalid issuer")
+	ErrJWTInvalidAudience  = errors.New("Invalid audience")
+	ErrJWTMissingClaim     = errors.New("Missing claim")
+	ErrJWTUnknownKey       = errors.New("Unknown key")
+	ErrJWTTokenRevoked     = errors.New("Token revoked")
 )
 
+// JWTClaimsRule is an additional validation rule applied to the claims of a token
+type JWTClaimsRule func(claims jwt.Claims) error
+
+// JWT is a middleware verifying JSON Web Tokens.
+//
+// The claims of a valid token are stored in the request context under ContextKey.
+// Requests without a token are passed to the next handler; use EnsureAuthenticated to reject them.
+//
+// Tokens are verified with SigningKey or, if set, with the key of KeySet selected by the kid header of the token.
+// The registered claims exp, nbf and iat are validated with a tolerance of Leeway,
+// iss and aud are checked against Issuer and Audience if set.
 type JWT struct {
 	SigningKey    interface{}
 	SigningMethod string
 	ContextKey    interface{}
+
+	// SigningMethods lists the accepted algorithms in addition to SigningMethod, e.g. []string{"RS256", "ES256"}
+	SigningMethods []string
+	// KeySet provides the keys used to verify tokens, for example a JWKS. It takes precedence over SigningKey.
+	KeySet JWTKeySet
+
+	// Extractor extracts the token from the request. It defaults to the Authorization header.
+	Extractor request.Extractor
+	// Claims returns the value into which the claims of a token are decoded, e.g. func() jwt.Claims { return &MyClaims{} }.
+	// It defaults to jwt.MapClaims.
+	Claims func() jwt.Claims
+
+	// Issuer is the expected iss claim, if not empty
+	Issuer string
+	// Audience is the expected aud claim, if not empty
+	Audience string
+	// Leeway is the clock skew tolerated when validating exp, nbf and iat
+	Leeway time.Duration
+	// RequiredClaims lists the claims that must be present in a token
+	RequiredClaims []string
+	// Rules are additional validation rules applied to the claims
+	Rules []JWTClaimsRule
+	// Revocations, if set, is consulted to reject revoked tokens identified by their jti claim
+	Revocations JWTRevocationStore
+
+	// ErrorHandler responds to requests with an invalid token.
+	// err is one of the ErrJWT errors, a jwt-go error or an error returned by Rules.
+	// By default, it responds with 400 Bad Request and the message of err.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
 }
 
 func NewJWT(secret []byte) *JWT {
@@ -30,59 +81,221 @@ func NewJWT(secret []byte) *JWT {
 
 func (j *JWT) ServeNext(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		c := lion.C(r)
+		extractor := j.Extractor
+		if extractor == nil {
+			extractor = request.AuthorizationHeaderExtractor
+		}
 
-		if h := r.Header.Get("Authorization"); h != "" {
-			// Parse token from request
-			token, err := request.ParseFromRequest(r, request.AuthorizationHeaderExtractor, func(token *jwt.Token) (interface{}, error) {
-				if token.Method.Alg() != j.SigningMethod {
-					return nil, errJWTWrongAlg
-				}
-				return j.SigningKey, nil
-			})
-
-			// Respond with error: 400 Bad Request
-			if err != nil {
-				c.WithStatus(http.StatusBadRequest)
-
-				if vErr, ok := err.(*jwt.ValidationError); ok {
-
-					switch vErr.Errors {
-					case jwt.ValidationErrorExpired:
-						err = errJWTTokenExpired
-					case jwt.ValidationErrorNotValidYet:
-						err = errJWTTokenNotValidYet
-					case jwt.ValidationErrorMalformed:
-						err = errJWTTokenMalformed
-					default:
-						err = vErr
-					}
+		raw, err := extractor.ExtractToken(r)
+		if err == request.ErrNoTokenInRequest || raw == "" {
+			// If there is no token then continue to the next handler
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			j.error(w, r, err)
+			return
+		}
+
+		claims, fields, err := j.parse(raw)
+		if err != nil {
+			j.error(w, r, err)
+			return
+		}
 
-					c.Error(err)
+		if j.Revocations != nil {
+			var jti string
+			json.Unmarshal(fields["jti"], &jti)
+			if jti != "" {
+				revoked, err := j.Revocations.IsRevoked(r.Context(), jti)
+				if err != nil {
+					if j.ErrorHandler != nil {
+						j.ErrorHandler(w, r, err)
+						return
+					}
+					writeError(w, lion.ErrorInternalServer)
+					return
+				}
+				if revoked {
+					j.error(w, r, ErrJWTTokenRevoked)
 					return
 				}
-
-				c.Error(err)
-				return
 			}
+		}
 
-			// Token invalid respond with error: 401 Unauthorized
-			if !token.Valid {
-				c.Error(lion.ErrorUnauthorized)
-				return
+		var sub string
+		json.Unmarshal(fields["sub"], &sub)
+		setAccessLogSubject(r, sub)
+		if c := lion.C(r); c != nil && sub != "" {
+			c.WithLogAttrs("subject", sub)
+		}
+
+		// Adding claims to context key and continue to next handler
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, j.ContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// Parse verifies a raw token and validates its claims.
+// It does not consult Revocations.
+func (j *JWT) Parse(raw string) (jwt.Claims, error) {
+	claims, _, err := j.parse(raw)
+	return claims, err
+}
+
+// parse verifies a raw token and returns its claims along with the raw fields of its payload
+func (j *JWT) parse(raw string) (jwt.Claims, map[string]json.RawMessage, error) {
+	var claims jwt.Claims = jwt.MapClaims{}
+	if j.Claims != nil {
+		claims = j.Claims()
+	}
+
+	// Claims are validated below to take the leeway into account
+	parser := &jwt.Parser{ValidMethods: j.methods(), SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(raw, claims, j.keyFunc)
+	if err != nil {
+		if vErr, ok := err.(*jwt.ValidationError); ok {
+			switch {
+			case vErr.Errors == jwt.ValidationErrorMalformed:
+				return nil, nil, ErrJWTTokenMalformed
+			case vErr.Errors&jwt.ValidationErrorSignatureInvalid != 0 && vErr.Inner == nil:
+				return nil, nil, ErrJWTWrongAlg
+			case vErr.Inner != nil:
+				return nil, nil, vErr.Inner
 			}
+		}
+		return nil, nil, err
+	}
 
-			// Adding token to context key and continue to next handler
-			ctx := r.Context()
-			ctx = context.WithValue(ctx, j.ContextKey, token.Claims)
-			next.ServeHTTP(w, r.WithContext(ctx))
-		} else {
-			// If there is no Authorization header then continue to the next handler
-			next.ServeHTTP(w, r)
+	// Token invalid respond with error: 401 Unauthorized
+	if !token.Valid {
+		return nil, nil, lion.ErrorUnauthorized
+	}
+
+	fields, err := j.validate(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, rule := range j.Rules {
+		if err := rule(token.Claims); err != nil {
+			return nil, nil, err
 		}
 	}
+	return token.Claims, fields, nil
+}
 
-	return http.HandlerFunc(fn)
+func (j *JWT) methods() []string {
+	if j.SigningMethod == "" {
+		return j.SigningMethods
+	}
+	return append([]string{j.SigningMethod}, j.SigningMethods...)
+}
+
+func (j *JWT) keyFunc(token *jwt.Token) (interface{}, error) {
+	if len(j.methods()) == 0 {
+		return nil, ErrJWTWrongAlg
+	}
+	if j.KeySet == nil {
+		return j.SigningKey, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	return j.KeySet.Key(kid, token.Method.Alg())
+}
+
+// validate validates the registered claims of the payload of raw and returns its fields
+func (j *JWT) validate(raw string) (map[string]json.RawMessage, error) {
+	payload, err := jwt.DecodeSegment(strings.Split(raw, ".")[1])
+	if err != nil {
+		return nil, ErrJWTTokenMalformed
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, ErrJWTTokenMalformed
+	}
+
+	for _, name := range j.RequiredClaims {
+		if _, ok := fields[name]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrJWTMissingClaim, name)
+		}
+	}
+
+	now := time.Now()
+	if exp, ok, err := numericDate(fields["exp"]); err != nil {
+		return nil, err
+	} else if ok && now.After(exp.Add(j.Leeway)) {
+		return nil, ErrJWTTokenExpired
+	}
+	if nbf, ok, err := numericDate(fields["nbf"]); err != nil {
+		return nil, err
+	} else if ok && now.Add(j.Leeway).Before(nbf) {
+		return nil, ErrJWTTokenNotValidYet
+	}
+	if iat, ok, err := numericDate(fields["iat"]); err != nil {
+		return nil, err
+	} else if ok && now.Add(j.Leeway).Before(iat) {
+		return nil, ErrJWTTokenNotValidYet
+	}
+
+	if j.Issuer != "" {
+		var iss string
+		if json.Unmarshal(fields["iss"], &iss) != nil || iss != j.Issuer {
+			return nil, ErrJWTInvalidIssuer
+		}
+	}
+	if j.Audience != "" && !containsAudience(fields["aud"], j.Audience) {
+		return nil, ErrJWTInvalidAudience
+	}
+	return fields, nil
+}
+
+// numericDate decodes a NumericDate claim (RFC 7519, section 2)
+func numericDate(raw json.RawMessage) (time.Time, bool, error) {
+	if raw == nil || string(raw) == "null" {
+		return time.Time{}, false, nil
+	}
+	var seconds float64
+	if err := json.Unmarshal(raw, &seconds); err != nil {
+		return time.Time{}, false, ErrJWTTokenMalformed
+	}
+	sec := int64(seconds)
+	return time.Unix(sec, int64((seconds-float64(sec))*float64(time.Second))), true, nil
+}
+
+// containsAudience reports whether the aud claim, a string or an array of strings, contains audience
+func containsAudience(raw json.RawMessage, audience string) bool {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return single == audience
+	}
+	var multiple []string
+	if json.Unmarshal(raw, &multiple) != nil {
+		return false
+	}
+	for _, aud := range multiple {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *JWT) error(w http.ResponseWriter, r *http.Request, err error) {
+	if j.ErrorHandler != nil {
+		j.ErrorHandler(w, r, err)
+		return
+	}
+	if herr, ok := err.(lion.HTTPError); ok {
+		writeError(w, herr)
+		return
+	}
+	// Respond with error: 400 Bad Request
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	io.WriteString(w, err.Error())
 }
 
 func (j *JWT) EnsureAuthenticated() lion.Middleware {
@@ -104,3 +317,33 @@ func JWTEnsureAuthenticated(contextKey interface{}) lion.Middleware {
 	}
 	return lion.MiddlewareFunc(mw)
 }
+
+// JWTCookieExtractor extracts tokens from the cookie named name
+func JWTCookieExtractor(name string) request.Extractor {
+	return jwtCookieExtractor(name)
+}
+
+type jwtCookieExtractor string
+
+func (e jwtCookieExtractor) ExtractToken(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(string(e))
+	if err != nil || cookie.Value == "" {
+		return "", request.ErrNoTokenInRequest
+	}
+	return cookie.Value, nil
+}
+
+// JWTQueryExtractor extracts tokens from the query parameter named name.
+// Unlike request.ArgumentExtractor, it does not parse the body of the request.
+func JWTQueryExtractor(name string) request.Extractor {
+	return jwtQueryExtractor(name)
+}
+
+type jwtQueryExtractor string
+
+func (e jwtQueryExtractor) ExtractToken(r *http.Request) (string, error) {
+	if token := r.URL.Query().Get(string(e)); token != "" {
+		return token, nil
+	}
+	return "", request.ErrNoTokenInRequest
+}
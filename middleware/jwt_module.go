@@ -0,0 +1,433 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celrenheit/lion"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// DefaultJWTAccessTokenTTL is the default lifetime of access tokens issued by JWTModule
+	DefaultJWTAccessTokenTTL = 15 * time.Minute
+	// DefaultJWTRefreshTokenTTL is the default lifetime of refresh tokens issued by JWTModule
+	DefaultJWTRefreshTokenTTL = 7 * 24 * time.Hour
+
+	// ErrJWTInvalidCredentials should be returned by a JWTCredentialsVerifier when credentials are invalid
+	ErrJWTInvalidCredentials = errors.New("Invalid credentials")
+
+	// ErrJWTWrongTokenUse is returned when a refresh token is used as an access token or the reverse
+	ErrJWTWrongTokenUse = errors.New("Wrong token use")
+)
+
+const (
+	jwtTokenUseClaim = "token_use"
+	jwtAccessToken   = "access"
+	jwtRefreshToken  = "refresh"
+)
+
+// JWTAccessTokensOnly is a JWTClaimsRule rejecting the refresh tokens issued by JWTModule.
+//
+// Claims types other than jwt.MapClaims must decode the token_use claim, e.g. with a field
+// TokenUse string `json:"token_use"`. Tokens whose use cannot be determined are rejected.
+func JWTAccessTokensOnly(claims jwt.Claims) error {
+	c, ok := claims.(jwt.MapClaims)
+	if !ok {
+		b, err := json.Marshal(claims)
+		if err != nil || json.Unmarshal(b, &c) != nil {
+			return ErrJWTWrongTokenUse
+		}
+		if _, ok := c[jwtTokenUseClaim]; !ok {
+			return ErrJWTWrongTokenUse
+		}
+	}
+	if c[jwtTokenUseClaim] == jwtRefreshToken {
+		return ErrJWTWrongTokenUse
+	}
+	return nil
+}
+
+// JWTCredentialsVerifier verifies the credentials submitted to JWTModule
+type JWTCredentialsVerifier interface {
+	// Verify returns the subject of the tokens to issue for username and password, or ErrJWTInvalidCredentials
+	Verify(ctx context.Context, username, password string) (subject string, err error)
+}
+
+// JWTCredentialsVerifierFunc is an adapter to use a function as a JWTCredentialsVerifier
+type JWTCredentialsVerifierFunc func(ctx context.Context, username, password string) (string, error)
+
+// Verify calls fn(ctx, username, password)
+func (fn JWTCredentialsVerifierFunc) Verify(ctx context.Context, username, password string) (string, error) {
+	return fn(ctx, username, password)
+}
+
+// JWTRevocationStore keeps the identifiers (jti) of revoked tokens
+type JWTRevocationStore interface {
+	// Revoke revokes the token identified by jti. The entry can be forgotten after expiresAt, once the token has expired.
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	// IsRevoked reports whether the token identified by jti has been revoked
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	// RevokeOnce revokes the token identified by jti and reports whether it was not already revoked.
+	// It must be atomic, so that a refresh token used concurrently is only accepted once.
+	RevokeOnce(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+}
+
+// JWTModule is a Module issuing, refreshing and revoking JSON Web Tokens.
+//
+// It registers the following routes under Path, accepting JSON or form encoded bodies:
+//
+//	POST /token    username and password: issues an access token and a refresh token
+//	POST /refresh  refresh_token: issues new tokens and revokes the refresh token
+//	POST /revoke   token and the access token of the Authorization header: revokes them
+//
+// Tokens are verified by JWT, which must be used by the protected routes so that revoked tokens are rejected:
+//
+//	auth := middleware.NewJWTModule(secret, verifier)
+//	l.Module(auth)
+//	api := l.Group("/api", auth.JWT, auth.JWT.EnsureAuthenticated())
+type JWTModule struct {
+	// Path is the base path of the module
+	Path string
+
+	// SigningKey signs the issued tokens. For asymmetric algorithms, it is the private key of the public one used by JWT.
+	SigningKey interface{}
+	// SigningMethod signs the issued tokens
+	SigningMethod jwt.SigningMethod
+	// KeyID, if not empty, is set as the kid header of issued tokens
+	KeyID string
+	// Issuer and Audience are set as the iss and aud claims of issued tokens
+	Issuer   string
+	Audience string
+
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Verifier verifies the credentials sent to /token
+	Verifier JWTCredentialsVerifier
+	// Claims returns additional claims for the access tokens of subject
+	Claims func(ctx context.Context, subject string) (jwt.MapClaims, error)
+	// Revocations keeps the revoked tokens. It should be the store used by JWT.
+	Revocations JWTRevocationStore
+	// JWT verifies tokens. It should use JWTAccessTokensOnly to reject refresh tokens.
+	JWT *JWT
+}
+
+// NewJWTModule creates a JWTModule issuing tokens signed with secret using HS256, mounted at /auth.
+// Its JWT verifies these tokens and rejects revoked ones, using an in-memory revocation store.
+func NewJWTModule(secret []byte, verifier JWTCredentialsVerifier) *JWTModule {
+	revocations := NewMemoryJWTRevocationStore()
+	j := NewJWT(secret)
+	j.Rules = []JWTClaimsRule{JWTAccessTokensOnly}
+	j.Revocations = revocations
+
+	return &JWTModule{
+		Path:            "/auth",
+		SigningKey:      secret,
+		SigningMethod:   jwt.SigningMethodHS256,
+		AccessTokenTTL:  DefaultJWTAccessTokenTTL,
+		RefreshTokenTTL: DefaultJWTRefreshTokenTTL,
+		Verifier:        verifier,
+		Revocations:     revocations,
+		JWT:             j,
+	}
+}
+
+// Base implements lion.Module
+func (m *JWTModule) Base() string {
+	return m.Path
+}
+
+// Routes implements lion.Module
+func (m *JWTModule) Routes(r *lion.Router) {
+	r.PostFunc("/token", m.token)
+	r.PostFunc("/refresh", m.refresh)
+	r.PostFunc("/revoke", m.revoke)
+}
+
+// JWTTokens are the tokens issued by JWTModule
+type JWTTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// Issue issues an access token and a refresh token for subject
+func (m *JWTModule) Issue(ctx context.Context, subject string) (*JWTTokens, error) {
+	now := time.Now()
+
+	claims := jwt.MapClaims{}
+	if m.Claims != nil {
+		extra, err := m.Claims(ctx, subject)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range extra {
+			claims[k] = v
+		}
+	}
+	access, err := m.sign(claims, subject, jwtAccessToken, now, m.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := m.sign(jwt.MapClaims{}, subject, jwtRefreshToken, now, m.RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JWTTokens{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(m.AccessTokenTTL / time.Second),
+	}, nil
+}
+
+func (m *JWTModule) sign(claims jwt.MapClaims, subject, use string, now time.Time, ttl time.Duration) (string, error) {
+	jti, err := newJTI()
+	if err != nil {
+		return "", err
+	}
+	claims["jti"] = jti
+	claims["sub"] = subject
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+	claims[jwtTokenUseClaim] = use
+	if m.Issuer != "" {
+		claims["iss"] = m.Issuer
+	}
+	if m.Audience != "" {
+		claims["aud"] = m.Audience
+	}
+
+	token := jwt.NewWithClaims(m.SigningMethod, claims)
+	if m.KeyID != "" {
+		token.Header["kid"] = m.KeyID
+	}
+	return token.SignedString(m.SigningKey)
+}
+
+// Revoke verifies raw and revokes it until it expires
+func (m *JWTModule) Revoke(ctx context.Context, raw string) error {
+	claims, err := m.parse(ctx, raw)
+	if err != nil {
+		return err
+	}
+	return m.revokeClaims(ctx, claims)
+}
+
+func (m *JWTModule) revokeClaims(ctx context.Context, claims jwt.MapClaims) error {
+	jti, expiresAt := revocationOf(claims)
+	if jti == "" {
+		return nil
+	}
+	return m.Revocations.Revoke(ctx, jti, expiresAt)
+}
+
+func revocationOf(claims jwt.MapClaims) (jti string, expiresAt time.Time) {
+	jti, _ = claims["jti"].(string)
+	if exp, ok := claims["exp"].(float64); ok {
+		expiresAt = time.Unix(int64(exp), 0)
+	}
+	return jti, expiresAt
+}
+
+// parse verifies raw, including refresh tokens, and checks that it has not been revoked
+func (m *JWTModule) parse(ctx context.Context, raw string) (jwt.MapClaims, error) {
+	verifier := *m.JWT
+	verifier.Claims = nil
+	verifier.Rules = nil
+
+	claims, err := verifier.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	mc := claims.(jwt.MapClaims)
+	if jti, _ := mc["jti"].(string); jti != "" && m.Revocations != nil {
+		revoked, err := m.Revocations.IsRevoked(ctx, jti)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrJWTTokenRevoked
+		}
+	}
+	return mc, nil
+}
+
+func (m *JWTModule) token(w http.ResponseWriter, r *http.Request) {
+	params, err := readParams(r)
+	if err != nil {
+		writeError(w, lion.ErrorBadRequest)
+		return
+	}
+
+	subject, err := m.Verifier.Verify(r.Context(), params["username"], params["password"])
+	if err == ErrJWTInvalidCredentials {
+		writeError(w, lion.ErrorUnauthorized)
+		return
+	}
+	if err != nil {
+		writeError(w, lion.ErrorInternalServer)
+		return
+	}
+	m.issue(w, r, subject)
+}
+
+func (m *JWTModule) refresh(w http.ResponseWriter, r *http.Request) {
+	params, err := readParams(r)
+	if err != nil {
+		writeError(w, lion.ErrorBadRequest)
+		return
+	}
+
+	claims, err := m.parse(r.Context(), params["refresh_token"])
+	if err == nil && claims[jwtTokenUseClaim] != jwtRefreshToken {
+		err = ErrJWTWrongTokenUse
+	}
+	if err != nil {
+		writeError(w, lion.ErrorUnauthorized)
+		return
+	}
+
+	// Rotate refresh tokens: each one can only be used once, even by concurrent requests
+	jti, expiresAt := revocationOf(claims)
+	if jti == "" {
+		writeError(w, lion.ErrorUnauthorized)
+		return
+	}
+	first, err := m.Revocations.RevokeOnce(r.Context(), jti, expiresAt)
+	if err != nil {
+		writeError(w, lion.ErrorInternalServer)
+		return
+	}
+	if !first {
+		writeError(w, lion.ErrorUnauthorized)
+		return
+	}
+	subject, _ := claims["sub"].(string)
+	m.issue(w, r, subject)
+}
+
+func (m *JWTModule) revoke(w http.ResponseWriter, r *http.Request) {
+	params, err := readParams(r)
+	if err != nil {
+		writeError(w, lion.ErrorBadRequest)
+		return
+	}
+
+	tokens := []string{params["token"]}
+	if h := r.Header.Get("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		tokens = append(tokens, h[7:])
+	}
+	for _, raw := range tokens {
+		if raw == "" {
+			continue
+		}
+		// Invalid tokens are ignored (RFC 7009, section 2.2)
+		claims, err := m.parse(r.Context(), raw)
+		if err != nil {
+			continue
+		}
+		if err := m.revokeClaims(r.Context(), claims); err != nil {
+			writeError(w, lion.ErrorInternalServer)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (m *JWTModule) issue(w http.ResponseWriter, r *http.Request, subject string) {
+	tokens, err := m.Issue(r.Context(), subject)
+	if err != nil {
+		writeError(w, lion.ErrorInternalServer)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// readParams reads the parameters of a JSON or form encoded request body
+func readParams(r *http.Request) (map[string]string, error) {
+	params := make(map[string]string)
+	if mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediatype == "application/json" {
+		err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&params)
+		return params, err
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	for k := range r.PostForm {
+		params[k] = r.PostForm.Get(k)
+	}
+	return params, nil
+}
+
+func newJTI() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MemoryJWTRevocationStore is a JWTRevocationStore keeping revoked tokens in memory until they expire
+type MemoryJWTRevocationStore struct {
+	mu        sync.RWMutex
+	revoked   map[string]time.Time
+	nextSweep time.Time
+}
+
+// NewMemoryJWTRevocationStore creates an empty MemoryJWTRevocationStore
+func NewMemoryJWTRevocationStore() *MemoryJWTRevocationStore {
+	return &MemoryJWTRevocationStore{revoked: make(map[string]time.Time)}
+}
+
+// Revoke implements JWTRevocationStore
+func (s *MemoryJWTRevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.RevokeOnce(ctx, jti, expiresAt)
+	return nil
+}
+
+// RevokeOnce implements JWTRevocationStore
+func (s *MemoryJWTRevocationStore) RevokeOnce(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.nextSweep) {
+		for id, exp := range s.revoked {
+			if !exp.IsZero() && now.After(exp) {
+				delete(s.revoked, id)
+			}
+		}
+		s.nextSweep = now.Add(time.Minute)
+	}
+	if _, ok := s.revoked[jti]; ok {
+		return false, nil
+	}
+	s.revoked[jti] = expiresAt
+	return true, nil
+}
+
+// IsRevoked implements JWTRevocationStore
+func (s *MemoryJWTRevocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.revoked[jti]
+	return ok, nil
+}
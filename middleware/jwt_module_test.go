@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestJWTModule(t *testing.T) {
+	auth := NewJWTModule([]byte("secret"), JWTCredentialsVerifierFunc(func(ctx context.Context, username, password string) (string, error) {
+		if username != "lion" || password != "argh" {
+			return "", ErrJWTInvalidCredentials
+		}
+		return "42", nil
+	}))
+	auth.Claims = func(ctx context.Context, subject string) (jwt.MapClaims, error) {
+		return jwt.MapClaims{"role": "admin"}, nil
+	}
+
+	l := lion.New()
+	l.Module(auth)
+	l.Group("/api", auth.JWT, auth.JWT.EnsureAuthenticated()).GetFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		claims := r.Context().Value(DefaultJWTContextKey).(jwt.MapClaims)
+		w.Write([]byte(claims["sub"].(string) + " " + claims["role"].(string)))
+	})
+
+	test := htest.New(t, l)
+	form := func(values url.Values) string { return values.Encode() }
+	issue := func(path, body, contentType string) JWTTokens {
+		var tokens JWTTokens
+		res := test.Post(path).SetHeader("Content-Type", contentType).SendString(body).Do().
+			ExpectStatus(http.StatusOK).
+			ExpectHeader("Cache-Control", "no-store")
+		if err := json.Unmarshal(res.Recorder().Body.Bytes(), &tokens); err != nil {
+			t.Fatal(err)
+		}
+		return tokens
+	}
+
+	test.Post("/auth/token").SetHeader("Content-Type", "application/x-www-form-urlencoded").
+		SendString(form(url.Values{"username": {"lion"}, "password": {"wrong"}})).Do().
+		ExpectStatus(http.StatusUnauthorized)
+
+	tokens := issue("/auth/token", form(url.Values{"username": {"lion"}, "password": {"argh"}}), "application/x-www-form-urlencoded")
+	if tokens.TokenType != "Bearer" || tokens.ExpiresIn != int64(DefaultJWTAccessTokenTTL.Seconds()) {
+		t.Errorf("Incorrect tokens: %+v", tokens)
+	}
+	test.Get("/api/me").SetHeader("Authorization", "Bearer "+tokens.AccessToken).Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("42 admin")
+	// Refresh tokens are not access tokens
+	test.Get("/api/me").SetHeader("Authorization", "Bearer "+tokens.RefreshToken).Do().
+		ExpectStatus(http.StatusBadRequest).
+		ExpectBody(ErrJWTWrongTokenUse.Error())
+
+	// Refresh tokens are rotated
+	refreshed := issue("/auth/refresh", `{"refresh_token": "`+tokens.RefreshToken+`"}`, "application/json")
+	if refreshed.RefreshToken == tokens.RefreshToken {
+		t.Errorf("The refresh token should have been rotated")
+	}
+	test.Post("/auth/refresh").SetHeader("Content-Type", "application/json").
+		SendString(`{"refresh_token": "` + tokens.RefreshToken + `"}`).Do().
+		ExpectStatus(http.StatusUnauthorized)
+	// Access tokens cannot be used to refresh
+	test.Post("/auth/refresh").SetHeader("Content-Type", "application/json").
+		SendString(`{"refresh_token": "` + refreshed.AccessToken + `"}`).Do().
+		ExpectStatus(http.StatusUnauthorized)
+
+	// Logout revokes both tokens
+	test.Post("/auth/revoke").SetHeader("Authorization", "Bearer "+refreshed.AccessToken).
+		SetHeader("Content-Type", "application/json").
+		SendString(`{"token": "` + refreshed.RefreshToken + `"}`).Do().
+		ExpectStatus(http.StatusOK)
+	test.Get("/api/me").SetHeader("Authorization", "Bearer "+refreshed.AccessToken).Do().
+		ExpectStatus(http.StatusBadRequest).
+		ExpectBody(ErrJWTTokenRevoked.Error())
+	test.Post("/auth/refresh").SetHeader("Content-Type", "application/json").
+		SendString(`{"refresh_token": "` + refreshed.RefreshToken + `"}`).Do().
+		ExpectStatus(http.StatusUnauthorized)
+	// The first access token is still valid
+	test.Get("/api/me").SetHeader("Authorization", "Bearer "+tokens.AccessToken).Do().
+		ExpectStatus(http.StatusOK)
+}
+
+func TestJWTModuleConcurrentRefresh(t *testing.T) {
+	auth := NewJWTModule([]byte("secret"), JWTCredentialsVerifierFunc(func(ctx context.Context, username, password string) (string, error) {
+		return "42", nil
+	}))
+	l := lion.New()
+	l.Module(auth)
+
+	test := htest.New(t, l)
+	var tokens JWTTokens
+	res := test.Post("/auth/token").SetHeader("Content-Type", "application/json").
+		SendString(`{"username": "lion", "password": "argh"}`).Do().
+		ExpectStatus(http.StatusOK)
+	if err := json.Unmarshal(res.Recorder().Body.Bytes(), &tokens); err != nil {
+		t.Fatal(err)
+	}
+
+	// A refresh token used concurrently is only accepted once
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	accepted := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/auth/refresh", strings.NewReader(`{"refresh_token": "`+tokens.RefreshToken+`"}`))
+			r.Header.Set("Content-Type", "application/json")
+			l.ServeHTTP(w, r)
+			if w.Code == http.StatusOK {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("The refresh token should be accepted once but was accepted %d times", accepted)
+	}
+}
+
+type tokenUseClaims struct {
+	jwt.StandardClaims
+	TokenUse string `json:"token_use"`
+}
+
+func TestJWTAccessTokensOnly(t *testing.T) {
+	tests := []struct {
+		claims jwt.Claims
+		err    error
+	}{
+		{jwt.MapClaims{"token_use": "access"}, nil},
+		{jwt.MapClaims{}, nil},
+		{jwt.MapClaims{"token_use": "refresh"}, ErrJWTWrongTokenUse},
+		{&tokenUseClaims{TokenUse: "access"}, nil},
+		{&tokenUseClaims{TokenUse: "refresh"}, ErrJWTWrongTokenUse},
+		// The use of the token cannot be determined
+		{&jwt.StandardClaims{Subject: "42"}, ErrJWTWrongTokenUse},
+	}
+	for _, test := range tests {
+		if err := JWTAccessTokensOnly(test.claims); err != test.err {
+			t.Errorf("JWTAccessTokensOnly(%#v): expected %v but got %v", test.claims, test.err, err)
+		}
+	}
+}
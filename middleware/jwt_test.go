@@ -10,6 +10,7 @@ import (
 	"github.com/celrenheit/htest"
 	"github.com/celrenheit/lion"
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/dgrijalva/jwt-go/request"
 )
 
 func TestJWT(t *testing.T) {
@@ -28,7 +29,7 @@ func TestJWT(t *testing.T) {
 	test.Get("/private").
 		SetHeader("Authorization", "Bearer "+invalidAlg).Do().
 		ExpectStatus(400).
-		ExpectBody(errJWTWrongAlg.Error())
+		ExpectBody(ErrJWTWrongAlg.Error())
 
 	// Invalid key
 	r = newTestJWTRouter("HS256", "invalidsecret")
@@ -46,7 +47,7 @@ func TestJWT(t *testing.T) {
 	test.Get("/private").
 		SetHeader("Authorization", "Bearer test").Do().
 		ExpectStatus(400).
-		ExpectBody(errJWTTokenMalformed.Error())
+		ExpectBody(ErrJWTTokenMalformed.Error())
 
 	// Expired token
 	r = newTestJWTRouter("HS256", "secret")
@@ -57,7 +58,7 @@ func TestJWT(t *testing.T) {
 		SetHeader("Authorization", "Bearer "+expiredToken).
 		Do().
 		ExpectStatus(400).
-		ExpectBody(errJWTTokenExpired.Error())
+		ExpectBody(ErrJWTTokenExpired.Error())
 
 	// Not valid yet token
 	r = newTestJWTRouter("HS256", "secret")
@@ -68,7 +69,7 @@ func TestJWT(t *testing.T) {
 		SetHeader("Authorization", "Bearer "+notvalidyetToken).
 		Do().
 		ExpectStatus(400).
-		ExpectBody(errJWTTokenNotValidYet.Error())
+		ExpectBody(ErrJWTTokenNotValidYet.Error())
 
 	// RS256
 	r = newTestJWTRouter("RS256", `
@@ -84,6 +85,103 @@ MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDdlatRjRjogo3WojgGHFHYLugdUWAY9iR3fy4arWNA
 		ExpectStatus(200)
 }
 
+func TestJWTClaimsValidation(t *testing.T) {
+	j := &JWT{
+		SigningKey:     []byte("secret"),
+		SigningMethod:  "HS256",
+		ContextKey:     DefaultJWTContextKey,
+		Issuer:         "https://auth.example.com",
+		Audience:       "lion",
+		Leeway:         time.Minute,
+		RequiredClaims: []string{"sub"},
+		Rules: []JWTClaimsRule{func(claims jwt.Claims) error {
+			if claims.(jwt.MapClaims)["sub"] == "banned" {
+				return lion.ErrorForbidden
+			}
+			return nil
+		}},
+	}
+	test := htest.New(t, j.ServeNext(fakeHandler()))
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": "https://auth.example.com",
+			"aud": []string{"other", "lion"},
+			"sub": "1234",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+	tests := []struct {
+		update   func(jwt.MapClaims)
+		status   int
+		expected string
+	}{
+		{func(c jwt.MapClaims) {}, http.StatusOK, ""},
+		{func(c jwt.MapClaims) { c["aud"] = "lion" }, http.StatusOK, ""},
+		// Within the leeway
+		{func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-30 * time.Second).Unix() }, http.StatusOK, ""},
+		{func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(30 * time.Second).Unix() }, http.StatusOK, ""},
+		{func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }, http.StatusBadRequest, ErrJWTTokenExpired.Error()},
+		{func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(2 * time.Minute).Unix() }, http.StatusBadRequest, ErrJWTTokenNotValidYet.Error()},
+		{func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, http.StatusBadRequest, ErrJWTInvalidIssuer.Error()},
+		{func(c jwt.MapClaims) { c["aud"] = "other" }, http.StatusBadRequest, ErrJWTInvalidAudience.Error()},
+		{func(c jwt.MapClaims) { delete(c, "sub") }, http.StatusBadRequest, ErrJWTMissingClaim.Error() + ": sub"},
+		{func(c jwt.MapClaims) { c["sub"] = "banned" }, http.StatusForbidden, "Forbidden"},
+	}
+	for _, tt := range tests {
+		claims := valid()
+		tt.update(claims)
+		res := test.Get("/").SetHeader("Authorization", "Bearer "+signToken(t, jwt.SigningMethodHS256, "", []byte("secret"), claims)).Do().
+			ExpectStatus(tt.status)
+		if tt.expected != "" {
+			res.ExpectBody(tt.expected)
+		}
+	}
+}
+
+type testJWTClaims struct {
+	jwt.StandardClaims
+	Role string `json:"role"`
+}
+
+func TestJWTExtractorsAndTypedClaims(t *testing.T) {
+	j := NewJWT([]byte("secret"))
+	j.Extractor = request.MultiExtractor{JWTCookieExtractor("token"), JWTQueryExtractor("access_token")}
+	j.Claims = func() jwt.Claims { return &testJWTClaims{} }
+	j.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("custom: " + err.Error()))
+	}
+
+	handler := j.ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := r.Context().Value(DefaultJWTContextKey).(*testJWTClaims)
+		if claims == nil {
+			w.Write([]byte("anonymous"))
+			return
+		}
+		w.Write([]byte(claims.Subject + " " + claims.Role))
+	}))
+	test := htest.New(t, handler)
+
+	token := signToken(t, jwt.SigningMethodHS256, "", []byte("secret"), &testJWTClaims{
+		StandardClaims: jwt.StandardClaims{Subject: "1234"},
+		Role:           "admin",
+	})
+	test.Get("/").SetHeader("Cookie", "token="+token).Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("1234 admin")
+	test.Get("/?access_token=" + token).Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("1234 admin")
+	// The Authorization header is not used
+	test.Get("/").SetHeader("Authorization", "Bearer "+token).Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("anonymous")
+	test.Get("/?access_token=invalid").Do().
+		ExpectStatus(http.StatusUnauthorized).
+		ExpectBody("custom: " + ErrJWTTokenMalformed.Error())
+}
+
 func newTestJWTRouter(signingMethod string, key string) *lion.Router {
 	j := &JWT{
 		SigningKey:    []byte(key),
@@ -0,0 +1,465 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celrenheit/lion"
+)
+
+var (
+	// DefaultMetricsRegistry is the registry used by NewMetrics
+	DefaultMetricsRegistry = NewMetricsRegistry()
+
+	// DefaultLatencyBuckets are the buckets, in seconds, of the latency histogram
+	DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// DefaultSizeBuckets are the buckets, in bytes, of the response size histogram
+	DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+)
+
+var metricsLabels = []string{"method", "status", "host", "route"}
+
+// Metrics is a middleware recording the number of requests, their latency, the number of requests in flight and the size of responses.
+//
+// Metrics are labelled with the method, the status class (e.g. 2xx), and the host and route patterns, never with the raw path.
+// Use Handler to expose them in the Prometheus text format:
+//
+//	metrics := middleware.NewMetrics()
+//	l := lion.New(metrics)
+//	l.Get("/metrics", metrics.Handler())
+type Metrics struct {
+	// Registry holds the metrics, DefaultMetricsRegistry by default
+	Registry *MetricsRegistry
+	// Namespace prefixes the names of the metrics
+	Namespace      string
+	LatencyBuckets []float64
+	SizeBuckets    []float64
+
+	once     sync.Once
+	requests *MetricsCounter
+	latency  *MetricsHistogram
+	inFlight *MetricsGauge
+	size     *MetricsHistogram
+}
+
+// NewMetrics creates a Metrics middleware registering its metrics in the DefaultMetricsRegistry under the "lion" namespace
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Registry:       DefaultMetricsRegistry,
+		Namespace:      "lion",
+		LatencyBuckets: DefaultLatencyBuckets,
+		SizeBuckets:    DefaultSizeBuckets,
+	}
+}
+
+func (m *Metrics) init() {
+	if m.Registry == nil {
+		m.Registry = DefaultMetricsRegistry
+	}
+	prefix := ""
+	if m.Namespace != "" {
+		prefix = m.Namespace + "_"
+	}
+	m.requests = m.Registry.NewCounter(prefix+"http_requests_total", "Number of HTTP requests.", metricsLabels...)
+	m.latency = m.Registry.NewHistogram(prefix+"http_request_duration_seconds", "Latency of HTTP requests.", m.LatencyBuckets, metricsLabels...)
+	m.inFlight = m.Registry.NewGauge(prefix+"http_requests_in_flight", "Number of HTTP requests being served.", "method", "host", "route")
+	m.size = m.Registry.NewHistogram(prefix+"http_response_size_bytes", "Size of HTTP responses.", m.SizeBuckets, metricsLabels...)
+}
+
+// ServeNext implements the Middleware interface for Metrics.
+func (m *Metrics) ServeNext(next http.Handler) http.Handler {
+	m.once.Do(m.init)
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var host, route string
+		if c := lion.C(r); c != nil && c.Route() != nil {
+			host, route = c.Route().Host(), c.Route().Pattern()
+		}
+
+		m.inFlight.Inc(r.Method, host, route)
+		defer m.inFlight.Dec(r.Method, host, route)
+
+		res := wrapResponseWriter(w)
+		start := time.Now()
+
+		next.ServeHTTP(res, r)
+
+		status := res.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		class := strconv.Itoa(status/100) + "xx"
+		m.requests.Inc(r.Method, class, host, route)
+		m.latency.Observe(time.Since(start).Seconds(), r.Method, class, host, route)
+		m.size.Observe(float64(res.BytesWritten()), r.Method, class, host, route)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// Handler returns the handler exposing the metrics of the registry
+func (m *Metrics) Handler() http.Handler {
+	m.once.Do(m.init)
+	return m.Registry
+}
+
+// MetricsCollector writes custom metrics in the Prometheus text format.
+// It allows modules to expose metrics computed on scrape.
+type MetricsCollector interface {
+	WriteMetrics(w io.Writer) error
+}
+
+// MetricsCollectorFunc is an adapter to use a function as a MetricsCollector
+type MetricsCollectorFunc func(w io.Writer) error
+
+// WriteMetrics calls fn(w)
+func (fn MetricsCollectorFunc) WriteMetrics(w io.Writer) error {
+	return fn(w)
+}
+
+// MetricsRegistry holds metrics and exposes them in the Prometheus text format.
+// Modules can register their own metrics with NewCounter, NewGauge, NewHistogram, NewGaugeFunc or Register.
+type MetricsRegistry struct {
+	mu         sync.Mutex
+	families   map[string]metricFamily
+	collectors []MetricsCollector
+}
+
+// NewMetricsRegistry creates an empty MetricsRegistry
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{families: make(map[string]metricFamily)}
+}
+
+type metricFamily interface {
+	writeTo(w *bufio.Writer)
+}
+
+// register returns the family named name, creating it with create if needed.
+// It panics if a metric with the same name exists and cannot be reused.
+func (reg *MetricsRegistry) register(name string, create func() metricFamily, check func(metricFamily) bool) metricFamily {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if f, ok := reg.families[name]; ok {
+		if !check(f) {
+			panic("lion: metric " + name + " is already registered")
+		}
+		return f
+	}
+	f := create()
+	reg.families[name] = f
+	return f
+}
+
+// NewCounter returns the counter named name, registering it if needed
+func (reg *MetricsRegistry) NewCounter(name, help string, labels ...string) *MetricsCounter {
+	f := reg.register(name, func() metricFamily {
+		return &MetricsCounter{newMetricVec(name, help, "counter", labels)}
+	}, func(f metricFamily) bool {
+		_, ok := f.(*MetricsCounter)
+		return ok
+	})
+	return f.(*MetricsCounter)
+}
+
+// NewGauge returns the gauge named name, registering it if needed
+func (reg *MetricsRegistry) NewGauge(name, help string, labels ...string) *MetricsGauge {
+	f := reg.register(name, func() metricFamily {
+		return &MetricsGauge{newMetricVec(name, help, "gauge", labels)}
+	}, func(f metricFamily) bool {
+		_, ok := f.(*MetricsGauge)
+		return ok
+	})
+	return f.(*MetricsGauge)
+}
+
+// NewHistogram returns the histogram named name, registering it with buckets if needed
+func (reg *MetricsRegistry) NewHistogram(name, help string, buckets []float64, labels ...string) *MetricsHistogram {
+	f := reg.register(name, func() metricFamily {
+		b := append([]float64(nil), buckets...)
+		sort.Float64s(b)
+		return &MetricsHistogram{metricVec: newMetricVec(name, help, "histogram", labels), buckets: b}
+	}, func(f metricFamily) bool {
+		_, ok := f.(*MetricsHistogram)
+		return ok
+	})
+	return f.(*MetricsHistogram)
+}
+
+// NewGaugeFunc registers a gauge whose value is computed by fn on each scrape
+func (reg *MetricsRegistry) NewGaugeFunc(name, help string, fn func() float64) {
+	reg.register(name, func() metricFamily {
+		return &metricsGaugeFunc{name: name, help: help, fn: fn}
+	}, func(metricFamily) bool {
+		return false
+	})
+}
+
+// Register adds a collector whose output is appended to the metrics of the registry
+func (reg *MetricsRegistry) Register(c MetricsCollector) {
+	reg.mu.Lock()
+	reg.collectors = append(reg.collectors, c)
+	reg.mu.Unlock()
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (reg *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	reg.mu.Lock()
+	names := make([]string, 0, len(reg.families))
+	for name := range reg.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	families := make([]metricFamily, len(names))
+	for i, name := range names {
+		families[i] = reg.families[name]
+	}
+	collectors := append([]MetricsCollector(nil), reg.collectors...)
+	reg.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.writeTo(bw)
+	}
+	for _, c := range collectors {
+		if err := c.WriteMetrics(bw); err != nil {
+			bw.Flush()
+			return cw.n, err
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (reg *MetricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	reg.WriteTo(w)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// metricVec holds the series of a metric, indexed by their label values
+type metricVec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64 // per bucket, for histograms
+	count       uint64
+}
+
+func newMetricVec(name, help, typ string, labels []string) metricVec {
+	return metricVec{name: name, help: help, typ: typ, labels: labels, series: make(map[string]*metricSeries)}
+}
+
+// get returns the series of labelValues. It must be called with v.mu held.
+func (v *metricVec) get(labelValues []string) *metricSeries {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("lion: metric %s expects %d label values but got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *metricVec) add(delta float64, labelValues []string) {
+	v.mu.Lock()
+	v.get(labelValues).value += delta
+	v.mu.Unlock()
+}
+
+// sorted returns the series sorted by label values. It must be called with v.mu held.
+func (v *metricVec) sorted() []*metricSeries {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]*metricSeries, len(keys))
+	for i, key := range keys {
+		series[i] = v.series[key]
+	}
+	return series
+}
+
+func (v *metricVec) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeMetricHelp(v.help), v.name, v.typ)
+}
+
+func (v *metricVec) writeTo(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.writeHeader(w)
+	for _, s := range v.sorted() {
+		writeMetricSample(w, v.name, v.labels, s.labelValues, "", "", s.value)
+	}
+}
+
+// MetricsCounter is a counter with labels
+type MetricsCounter struct {
+	metricVec
+}
+
+// Inc increments the counter of labelValues
+func (c *MetricsCounter) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+// Add adds delta, which must not be negative, to the counter of labelValues
+func (c *MetricsCounter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("lion: counters cannot decrease")
+	}
+	c.add(delta, labelValues)
+}
+
+// MetricsGauge is a gauge with labels
+type MetricsGauge struct {
+	metricVec
+}
+
+// Set sets the gauge of labelValues to value
+func (g *MetricsGauge) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	g.get(labelValues).value = value
+	g.mu.Unlock()
+}
+
+// Add adds delta to the gauge of labelValues
+func (g *MetricsGauge) Add(delta float64, labelValues ...string) {
+	g.add(delta, labelValues)
+}
+
+// Inc increments the gauge of labelValues
+func (g *MetricsGauge) Inc(labelValues ...string) {
+	g.add(1, labelValues)
+}
+
+// Dec decrements the gauge of labelValues
+func (g *MetricsGauge) Dec(labelValues ...string) {
+	g.add(-1, labelValues)
+}
+
+// MetricsHistogram is a histogram with labels
+type MetricsHistogram struct {
+	metricVec
+	buckets []float64
+}
+
+// Observe adds value to the histogram of labelValues
+func (h *MetricsHistogram) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	s := h.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.value += value
+	h.mu.Unlock()
+}
+
+func (h *MetricsHistogram) writeTo(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, s := range h.sorted() {
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			writeMetricSample(w, h.name+"_bucket", h.labels, s.labelValues, "le", formatMetricValue(upper), float64(cumulative))
+		}
+		writeMetricSample(w, h.name+"_bucket", h.labels, s.labelValues, "le", "+Inf", float64(s.count))
+		writeMetricSample(w, h.name+"_sum", h.labels, s.labelValues, "", "", s.value)
+		writeMetricSample(w, h.name+"_count", h.labels, s.labelValues, "", "", float64(s.count))
+	}
+}
+
+type metricsGaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func (g *metricsGaugeFunc) writeTo(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, escapeMetricHelp(g.help), g.name)
+	writeMetricSample(w, g.name, nil, nil, "", "", g.fn())
+}
+
+func writeMetricSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeMetricLabel(values[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatMetricValue(value))
+	w.WriteByte('\n')
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var metricLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var metricHelpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeMetricLabel(s string) string {
+	return metricLabelReplacer.Replace(s)
+}
+
+func escapeMetricHelp(s string) string {
+	return metricHelpReplacer.Replace(s)
+}
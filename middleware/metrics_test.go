@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.Registry = NewMetricsRegistry()
+	metrics.LatencyBuckets = []float64{60}
+	metrics.SizeBuckets = []float64{1, 10}
+
+	l := lion.New(metrics)
+	l.GetFunc("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user"))
+	})
+	l.GetFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	l.Get("/metrics", metrics.Handler())
+
+	test := htest.New(t, l)
+	test.Get("/users/1").Do()
+	test.Get("/users/2").Do()
+	test.Get("/fail").Do()
+
+	jobs := metrics.Registry.NewGauge("jobs_queued", "Number of \"queued\" jobs.", "queue")
+	jobs.Set(3, "emails")
+	metrics.Registry.NewGaugeFunc("goroutines", "Custom gauge.", func() float64 { return 7 })
+	metrics.Registry.Register(MetricsCollectorFunc(func(w io.Writer) error {
+		_, err := fmt.Fprintln(w, "custom_metric 1")
+		return err
+	}))
+
+	res := test.Get("/metrics").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	body := res.Recorder().Body.String()
+
+	expected := []string{
+		"# HELP goroutines Custom gauge.\n# TYPE goroutines gauge\ngoroutines 7\n",
+		"# TYPE jobs_queued gauge\njobs_queued{queue=\"emails\"} 3\n",
+		"# TYPE lion_http_requests_total counter\n" +
+			"lion_http_requests_total{method=\"GET\",status=\"2xx\",host=\"\",route=\"/users/:id\"} 2\n" +
+			"lion_http_requests_total{method=\"GET\",status=\"4xx\",host=\"\",route=\"/fail\"} 1\n",
+		"lion_http_request_duration_seconds_bucket{method=\"GET\",status=\"2xx\",host=\"\",route=\"/users/:id\",le=\"60\"} 2\n",
+		"lion_http_request_duration_seconds_count{method=\"GET\",status=\"2xx\",host=\"\",route=\"/users/:id\"} 2\n",
+		"lion_http_response_size_bytes_bucket{method=\"GET\",status=\"2xx\",host=\"\",route=\"/users/:id\",le=\"1\"} 0\n" +
+			"lion_http_response_size_bytes_bucket{method=\"GET\",status=\"2xx\",host=\"\",route=\"/users/:id\",le=\"10\"} 2\n" +
+			"lion_http_response_size_bytes_bucket{method=\"GET\",status=\"2xx\",host=\"\",route=\"/users/:id\",le=\"+Inf\"} 2\n" +
+			"lion_http_response_size_bytes_sum{method=\"GET\",status=\"2xx\",host=\"\",route=\"/users/:id\"} 8\n",
+		// The scrape itself is in flight
+		"lion_http_requests_in_flight{method=\"GET\",host=\"\",route=\"/metrics\"} 1\n",
+		"custom_metric 1\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Errorf("Expected metrics to contain:\n%s\ngot:\n%s", e, body)
+		}
+	}
+	if strings.Contains(body, "/users/1") {
+		t.Errorf("Raw paths should not be used as labels")
+	}
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	reg := NewMetricsRegistry()
+	c := reg.NewCounter("hits_total", "Hits.", "path")
+	if reg.NewCounter("hits_total", "Hits.", "path") != c {
+		t.Errorf("Metrics should be registered once")
+	}
+	c.Inc("a\"b\\c\nd")
+
+	buf := new(strings.Builder)
+	reg.WriteTo(buf)
+	if !strings.Contains(buf.String(), `hits_total{path="a\"b\\c\nd"} 1`) {
+		t.Errorf("Label values should be escaped: %s", buf.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Registering a metric with another type should panic")
+		}
+	}()
+	reg.NewGauge("hits_total", "Hits.")
+}
@@ -1,7 +1,9 @@
 package middleware
 
 import (
+	"io"
 	"log"
+	"net/http"
 	"os"
 
 	"github.com/celrenheit/lion"
@@ -20,3 +22,11 @@ func Basic() lion.Middlewares {
 func Classic() lion.Middlewares {
 	return lion.Middlewares{Basic(), NewLogger()}
 }
+
+// writeError writes err to w in the same way as lion.Context.Error
+func writeError(w http.ResponseWriter, err lion.HTTPError) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(err.Status())
+	io.WriteString(w, err.Error())
+}
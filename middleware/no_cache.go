@@ -28,7 +28,7 @@ func NewNoCache() lion.Middleware {
 			"ETag",
 			"If-Modified-Since",
 			"If-Match",
-			"If-Note-Match",
+			"If-None-Match",
 			"If-Range",
 			"If-Unmodified-Since",
 		},
@@ -49,5 +49,6 @@ func (n NoCache) ServeNext(next http.Handler) http.Handler {
 			w.Header().Set(k, v)
 		}
 
+		next.ServeHTTP(w, r)
 	})
 }
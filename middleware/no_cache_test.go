@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/celrenheit/htest"
+)
+
+func TestNoCache(t *testing.T) {
+	var ifNoneMatch string
+	handler := NewNoCache().ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = r.Header.Get("If-None-Match")
+		w.Write([]byte("fresh"))
+	}))
+
+	htest.New(t, handler).Get("/").SetHeader("If-None-Match", `"v1"`).Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("fresh").
+		ExpectHeader("Cache-Control", "no-cache, private, must-revalidate, max-age=0").
+		ExpectHeader("Pragma", "no-cache")
+
+	if ifNoneMatch != "" {
+		t.Errorf("The If-None-Match header should be removed but got %s", ifNoneMatch)
+	}
+}
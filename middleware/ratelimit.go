@@ -0,0 +1,309 @@
+package middleware
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celrenheit/lion"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// RateLimitKeyFunc returns the key identifying the client of a request.
+// Requests with an empty key are not limited.
+type RateLimitKeyFunc func(r *http.Request) string
+
+// Limit allows Requests requests per Period.
+// Unused requests are accumulated up to Burst, which defaults to Requests.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// interval is the time needed to get a new token, at least 1ns
+func (l Limit) interval() time.Duration {
+	if l.Requests <= 0 || l.Period < time.Duration(l.Requests) {
+		return 1
+	}
+	return l.Period / time.Duration(l.Requests)
+}
+
+// RateLimitResult is the state of a limit after a request
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the limit is fully restored
+	Reset time.Duration
+	// RetryAfter is the time until the next request will be allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore stores the state of the limits
+type RateLimitStore interface {
+	// Take consumes a request for key at time now
+	Take(key string, limit Limit, now time.Time) (RateLimitResult, error)
+}
+
+// RateLimit is a middleware that limits the number of requests per client using a token bucket.
+// Each client is identified by the key returned by KeyFunc.
+// Use different instances in different groups to apply different limits:
+//
+//	l.Group("/login", middleware.NewRateLimit(5, time.Minute))
+//	l.Group("/search", middleware.NewRateLimit(100, time.Minute))
+//
+// Responses contain RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+// Requests exceeding the limit receive a Retry-After header and are passed to LimitReached.
+type RateLimit struct {
+	Limit   Limit
+	KeyFunc RateLimitKeyFunc
+	Store   RateLimitStore
+
+	// LimitReached handles requests exceeding the limit. It responds with 429 Too Many Requests by default.
+	LimitReached http.Handler
+	// ErrorHandler handles errors returned by Store. Requests are allowed by default.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, next http.Handler, err error)
+}
+
+// NewRateLimit creates a RateLimit allowing requests per period for each client IP, with an in-memory store
+func NewRateLimit(requests int, period time.Duration) *RateLimit {
+	return &RateLimit{
+		Limit:   Limit{Requests: requests, Period: period},
+		KeyFunc: KeyByIP,
+		Store:   NewMemoryRateLimitStore(),
+	}
+}
+
+// ServeNext implements the Middleware interface for RateLimit.
+func (rl *RateLimit) ServeNext(next http.Handler) http.Handler {
+	if rl.Limit.Requests <= 0 || rl.Limit.Period <= 0 {
+		panic("lion: RateLimit requires a positive number of requests and period")
+	}
+	if rl.Limit.Period < time.Duration(rl.Limit.Requests) {
+		panic(fmt.Sprintf("lion: RateLimit cannot allow %d requests per %v, the period must be at least 1ns per request", rl.Limit.Requests, rl.Limit.Period))
+	}
+	if rl.KeyFunc == nil {
+		rl.KeyFunc = KeyByIP
+	}
+	if rl.Store == nil {
+		rl.Store = NewMemoryRateLimitStore()
+	}
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		key := rl.KeyFunc(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		res, err := rl.Store.Take(key, rl.Limit, time.Now())
+		if err != nil {
+			if rl.ErrorHandler != nil {
+				rl.ErrorHandler(w, r, next, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", seconds(res.Reset))
+
+		if !res.Allowed {
+			h.Set("Retry-After", seconds(res.RetryAfter))
+			if rl.LimitReached != nil {
+				rl.LimitReached.ServeHTTP(w, r)
+				return
+			}
+			writeError(w, lion.ErrorTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// seconds rounds d up to the next second
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// KeyByIP identifies clients by their IP address.
+// Use it with RealIP if the server is behind a proxy.
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// KeyByHeader identifies clients by the value of a header, an API key for example
+func KeyByHeader(name string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// KeyByJWTSubject identifies clients by the subject of the claims stored by the JWT middleware under contextKey
+func KeyByJWTSubject(contextKey interface{}) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		switch claims := r.Context().Value(contextKey).(type) {
+		case jwt.MapClaims:
+			sub, _ := claims["sub"].(string)
+			return sub
+		case *jwt.StandardClaims:
+			return claims.Subject
+		}
+		return ""
+	}
+}
+
+// KeyByRoute identifies the route matched by lion, using its name or, if it has none, its host and pattern.
+// Combine it with another key to limit each client per route.
+func KeyByRoute(r *http.Request) string {
+	c := lion.C(r)
+	if c == nil || c.Route() == nil {
+		return ""
+	}
+	rt := c.Route()
+	if rt.Name() != "" {
+		return rt.Name()
+	}
+	return rt.Host() + rt.Pattern()
+}
+
+// CombineKeys joins the keys returned by fns. The key is empty if any of them is empty.
+//
+//	middleware.CombineKeys(middleware.KeyByRoute, middleware.KeyByIP)
+func CombineKeys(fns ...RateLimitKeyFunc) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		keys := make([]string, 0, len(fns))
+		for _, fn := range fns {
+			key := fn(r)
+			if key == "" {
+				return ""
+			}
+			keys = append(keys, key)
+		}
+		return strings.Join(keys, "|")
+	}
+}
+
+// FirstKey returns the first non empty key returned by fns.
+//
+//	middleware.FirstKey(middleware.KeyByJWTSubject(middleware.DefaultJWTContextKey), middleware.KeyByIP)
+func FirstKey(fns ...RateLimitKeyFunc) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		for _, fn := range fns {
+			if key := fn(r); key != "" {
+				return key
+			}
+		}
+		return ""
+	}
+}
+
+const rateLimitShards = 32
+
+// MemoryRateLimitStore is an in-memory RateLimitStore.
+// Keys are spread over several shards to reduce lock contention.
+type MemoryRateLimitStore struct {
+	shards [rateLimitShards]rateLimitShard
+}
+
+type rateLimitShard struct {
+	sync.Mutex
+	buckets map[string]*tokenBucket
+	takes   int
+}
+
+// tokenBucket is stored as the time at which it will be full again (GCRA)
+type tokenBucket struct {
+	full time.Time
+}
+
+var _ RateLimitStore = (*MemoryRateLimitStore)(nil)
+
+// NewMemoryRateLimitStore creates a new MemoryRateLimitStore
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{}
+	for i := range s.shards {
+		s.shards[i].buckets = make(map[string]*tokenBucket)
+	}
+	return s
+}
+
+// Take implements RateLimitStore
+func (s *MemoryRateLimitStore) Take(key string, limit Limit, now time.Time) (RateLimitResult, error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := &s.shards[h.Sum32()%rateLimitShards]
+
+	shard.Lock()
+	defer shard.Unlock()
+
+	shard.takes++
+	if shard.takes%1024 == 0 {
+		shard.sweep(now)
+	}
+
+	b, ok := shard.buckets[key]
+	if !ok {
+		b = &tokenBucket{full: now}
+		shard.buckets[key] = b
+	}
+	return b.take(limit, now), nil
+}
+
+// sweep removes full buckets since they are equivalent to missing ones
+func (s *rateLimitShard) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if !b.full.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func (b *tokenBucket) take(limit Limit, now time.Time) RateLimitResult {
+	interval := limit.interval()
+	capacity := time.Duration(limit.burst()) * interval
+
+	full := b.full
+	if full.Before(now) {
+		full = now
+	}
+	full = full.Add(interval)
+
+	res := RateLimitResult{Limit: limit.burst()}
+	if used := full.Sub(now); used > capacity {
+		// Not enough tokens
+		res.Reset = b.full.Sub(now)
+		res.RetryAfter = used - capacity
+		return res
+	}
+
+	b.full = full
+	res.Allowed = true
+	res.Reset = full.Sub(now)
+	res.Remaining = int((capacity - res.Reset) / interval)
+	return res
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+)
+
+func TestRateLimit(t *testing.T) {
+	l := lion.New()
+	login := l.Group("/login", NewRateLimit(2, time.Minute))
+	login.Post("/", fakeHandler())
+
+	search := l.Group("/search", &RateLimit{
+		Limit:   Limit{Requests: 1, Period: time.Hour},
+		KeyFunc: KeyByHeader("X-API-Key"),
+		LimitReached: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}),
+	})
+	search.Get("/", fakeHandler())
+
+	test := htest.New(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = "10.0.0.1:1234"
+		l.ServeHTTP(w, r)
+	}))
+	test.Post("/login").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("RateLimit-Limit", "2").
+		ExpectHeader("RateLimit-Remaining", "1").
+		ExpectHeader("RateLimit-Reset", "30")
+	test.Post("/login").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("RateLimit-Remaining", "0").
+		ExpectHeader("RateLimit-Reset", "60")
+	test.Post("/login").Do().
+		ExpectStatus(http.StatusTooManyRequests).
+		ExpectHeader("RateLimit-Remaining", "0").
+		ExpectHeader("Retry-After", "30").
+		ExpectBody("Too Many Requests")
+
+	// Requests without key are not limited
+	test.Get("/search").Do().ExpectStatus(http.StatusOK)
+	test.Get("/search").Do().ExpectStatus(http.StatusOK)
+
+	test.Get("/search").AddHeader("X-API-Key", "abc").Do().ExpectStatus(http.StatusOK)
+	test.Get("/search").AddHeader("X-API-Key", "abc").Do().
+		ExpectStatus(http.StatusServiceUnavailable).
+		ExpectHeader("Retry-After", "3600")
+	test.Get("/search").AddHeader("X-API-Key", "def").Do().ExpectStatus(http.StatusOK)
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	limit := Limit{Requests: 10, Period: 10 * time.Second, Burst: 2}
+	now := time.Now()
+
+	take := func(at time.Duration, allowed bool, remaining int) {
+		res, err := s.Take("key", limit, now.Add(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed != allowed || res.Remaining != remaining {
+			t.Errorf("At %v: got allowed=%v remaining=%d want allowed=%v remaining=%d", at, res.Allowed, res.Remaining, allowed, remaining)
+		}
+	}
+
+	take(0, true, 1)
+	take(0, true, 0)
+	take(500*time.Millisecond, false, 0)
+	take(time.Second, true, 0)
+	take(10*time.Second, true, 1)
+
+	if res, _ := s.Take("other", limit, now); !res.Allowed {
+		t.Errorf("Keys should be limited independently")
+	}
+}
+
+func TestRateLimitInvalidLimit(t *testing.T) {
+	for _, limit := range []Limit{{Requests: 10}, {Requests: 0, Period: time.Second}, {Requests: 10, Period: 5}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%+v: should panic", limit)
+				}
+			}()
+			(&RateLimit{Limit: limit}).ServeNext(fakeHandler())
+		}()
+	}
+
+	// Stores used directly do not divide by zero
+	if _, err := NewMemoryRateLimitStore().Take("key", Limit{Requests: 10, Period: 5}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-API-Key", "secret")
+
+	if key := KeyByIP(r); key != "10.0.0.1" {
+		t.Errorf("Incorrect IP key: %s", key)
+	}
+	if key := CombineKeys(KeyByHeader("X-API-Key"), KeyByIP)(r); key != "secret|10.0.0.1" {
+		t.Errorf("Incorrect combined key: %s", key)
+	}
+	if key := CombineKeys(KeyByRoute, KeyByIP)(r); key != "" {
+		t.Errorf("Combined key should be empty: %s", key)
+	}
+	if key := FirstKey(KeyByJWTSubject(DefaultJWTContextKey), KeyByIP)(r); key != "10.0.0.1" {
+		t.Errorf("Incorrect first key: %s", key)
+	}
+}
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"strings"
 
@@ -9,33 +11,82 @@ import (
 
 var xForwardedFor = http.CanonicalHeaderKey("X-Forwarded-For")
 var xRealIP = http.CanonicalHeaderKey("X-Real-IP")
+var xForwardedProto = http.CanonicalHeaderKey("X-Forwarded-Proto")
+var xForwardedHost = http.CanonicalHeaderKey("X-Forwarded-Host")
 
-// RealIP is a middleware that sets a http.Request's RemoteAddr to the results
-// of parsing either the X-Forwarded-For header or the X-Real-IP header (in that
-// order).
+type ctxRealIPKeyType int
+
+const ctxRealIPKey ctxRealIPKeyType = 0
+
+// DefaultTrustedProxies are the loopback and private networks, trusted by RealIP when no proxies are given
+var DefaultTrustedProxies = []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+// RealIPInfo describes the client of a request as reported by trusted proxies
+type RealIPInfo struct {
+	// IP is the IP of the client
+	IP string
+	// Scheme is the scheme used by the client, e.g. https, if reported by the proxies
+	Scheme string
+	// Host is the host requested by the client, if reported by the proxies
+	Host string
+	// RemoteAddr is the address of the peer of the connection, i.e. the closest proxy
+	RemoteAddr string
+}
+
+// RealIP is a middleware that sets a http.Request's RemoteAddr to the IP of the client reported by trusted proxies,
+// keeping the port of the connection.
+//
+// Forwarding headers are only read from requests whose peer is one of TrustedProxies, or of DefaultTrustedProxies if it is empty.
+// The Forwarded header (RFC 7239), or else the X-Forwarded-For header, is walked from right to left past trusted proxies,
+// the first untrusted address being the client. The X-Real-IP header is used if none of them is present.
+// The scheme and host requested by the client, from the Forwarded header or the X-Forwarded-Proto and X-Forwarded-Host headers,
+// are available with GetRealIPInfo along with the client IP.
 //
 // This middleware should be inserted fairly early in the middleware stack to
 // ensure that subsequent layers (e.g., request loggers) which examine the
 // RemoteAddr will see the intended value.
-//
-// You should only use this middleware if you can trust the headers passed to
-// you (in particular, the two headers this middleware uses), for example
-// because you have placed a reverse proxy like HAProxy or nginx in front of
-// Goji. If your reverse proxies are configured to pass along arbitrary header
-// values from the client, or if you use this middleware without a reverse
-// proxy, malicious clients will be able to make you very sad (or, depending on
-// how you're using RemoteAddr, vulnerable to an attack of some sort).
-// Taken from https://github.com/zenazn/goji/blob/master/web/middleware/realip.go
-type RealIP struct{}
+type RealIP struct {
+	// TrustedProxies lists the IPs or CIDRs of the proxies whose forwarding headers are trusted
+	TrustedProxies []string
+}
 
-func NewRealIP() lion.Middleware {
-	return RealIP{}
+// NewRealIP creates a RealIP middleware trusting the proxies in trustedProxies, or the DefaultTrustedProxies if none are given
+func NewRealIP(trustedProxies ...string) lion.Middleware {
+	return RealIP{TrustedProxies: trustedProxies}
 }
 
-func (RealIP) ServeNext(next http.Handler) http.Handler {
+// ServeNext implements the Middleware interface for RealIP.
+func (rip RealIP) ServeNext(next http.Handler) http.Handler {
+	proxies := rip.TrustedProxies
+	if len(proxies) == 0 {
+		proxies = DefaultTrustedProxies
+	}
+	trusted, err := parseCIDRs(proxies)
+	if err != nil {
+		panic(err)
+	}
+
 	hfn := func(w http.ResponseWriter, r *http.Request) {
-		if rip := realIP(r); rip != "" {
-			r.RemoteAddr = rip
+		if !ipInCIDRs(r.RemoteAddr, trusted) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		info := resolveRealIP(r, trusted)
+		if info.IP == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		info.RemoteAddr = r.RemoteAddr
+
+		r = r.WithContext(context.WithValue(r.Context(), ctxRealIPKey, info))
+		if _, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			r.RemoteAddr = net.JoinHostPort(info.IP, port)
+		} else {
+			r.RemoteAddr = info.IP
+		}
+		if c := lion.C(r); c != nil {
+			c.WithLogAttrs("remote_ip", info.IP)
 		}
 		next.ServeHTTP(w, r)
 	}
@@ -43,18 +94,123 @@ func (RealIP) ServeNext(next http.Handler) http.Handler {
 	return http.HandlerFunc(hfn)
 }
 
-func realIP(r *http.Request) string {
-	var ip string
+// GetRealIPInfo returns the client information resolved by the RealIP middleware
+func GetRealIPInfo(ctx context.Context) (RealIPInfo, bool) {
+	info, ok := ctx.Value(ctxRealIPKey).(RealIPInfo)
+	return info, ok
+}
 
-	if xff := r.Header.Get(xForwardedFor); xff != "" {
-		i := strings.Index(xff, ", ")
-		if i == -1 {
-			i = len(xff)
+// resolveRealIP resolves the client of r, whose peer is one of the trusted proxies
+func resolveRealIP(r *http.Request, trusted []*net.IPNet) RealIPInfo {
+	if forwarded := r.Header[http.CanonicalHeaderKey("Forwarded")]; len(forwarded) > 0 {
+		return resolveForwarded(parseForwarded(strings.Join(forwarded, ",")), trusted)
+	}
+
+	var info RealIPInfo
+	if xff := r.Header[xForwardedFor]; len(xff) > 0 {
+		var hops []string
+		for _, h := range strings.Split(strings.Join(xff, ","), ",") {
+			hops = append(hops, strings.TrimSpace(h))
 		}
-		ip = xff[:i]
-	} else if xrip := r.Header.Get(xRealIP); xrip != "" {
-		ip = xrip
+		info.IP = walkForwardedFor(hops, trusted)
+	} else if xrip := strings.TrimSpace(r.Header.Get(xRealIP)); net.ParseIP(xrip) != nil {
+		info.IP = xrip
 	}
+	info.Scheme = strings.ToLower(firstHeaderValue(r.Header.Get(xForwardedProto)))
+	info.Host = firstHeaderValue(r.Header.Get(xForwardedHost))
+	return info
+}
 
-	return ip
+// walkForwardedFor returns the first untrusted address of hops, from right to left, or the leftmost one if all are trusted.
+// It stops at the first invalid address.
+func walkForwardedFor(hops []string, trusted []*net.IPNet) string {
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseForwardedNode(hops[i])
+		if ip == "" {
+			break
+		}
+		client = ip
+		if !ipInCIDRs(ip, trusted) {
+			break
+		}
+	}
+	return client
+}
+
+func resolveForwarded(elements []map[string]string, trusted []*net.IPNet) RealIPInfo {
+	var info RealIPInfo
+	for i := len(elements) - 1; i >= 0; i-- {
+		ip := parseForwardedNode(elements[i]["for"])
+		if ip == "" {
+			break
+		}
+		info = RealIPInfo{IP: ip, Scheme: strings.ToLower(elements[i]["proto"]), Host: elements[i]["host"]}
+		if !ipInCIDRs(ip, trusted) {
+			break
+		}
+	}
+	return info
+}
+
+// parseForwarded parses the elements of a Forwarded header, e.g. for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"
+func parseForwarded(header string) []map[string]string {
+	var elements []map[string]string
+	for _, element := range splitQuoted(header, ',') {
+		pairs := make(map[string]string)
+		for _, pair := range splitQuoted(element, ';') {
+			i := strings.IndexByte(pair, '=')
+			if i < 0 {
+				continue
+			}
+			key := strings.ToLower(strings.TrimSpace(pair[:i]))
+			value := strings.TrimSpace(pair[i+1:])
+			if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = strings.Replace(value[1:len(value)-1], `\"`, `"`, -1)
+			}
+			pairs[key] = value
+		}
+		elements = append(elements, pairs)
+	}
+	return elements
+}
+
+// splitQuoted splits s around sep, ignoring separators inside quoted strings
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseForwardedNode returns the IP of a node, e.g. 192.0.2.60, 192.0.2.60:80 or [2001:db8::1]:4711.
+// It returns an empty string for unknown or obfuscated nodes.
+func parseForwardedNode(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	ip := net.ParseIP(node)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func firstHeaderValue(h string) string {
+	if i := strings.IndexByte(h, ','); i >= 0 {
+		h = h[:i]
+	}
+	return strings.TrimSpace(h)
 }
@@ -7,23 +7,123 @@ import (
 	"github.com/celrenheit/htest"
 )
 
+// remoteAddrHandler sets the RemoteAddr of requests from the X-Test-Remote header, htest leaving it empty
+func remoteAddrHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = r.Header.Get("X-Test-Remote")
+		next.ServeHTTP(w, r)
+	})
+}
+
 func TestRealIP(t *testing.T) {
-	rip := RealIP{}
+	rip := NewRealIP()
 
 	var remoteaddr string
 	handler := rip.ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		remoteaddr = r.RemoteAddr
 	}))
 
-	test := htest.New(t, handler)
-	test.Get("/foo").SetHeader("X-Forwarded-For", "1.2.3.4").Do()
-	if remoteaddr != "1.2.3.4" {
-		t.Errorf("should equal 1.2.3.4")
+	test := htest.New(t, remoteAddrHandler(handler))
+	test.Get("/foo").SetHeader("X-Test-Remote", "127.0.0.1:4242").SetHeader("X-Forwarded-For", "1.2.3.4").Do()
+	if remoteaddr != "1.2.3.4:4242" {
+		t.Errorf("should equal 1.2.3.4:4242 but got %s", remoteaddr)
+	}
+
+	test.Get("/foo").SetHeader("X-Test-Remote", "127.0.0.1:4242").SetHeader("X-Real-IP", "5.6.7.8").Do()
+	if remoteaddr != "5.6.7.8:4242" {
+		t.Errorf("should equal 5.6.7.8:4242 but got %s", remoteaddr)
 	}
 
-	test.Get("/foo").SetHeader("X-Real-IP", "5.6.7.8").Do()
-	if remoteaddr != "5.6.7.8" {
-		t.Errorf("should equal 5.6.7.8")
+	test.Get("/foo").SetHeader("X-Test-Remote", "8.8.8.8:4242").SetHeader("X-Forwarded-For", "1.2.3.4").Do()
+	if remoteaddr != "8.8.8.8:4242" {
+		t.Errorf("headers of untrusted peers should be ignored but got %s", remoteaddr)
+	}
+	// The zero value trusts the DefaultTrustedProxies
+	handler = RealIP{}.ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteaddr = r.RemoteAddr
+	}))
+	test = htest.New(t, remoteAddrHandler(handler))
+	test.Get("/foo").SetHeader("X-Test-Remote", "10.0.0.1:4242").SetHeader("X-Forwarded-For", "1.2.3.4").Do()
+	if remoteaddr != "1.2.3.4:4242" {
+		t.Errorf("should equal 1.2.3.4:4242 but got %s", remoteaddr)
 	}
+}
+
+func TestRealIPForwardedFor(t *testing.T) {
+	rip := NewRealIP("10.0.0.0/8", "192.0.2.1")
+
+	var info RealIPInfo
+	var remoteaddr string
+	handler := rip.ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, _ = GetRealIPInfo(r.Context())
+		remoteaddr = r.RemoteAddr
+	}))
+	test := htest.New(t, remoteAddrHandler(handler))
+
+	tests := []struct {
+		xff, expected string
+	}{
+		// A spoofed leftmost entry is skipped
+		{"6.6.6.6, 1.2.3.4, 192.0.2.1, 10.0.0.2", "1.2.3.4"},
+		{"1.2.3.4,10.0.0.2", "1.2.3.4"},
+		// All hops are trusted
+		{"10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		// Invalid entries stop the walk
+		{"1.2.3.4, garbage, 10.0.0.2", "10.0.0.2"},
+		{"[2001:db8::1]:4711", "2001:db8::1"},
+	}
+	for _, tc := range tests {
+		test.Get("/").SetHeader("X-Test-Remote", "10.0.0.1:80").SetHeader("X-Forwarded-For", tc.xff).
+			SetHeader("X-Forwarded-Proto", "HTTPS, http").SetHeader("X-Forwarded-Host", "example.com").Do()
+		if info.IP != tc.expected || remoteaddr != "["+tc.expected+"]:80" && remoteaddr != tc.expected+":80" {
+			t.Errorf("%q: expected %s but got %+v (%s)", tc.xff, tc.expected, info, remoteaddr)
+		}
+		if info.Scheme != "https" || info.Host != "example.com" || info.RemoteAddr != "10.0.0.1:80" {
+			t.Errorf("Incorrect info: %+v", info)
+		}
+	}
+}
+
+func TestRealIPForwarded(t *testing.T) {
+	rip := NewRealIP("10.0.0.0/8")
+
+	var info RealIPInfo
+	handler := rip.ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, _ = GetRealIPInfo(r.Context())
+	}))
+	test := htest.New(t, remoteAddrHandler(handler))
+
+	tests := []struct {
+		forwarded string
+		expected  RealIPInfo
+	}{
+		{`for=192.0.2.60;proto=https;host=example.com`, RealIPInfo{IP: "192.0.2.60", Scheme: "https", Host: "example.com"}},
+		{`For="[2001:db8:cafe::17]:4711";Proto=http, for=10.0.0.2;proto=https`, RealIPInfo{IP: "2001:db8:cafe::17", Scheme: "http"}},
+		{`for=6.6.6.6, for=192.0.2.43;host="a.example.com", for=10.0.0.2`, RealIPInfo{IP: "192.0.2.43", Host: "a.example.com"}},
+		{`for=unknown, for=10.0.0.2;proto=https`, RealIPInfo{IP: "10.0.0.2", Scheme: "https"}},
+		{`for=_hidden;proto=https`, RealIPInfo{}},
+	}
+	for _, tc := range tests {
+		info = RealIPInfo{}
+		test.Get("/").SetHeader("X-Test-Remote", "10.0.0.1:80").SetHeader("Forwarded", tc.forwarded).
+			SetHeader("X-Forwarded-For", "7.7.7.7").Do()
+		if tc.expected.IP != "" {
+			tc.expected.RemoteAddr = "10.0.0.1:80"
+		}
+		if info != tc.expected {
+			t.Errorf("%q: expected %+v but got %+v", tc.forwarded, tc.expected, info)
+		}
+	}
+}
+
+func TestRealIPSecureHeaders(t *testing.T) {
+	sh := NewSecureHeaders()
+	sh.SSLRedirect = true
+	handler := NewRealIP().ServeNext(sh.ServeNext(fakeHandler()))
+	test := htest.New(t, remoteAddrHandler(handler))
 
+	test.Get("/").SetHeader("X-Test-Remote", "127.0.0.1:80").SetHeader("Forwarded", "for=1.2.3.4;proto=https").
+		Do().ExpectStatus(http.StatusOK)
+	test.Get("/").SetHeader("X-Test-Remote", "127.0.0.1:80").SetHeader("Forwarded", "for=1.2.3.4;proto=http").
+		Do().ExpectStatus(http.StatusMovedPermanently)
 }
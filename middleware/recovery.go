@@ -1,21 +1,88 @@
 package middleware
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/celrenheit/lion"
 )
 
+var (
+	// DefaultPanicQueueSize is the default number of panic reports waiting to be sent
+	DefaultPanicQueueSize = 100
+	// DefaultPanicReportTimeout is the default time given to the reporters to send a report
+	DefaultPanicReportTimeout = 10 * time.Second
+)
+
+// PanicHandler writes the response of a request whose handler panicked with err.
+// stack is the stack trace of the goroutine at the time of the panic.
+type PanicHandler func(w http.ResponseWriter, r *http.Request, err interface{}, stack []byte)
+
+// PanicReport describes a recovered panic
+type PanicReport struct {
+	Time      time.Time `json:"time"`
+	Error     string    `json:"error"`
+	Stack     string    `json:"stack"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Route     string    `json:"route,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	// Location is the file and line of the panic
+	Location string `json:"location,omitempty"`
+	// Occurrences is the number of panics described by the report: 1, or the number of duplicates suppressed
+	// during the DedupWindow, the report of the last one being sent when the window expires
+	Occurrences int `json:"occurrences"`
+}
+
 // Recovery is a middleware that recovers from panics
 // Taken from https://github.com/codegangsta/negroni/blob/master/recovery.go
+//
+// The response is written by PanicHandler, or by default as a 500 in plain text, JSON or problem+json depending on the Accept header.
+// Nothing is written if the response has already started. Panics with http.ErrAbortHandler are propagated to the server.
+// Each panic is logged and queued to be sent to the Reporters by a background goroutine, so that slow reporters
+// do not hold requests. Reports are dropped if QueueSize reports are already waiting. Use Flush to wait for them, e.g. on shutdown.
 type Recovery struct {
 	Logger     *log.Logger
 	PrintStack bool
 	StackAll   bool
 	StackSize  int
+
+	// PanicHandler writes the response of the requests that panicked
+	PanicHandler PanicHandler
+	// Reporters are notified of the panics, after the response has been written
+	Reporters []PanicReporter
+	// QueueSize is the number of reports that can wait to be sent, DefaultPanicQueueSize if zero
+	QueueSize int
+	// ReportTimeout bounds the time given to the Reporters to send a report. There is no limit if zero.
+	ReportTimeout time.Duration
+	// DedupWindow is the interval during which identical panics, i.e. with the same error at the same location, are reported once.
+	// The duplicates are counted and reported together when the window expires. Every panic is reported if zero.
+	DedupWindow time.Duration
+
+	mu    sync.Mutex
+	seen  map[string]*panicOccurrence
+	start sync.Once
+	queue chan panicJob
+}
+
+// panicOccurrence is a panic reported during the DedupWindow
+type panicOccurrence struct {
+	suppressed int
+	// last is the report of the last suppressed duplicate
+	last PanicReport
+}
+
+// panicJob is a report to send or, if flushed is set, a request to be notified once the previous reports are sent
+type panicJob struct {
+	report  PanicReport
+	flushed chan struct{}
 }
 
 // NewRecovery creates a new Recovery instance
@@ -25,31 +92,252 @@ func NewRecovery() lion.Middleware {
 		PrintStack: false,
 		StackAll:   false,
 		StackSize:  1024 * 8,
+
+		ReportTimeout: DefaultPanicReportTimeout,
 	}
 }
 
 // ServeNext is the method responsible for recovering from a panic
 func (rec *Recovery) ServeNext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := wrapResponseWriter(w)
 		defer func() {
 			if err := recover(); err != nil {
-				if w.Header().Get("Content-type") == "" {
-					w.Header().Set("Content-type", "text/plain; charset=utf-8")
+				if err == http.ErrAbortHandler {
+					panic(err)
 				}
-				w.WriteHeader(http.StatusInternalServerError)
+				location := panicLocation()
 				stack := make([]byte, rec.StackSize)
 				stack = stack[:runtime.Stack(stack, rec.StackAll)]
 
 				f := "PANIC: %s\n%s"
 				rec.Logger.Printf(f, err, stack)
 
-				if rec.PrintStack {
-					fmt.Fprintf(w, f, err, stack)
+				if res.Status() == 0 {
+					handler := rec.PanicHandler
+					if handler == nil {
+						handler = rec.writePanic
+					}
+					handler(res, r, err, stack)
 				}
 
+				rec.report(r, err, stack, location)
 			}
 		}()
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(res, r)
+	})
+}
+
+// writePanic is the default PanicHandler
+func (rec *Recovery) writePanic(w http.ResponseWriter, r *http.Request, err interface{}, stack []byte) {
+	status := http.StatusInternalServerError
+	h := w.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	switch contentType := negotiateErrorType(r.Header.Get("Accept")); contentType {
+	case "application/json", "application/problem+json":
+		body := map[string]interface{}{}
+		if contentType == "application/json" {
+			body["error"] = http.StatusText(status)
+		} else {
+			body["type"] = "about:blank"
+			body["title"] = http.StatusText(status)
+			body["status"] = status
+		}
+		if id := GetRequestID(r.Context()); id != "" {
+			body["request_id"] = id
+		}
+		if rec.PrintStack {
+			body["detail"] = fmt.Sprint(err)
+			body["stack"] = string(stack)
+		}
+		h.Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	default:
+		if h.Get("Content-type") == "" {
+			h.Set("Content-type", "text/plain; charset=utf-8")
+		}
+		w.WriteHeader(status)
+		if rec.PrintStack {
+			fmt.Fprintf(w, "PANIC: %s\n%s", err, stack)
+		}
+	}
+}
+
+// report queues the panic for the reporters, unless an identical panic has been reported during the DedupWindow
+func (rec *Recovery) report(r *http.Request, err interface{}, stack []byte, location string) {
+	if len(rec.Reporters) == 0 {
+		return
+	}
+
+	report := PanicReport{
+		Time:        time.Now(),
+		Error:       fmt.Sprint(err),
+		Stack:       string(stack),
+		Method:      r.Method,
+		URL:         r.URL.String(),
+		RequestID:   GetRequestID(r.Context()),
+		Location:    location,
+		Occurrences: 1,
+	}
+	if c := lion.C(r); c != nil && c.Route() != nil {
+		report.Route = c.Route().Pattern()
+	}
+
+	if rec.DedupWindow > 0 {
+		key := location + "\n" + report.Error
+		rec.mu.Lock()
+		if o, ok := rec.seen[key]; ok {
+			o.suppressed++
+			o.last = report
+			rec.mu.Unlock()
+			return
+		}
+		if rec.seen == nil {
+			rec.seen = make(map[string]*panicOccurrence)
+		}
+		rec.seen[key] = &panicOccurrence{}
+		rec.mu.Unlock()
+		time.AfterFunc(rec.DedupWindow, func() { rec.expire(key) })
+	}
+	rec.enqueue(panicJob{report: report})
+}
+
+// expire forgets the panic identified by key at the end of its DedupWindow and reports its suppressed duplicates, if any
+func (rec *Recovery) expire(key string) {
+	rec.mu.Lock()
+	o := rec.seen[key]
+	delete(rec.seen, key)
+	rec.mu.Unlock()
+
+	if o != nil && o.suppressed > 0 {
+		o.last.Occurrences = o.suppressed
+		rec.enqueue(panicJob{report: o.last})
+	}
+}
+
+// enqueue queues job for the reporters, starting the goroutine sending the reports on first use.
+// The report is dropped if the queue is full.
+func (rec *Recovery) enqueue(job panicJob) {
+	rec.startQueue()
+	select {
+	case rec.queue <- job:
+	default:
+		rec.Logger.Printf("recovery: cannot report panic: %d reports are already waiting", cap(rec.queue))
+	}
+}
+
+func (rec *Recovery) startQueue() {
+	rec.start.Do(func() {
+		size := rec.QueueSize
+		if size <= 0 {
+			size = DefaultPanicQueueSize
+		}
+		rec.queue = make(chan panicJob, size)
+		go rec.send(rec.queue)
 	})
 }
+
+// send sends the reports of queue to the reporters
+func (rec *Recovery) send(queue chan panicJob) {
+	for job := range queue {
+		if job.flushed != nil {
+			close(job.flushed)
+			continue
+		}
+
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if rec.ReportTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, rec.ReportTimeout)
+		}
+		for _, reporter := range rec.Reporters {
+			if err := reporter.ReportPanic(ctx, job.report); err != nil {
+				rec.Logger.Printf("recovery: cannot report panic: %v", err)
+			}
+		}
+		cancel()
+	}
+}
+
+// Flush waits until the panics queued so far have been reported or ctx is done.
+// The duplicates suppressed during a DedupWindow are only queued when the window expires.
+func (rec *Recovery) Flush(ctx context.Context) error {
+	rec.startQueue()
+	flushed := make(chan struct{})
+	select {
+	case rec.queue <- panicJob{flushed: flushed}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// panicLocation returns the file and line where the panic being recovered occurred.
+// It must be called by the deferred function calling recover.
+func panicLocation() string {
+	pc := make([]uintptr, 32)
+	n := runtime.Callers(3, pc)
+	frames := runtime.CallersFrames(pc[:n])
+	panicking := false
+	for {
+		frame, more := frames.Next()
+		if panicking && !strings.HasPrefix(frame.Function, "runtime.") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if frame.Function == "runtime.gopanic" {
+			panicking = true
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// negotiateErrorType returns the content type of error responses preferred by an Accept header:
+// application/problem+json, application/json or text/plain.
+// Explicit media types are preferred over wildcards, text/plain being the default.
+func negotiateErrorType(accept string) string {
+	types := []string{"text/plain", "application/json", "application/problem+json"}
+	if accept == "" {
+		return types[0]
+	}
+
+	type quality struct {
+		q           float64
+		specificity int
+	}
+	qs := map[string]quality{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, q := parseQuality(part)
+		for _, t := range types {
+			specificity := -1
+			switch {
+			case mediaType == t:
+				specificity = 2
+			case mediaType == t[:strings.IndexByte(t, '/')]+"/*":
+				specificity = 1
+			case mediaType == "*/*":
+				specificity = 0
+			}
+			if cur, ok := qs[t]; specificity >= 0 && (!ok || specificity > cur.specificity) {
+				qs[t] = quality{q, specificity}
+			}
+		}
+	}
+
+	best, bestQ := types[0], quality{0, -1}
+	for _, t := range types {
+		q, ok := qs[t]
+		if ok && q.q > 0 && (q.q > bestQ.q || q.q == bestQ.q && q.specificity > bestQ.specificity) {
+			best, bestQ = t, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// PanicReporter is notified of the panics recovered by Recovery.
+// Reports are sent one at a time by a goroutine of Recovery, after the request has been served.
+type PanicReporter interface {
+	// ReportPanic sends report. ctx is done when the ReportTimeout of Recovery expires.
+	ReportPanic(ctx context.Context, report PanicReport) error
+}
+
+// PanicReporterFunc is an adapter to use a function as a PanicReporter
+type PanicReporterFunc func(ctx context.Context, report PanicReport) error
+
+// ReportPanic calls fn(ctx, report)
+func (fn PanicReporterFunc) ReportPanic(ctx context.Context, report PanicReport) error {
+	return fn(ctx, report)
+}
+
+// CrashLogReporter writes panic reports as JSON objects, one per line
+type CrashLogReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewCrashLogReporter creates a CrashLogReporter writing to w
+func NewCrashLogReporter(w io.Writer) *CrashLogReporter {
+	return &CrashLogReporter{w: w}
+}
+
+// NewCrashLogFileReporter creates a CrashLogReporter appending to the file at path.
+// The file should be closed with Close.
+func NewCrashLogFileReporter(path string) (*CrashLogReporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewCrashLogReporter(f), nil
+}
+
+// ReportPanic implements PanicReporter
+func (c *CrashLogReporter) ReportPanic(ctx context.Context, report PanicReport) error {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.w.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer
+func (c *CrashLogReporter) Close() error {
+	if closer, ok := c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// WebhookReporter posts panic reports as JSON to a URL, e.g. of an incident management or chat service
+type WebhookReporter struct {
+	// URL receives the reports
+	URL string
+	// Headers are added to the requests, e.g. for authentication
+	Headers map[string]string
+	// Client sends the reports. It defaults to an http.Client with a 5 seconds timeout.
+	Client *http.Client
+}
+
+// NewWebhookReporter creates a WebhookReporter posting to url
+func NewWebhookReporter(url string) *WebhookReporter {
+	return &WebhookReporter{URL: url}
+}
+
+// ReportPanic implements PanicReporter
+func (wh *WebhookReporter) ReportPanic(ctx context.Context, report PanicReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range wh.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := wh.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook: %s responded with %s", wh.URL, res.Status)
+	}
+	return nil
+}
@@ -2,12 +2,21 @@ package middleware
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
 )
 
 func TestRecovery(t *testing.T) {
@@ -49,3 +58,206 @@ func TestRecovery(t *testing.T) {
 		t.Errorf("Should contain PANIC in log")
 	}
 }
+
+func TestRecoveryNegotiation(t *testing.T) {
+	recovery := NewRecovery().(*Recovery)
+	recovery.Logger = log.New(ioutil.Discard, "", 0)
+	handler := NewRequestID().ServeNext(recovery.ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("OHOH")
+	})))
+	test := htest.New(t, handler)
+
+	tests := []struct {
+		accept, contentType string
+	}{
+		{"", "text/plain; charset=utf-8"},
+		{"*/*", "text/plain; charset=utf-8"},
+		{"text/html, application/json", "application/json"},
+		{"application/problem+json, application/json;q=0.9", "application/problem+json"},
+		{"application/*, */*;q=0.1", "application/json"},
+		{"application/json;q=0, */*", "text/plain; charset=utf-8"},
+	}
+	for _, tc := range tests {
+		rec := test.Get("/").SetHeader("Accept", tc.accept).Do().
+			ExpectStatus(http.StatusInternalServerError).
+			ExpectHeader("Content-Type", tc.contentType).
+			Recorder()
+		if !strings.HasPrefix(tc.contentType, "application/") {
+			continue
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body["request_id"] != rec.Header().Get(headerXRequestID) || body["detail"] != nil {
+			t.Errorf("%q: incorrect body %v", tc.accept, body)
+		}
+		if tc.contentType == "application/problem+json" && (body["title"] != "Internal Server Error" || body["status"] != 500.0) {
+			t.Errorf("Incorrect problem: %v", body)
+		}
+	}
+}
+
+func TestRecoveryStartedResponse(t *testing.T) {
+	called := false
+	recovery := NewRecovery().(*Recovery)
+	recovery.Logger = log.New(ioutil.Discard, "", 0)
+	recovery.PanicHandler = func(w http.ResponseWriter, r *http.Request, err interface{}, stack []byte) {
+		called = true
+	}
+	handler := recovery.ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("partial"))
+		panic("OHOH")
+	}))
+
+	htest.New(t, handler).Get("/").Do().ExpectStatus(http.StatusAccepted).ExpectBody("partial")
+	if called {
+		t.Errorf("The PanicHandler should not be called once the response has started")
+	}
+}
+
+func TestRecoveryPanicHandler(t *testing.T) {
+	recovery := NewRecovery().(*Recovery)
+	recovery.Logger = log.New(ioutil.Discard, "", 0)
+	recovery.PanicHandler = func(w http.ResponseWriter, r *http.Request, err interface{}, stack []byte) {
+		if len(stack) == 0 {
+			t.Errorf("Should receive the stack")
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "custom: %v", err)
+	}
+	handler := recovery.ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("OHOH")
+	}))
+
+	htest.New(t, handler).Get("/").Do().ExpectStatus(http.StatusServiceUnavailable).ExpectBody("custom: OHOH")
+}
+
+func TestRecoveryErrAbortHandler(t *testing.T) {
+	handler := NewRecovery().ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("http.ErrAbortHandler should be propagated but got %v", err)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestRecoveryReporters(t *testing.T) {
+	var mu sync.Mutex
+	var reports []PanicReport
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report PanicReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Error(err)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Should send the headers")
+		}
+		mu.Lock()
+		reports = append(reports, report)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	crashlog := new(bytes.Buffer)
+	wh := NewWebhookReporter(webhook.URL)
+	wh.Headers = map[string]string{"Authorization": "Bearer secret"}
+
+	recovery := NewRecovery().(*Recovery)
+	recovery.Logger = log.New(ioutil.Discard, "", 0)
+	recovery.Reporters = []PanicReporter{NewCrashLogReporter(crashlog), wh}
+	recovery.DedupWindow = 50 * time.Millisecond
+
+	l := lion.New(recovery)
+	l.GetFunc("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		if lion.Param(r, "id") == "other" {
+			panic("other")
+		}
+		panic("OHOH")
+	})
+	test := htest.New(t, l)
+
+	for i := 0; i < 3; i++ {
+		test.Get("/users/42").Do().ExpectStatus(http.StatusInternalServerError)
+	}
+	test.Get("/users/other").Do()
+	// The suppressed duplicates are reported when the window expires
+	time.Sleep(100 * time.Millisecond)
+	test.Get("/users/42").Do()
+	if err := recovery.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reports) != 4 {
+		t.Fatalf("Expected 4 reports but got %d: %+v", len(reports), reports)
+	}
+	first := reports[0]
+	if first.Error != "OHOH" || first.Method != "GET" || first.URL != "/users/42" || first.Route != "/users/:id" ||
+		!strings.Contains(first.Location, "recovery_test.go") || first.Stack == "" || first.Occurrences != 1 {
+		t.Errorf("Incorrect report: %+v", first)
+	}
+	if reports[1].Error != "other" {
+		t.Errorf("Different panics should not be deduplicated: %+v", reports[1])
+	}
+	if reports[2].Error != "OHOH" || reports[2].Occurrences != 2 {
+		t.Errorf("The report should count the suppressed duplicates: %+v", reports[2])
+	}
+	if reports[3].Occurrences != 1 {
+		t.Errorf("The panic should be reported again once the window expired: %+v", reports[3])
+	}
+	if lines := strings.Count(crashlog.String(), "\n"); lines != 4 {
+		t.Errorf("Expected 4 lines in the crash log but got %d", lines)
+	}
+}
+
+func TestRecoverySlowReporter(t *testing.T) {
+	unblock := make(chan struct{})
+	var reported int32
+	recovery := NewRecovery().(*Recovery)
+	recovery.Logger = log.New(ioutil.Discard, "", 0)
+	recovery.QueueSize = 1
+	recovery.Reporters = []PanicReporter{PanicReporterFunc(func(ctx context.Context, report PanicReport) error {
+		<-unblock
+		atomic.AddInt32(&reported, 1)
+		return nil
+	})}
+
+	handler := recovery.ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("OHOH")
+	}))
+	test := htest.New(t, handler)
+
+	// Requests are not held by the reporter and the reports beyond the queue are dropped
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			test.Get("/").Do().ExpectStatus(http.StatusInternalServerError)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Requests should not wait for the reporters")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := recovery.Flush(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the flush to time out but got %v", err)
+	}
+	close(unblock)
+	if err := recovery.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&reported); n < 1 || n > 2 {
+		t.Errorf("Expected at most 2 reports, the one being sent and the queued one, but got %d", n)
+	}
+}
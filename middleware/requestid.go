@@ -2,8 +2,14 @@ package middleware
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/celrenheit/lion"
 	"github.com/nats-io/nuid"
 )
 
@@ -13,28 +19,213 @@ const CtxRequestIDKey ctxRequestIDKeyType = 0
 
 const (
 	headerXRequestID = "X-Request-ID"
+
+	maxRequestIDLength = 128
 )
 
+// RequestIDGenerator generates request IDs. It must be safe for concurrent use.
+type RequestIDGenerator func() string
+
+// RequestID is a middleware identifying each request with an ID, available with GetRequestID.
+//
+// The ID is generated by Generator, or, if TrustIncoming is set, taken from the HeaderName header of requests coming from TrustedProxies.
 type RequestID struct {
+	// SetHeader sets the ID in the HeaderName header of the response
 	SetHeader bool
-	n         *nuid.NUID
+	// HeaderName is the header of the ID, X-Request-ID by default
+	HeaderName string
+
+	// TrustIncoming accepts the IDs of incoming requests
+	TrustIncoming bool
+	// TrustedProxies lists the IPs or CIDRs of the upstreams whose IDs are accepted. All are trusted if empty.
+	TrustedProxies []string
+	// Validate reports whether an incoming ID is accepted.
+	// By default, IDs must be at most 128 characters among letters, digits and -_.:+/=
+	Validate func(id string) bool
+
+	// Generator generates the IDs, NUIDGenerator by default
+	Generator RequestIDGenerator
 }
 
+// NewRequestID creates a RequestID middleware generating NUIDs and setting them in the response headers
 func NewRequestID() *RequestID {
 	return &RequestID{
-		SetHeader: true,
-		n:         nuid.New(),
+		SetHeader:  true,
+		HeaderName: headerXRequestID,
+		Generator:  NUIDGenerator(),
 	}
 }
 
 func (rid *RequestID) ServeNext(next http.Handler) http.Handler {
+	trusted, err := parseCIDRs(rid.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+
+	header := rid.HeaderName
+	if header == "" {
+		header = headerXRequestID
+	}
+	generate := rid.Generator
+	if generate == nil {
+		generate = NUIDGenerator()
+	}
+
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		requestid := rid.n.Next()
+		requestid := rid.incoming(r, header, trusted)
+		if requestid == "" {
+			requestid = generate()
+		}
 		if rid.SetHeader {
-			w.Header().Set(headerXRequestID, requestid)
+			w.Header().Set(header, requestid)
+		}
+		setAccessLogRequestID(r, requestid)
+		if c := lion.C(r); c != nil {
+			c.WithLogAttrs("request_id", requestid)
 		}
 		ctx := context.WithValue(r.Context(), CtxRequestIDKey, requestid)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 	return http.HandlerFunc(fn)
 }
+
+// incoming returns the ID of r if it is trusted and valid
+func (rid *RequestID) incoming(r *http.Request, header string, trusted []*net.IPNet) string {
+	if !rid.TrustIncoming {
+		return ""
+	}
+	id := r.Header.Get(header)
+	if id == "" {
+		return ""
+	}
+	if len(trusted) > 0 && !ipInCIDRs(r.RemoteAddr, trusted) {
+		return ""
+	}
+	validate := rid.Validate
+	if validate == nil {
+		validate = validRequestID
+	}
+	if !validate(id) {
+		return ""
+	}
+	return id
+}
+
+func validRequestID(id string) bool {
+	if len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '/', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// GetRequestID returns the ID of the request of ctx, or an empty string
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(CtxRequestIDKey).(string)
+	return id
+}
+
+// NUIDGenerator generates NUIDs, e.g. "Ye4yMrbPYhdLN7EfCFTCbm"
+func NUIDGenerator() RequestIDGenerator {
+	return nuid.Next
+}
+
+// UUIDv4Generator generates random UUIDs, e.g. "9b2f6c0e-5d3a-4f7e-8c1b-2a6d4e8f0b13"
+func UUIDv4Generator() RequestIDGenerator {
+	return func() string {
+		var u [16]byte
+		rand.Read(u[:])
+		u[6] = u[6]&0x0f | 0x40
+		u[8] = u[8]&0x3f | 0x80
+		return formatUUID(u)
+	}
+}
+
+// UUIDv7Generator generates time-ordered UUIDs, e.g. "01890a5d-ac96-774b-bcce-b302099a8057"
+func UUIDv7Generator() RequestIDGenerator {
+	return func() string {
+		var u [16]byte
+		rand.Read(u[6:])
+		ms := uint64(time.Now().UnixMilli())
+		var ts [8]byte
+		binary.BigEndian.PutUint64(ts[:], ms)
+		copy(u[:6], ts[2:])
+		u[6] = u[6]&0x0f | 0x70
+		u[8] = u[8]&0x3f | 0x80
+		return formatUUID(u)
+	}
+}
+
+func formatUUID(u [16]byte) string {
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf)
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDGenerator generates ULIDs, e.g. "01ARZ3NDEKTSV4RRFFQ69G5FAV"
+func ULIDGenerator() RequestIDGenerator {
+	return func() string {
+		// 48 bits of milliseconds followed by 80 random bits
+		var b [16]byte
+		var ts [8]byte
+		binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixMilli()))
+		copy(b[:6], ts[2:])
+		rand.Read(b[6:])
+
+		// 128 bits are encoded in 26 characters of 5 bits, the first one having 3 bits
+		hi := binary.BigEndian.Uint64(b[:8])
+		lo := binary.BigEndian.Uint64(b[8:])
+		out := make([]byte, 26)
+		for i := 25; i >= 0; i-- {
+			out[i] = crockfordBase32[lo&0x1f]
+			lo = lo>>5 | hi<<59
+			hi >>= 5
+		}
+		return string(out)
+	}
+}
+
+// RequestIDTransport is an http.RoundTripper setting the ID of the request of the context of outbound requests in their headers
+type RequestIDTransport struct {
+	// Base is the underlying RoundTripper, http.DefaultTransport by default
+	Base http.RoundTripper
+	// HeaderName is the header of the ID, X-Request-ID by default
+	HeaderName string
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RequestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	header := t.HeaderName
+	if header == "" {
+		header = headerXRequestID
+	}
+	id := GetRequestID(req.Context())
+	if id == "" || req.Header.Get(header) != "" {
+		return base.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set(header, id)
+	return base.RoundTrip(req)
+}
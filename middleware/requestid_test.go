@@ -1,10 +1,17 @@
 package middleware
 
 import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
 )
 
 func TestRequestID(t *testing.T) {
@@ -32,3 +39,108 @@ func TestRequestID(t *testing.T) {
 		t.Errorf("Should have the header %s set", headerXRequestID)
 	}
 }
+
+func TestRequestIDLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := lion.New(NewRealIP(), NewRequestID())
+	l.Configure(lion.WithRequestLogger(slog.New(slog.NewJSONHandler(buf, nil))))
+	l.GetFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		lion.C(r).Logger().Info("handler")
+	})
+
+	proxied := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = "127.0.0.1:4242"
+		l.ServeHTTP(w, r)
+	})
+	id := htest.New(t, proxied).Get("/").SetHeader("X-Forwarded-For", "10.0.0.1").Do().
+		Recorder().Header().Get(headerXRequestID)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["request_id"] != id || record["remote_ip"] != "10.0.0.1" || record["route"] != "/" {
+		t.Errorf("Incorrect request logger attributes: %s", buf.String())
+	}
+}
+
+func TestRequestIDTrustIncoming(t *testing.T) {
+	rid := NewRequestID()
+	rid.HeaderName = "X-Correlation-ID"
+	rid.TrustIncoming = true
+	rid.TrustedProxies = []string{"10.0.0.0/8"}
+	handler := rid.ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(GetRequestID(r.Context())))
+	}))
+	test := htest.New(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = r.Header.Get("X-Test-Remote")
+		handler.ServeHTTP(w, r)
+	}))
+
+	test.Get("/").SetHeader("X-Correlation-ID", "upstream-id").SetHeader("X-Test-Remote", "10.0.0.1:1234").Do().
+		ExpectHeader("X-Correlation-ID", "upstream-id").
+		ExpectBody("upstream-id")
+
+	// Untrusted upstreams and invalid IDs are replaced
+	replaced := map[string]string{
+		"upstream-id":            "192.168.1.1:1234",
+		"<script>":               "10.0.0.1:1234",
+		strings.Repeat("a", 129): "10.0.0.1:1234",
+	}
+	for id, remote := range replaced {
+		body := test.Get("/").SetHeader("X-Correlation-ID", id).SetHeader("X-Test-Remote", remote).Do().
+			Recorder().Body.String()
+		if body == id || body == "" {
+			t.Errorf("The incoming ID %q from %s should be replaced: %q", id, remote, body)
+		}
+	}
+}
+
+func TestRequestIDGenerators(t *testing.T) {
+	generators := map[string]struct {
+		gen RequestIDGenerator
+		re  *regexp.Regexp
+	}{
+		"nuid":   {NUIDGenerator(), regexp.MustCompile(`^[0-9A-Za-z]{22}$`)},
+		"uuidv4": {UUIDv4Generator(), regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+		"uuidv7": {UUIDv7Generator(), regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+		"ulid":   {ULIDGenerator(), regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)},
+	}
+	for name, g := range generators {
+		a, b := g.gen(), g.gen()
+		if !g.re.MatchString(a) || a == b {
+			t.Errorf("Incorrect %s IDs: %s %s", name, a, b)
+		}
+	}
+
+	// Time-ordered IDs start with the timestamp
+	if a, b := ULIDGenerator()(), ULIDGenerator()(); a[:8] > b[:8] {
+		t.Errorf("ULIDs should be time-ordered: %s %s", a, b)
+	}
+}
+
+func TestRequestIDTransport(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(headerXRequestID)))
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: &RequestIDTransport{}}
+	var propagated string
+	handler := NewRequestID().ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequest("GET", upstream.URL, nil)
+		res, err := client.Do(req.WithContext(r.Context()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b := new(bytes.Buffer)
+		b.ReadFrom(res.Body)
+		propagated = b.String()
+	}))
+
+	id := htest.New(t, handler).Get("/").Do().Recorder().Header().Get(headerXRequestID)
+	if id == "" || propagated != id {
+		t.Errorf("The request ID should be propagated: %q %q", id, propagated)
+	}
+}
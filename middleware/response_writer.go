@@ -95,11 +95,16 @@ func (b *basicWriter) Flush() {
 
 func (b *basicWriter) ReadFrom(r io.Reader) (int64, error) {
 	if b.tee != nil {
-		return io.Copy(b, r)
+		return io.Copy(writerOnly{b}, r)
+	}
+	rf, ok := b.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(writerOnly{b}, r)
 	}
-	rf := b.ResponseWriter.(io.ReaderFrom)
 	if !b.Written() {
-		b.ResponseWriter.WriteHeader(http.StatusOK)
+		b.WriteHeader(http.StatusOK)
 	}
-	return rf.ReadFrom(r)
+	n, err := rf.ReadFrom(r)
+	b.bytes += int(n)
+	return n, err
 }
@@ -0,0 +1,294 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ctxSecureHeadersKeyType int
+
+const ctxSecureHeadersKey ctxSecureHeadersKeyType = 0
+
+// CSPNonceSource is a placeholder replaced by the nonce of the request in the sources of a CSP,
+// e.g. NewCSP().Set("script-src", "'self'", middleware.CSPNonceSource)
+const CSPNonceSource = "'nonce'"
+
+// SecureHeaders is a middleware setting security related headers and redirecting HTTP requests to HTTPS.
+//
+// Headers with an empty value are not sent. A SecureHeaders used in a group replaces the headers set by the one of a parent router,
+// use With to derive it from the parent configuration:
+//
+//	secure := middleware.NewSecureHeaders()
+//	secure.CSP = middleware.NewCSP().Set("default-src", "'self'").Set("script-src", "'self'", middleware.CSPNonceSource)
+//	l := lion.New(secure)
+//	admin := l.Group("/admin", secure.With(func(s *middleware.SecureHeaders) {
+//		s.FrameOptions = "DENY"
+//	}))
+type SecureHeaders struct {
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header, sent to secure requests only. Zero disables HSTS.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	// ContentTypeNosniff sets X-Content-Type-Options to nosniff
+	ContentTypeNosniff bool
+	// FrameOptions is the value of the X-Frame-Options header, e.g. DENY or SAMEORIGIN
+	FrameOptions string
+	// ReferrerPolicy is the value of the Referrer-Policy header
+	ReferrerPolicy string
+	// PermissionsPolicy is the value of the Permissions-Policy header, e.g. "geolocation=(), camera=()"
+	PermissionsPolicy string
+
+	// CSP is the Content-Security-Policy
+	CSP CSP
+	// CSPReportOnly sends the CSP in the Content-Security-Policy-Report-Only header instead
+	CSPReportOnly bool
+
+	// SSLRedirect redirects HTTP requests to HTTPS
+	SSLRedirect bool
+	// SSLHost is the host to redirect to. It defaults to the host of the request.
+	SSLHost string
+	// TrustedProxies lists the IPs or CIDRs of the proxies whose X-Forwarded-Proto header is trusted
+	TrustedProxies []string
+}
+
+// NewSecureHeaders creates a SecureHeaders middleware with a one year HSTS, nosniff, SAMEORIGIN frame options and
+// the strict-origin-when-cross-origin referrer policy
+func NewSecureHeaders() *SecureHeaders {
+	return &SecureHeaders{
+		HSTSMaxAge:         365 * 24 * time.Hour,
+		ContentTypeNosniff: true,
+		FrameOptions:       "SAMEORIGIN",
+		ReferrerPolicy:     "strict-origin-when-cross-origin",
+	}
+}
+
+// With returns a copy of s modified by fn, for example to tighten or relax the policy of a group
+func (s *SecureHeaders) With(fn func(*SecureHeaders)) *SecureHeaders {
+	c := *s
+	c.CSP = s.CSP.Clone()
+	c.TrustedProxies = append([]string(nil), s.TrustedProxies...)
+	fn(&c)
+	return &c
+}
+
+// ServeNext implements the Middleware interface for SecureHeaders.
+func (s *SecureHeaders) ServeNext(next http.Handler) http.Handler {
+	trusted, err := parseCIDRs(s.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		secure := isSecure(r, trusted)
+		if s.SSLRedirect && !secure {
+			s.redirect(w, r)
+			return
+		}
+
+		// The nonce is shared with the SecureHeaders of groups
+		nonce, ok := r.Context().Value(ctxSecureHeadersKey).(string)
+		if !ok {
+			nonce = newCSPNonce()
+			r = r.WithContext(context.WithValue(r.Context(), ctxSecureHeadersKey, nonce))
+		}
+
+		h := w.Header()
+		hsts := ""
+		if secure && s.HSTSMaxAge > 0 {
+			hsts = fmt.Sprintf("max-age=%d", int(s.HSTSMaxAge/time.Second))
+			if s.HSTSIncludeSubdomains {
+				hsts += "; includeSubDomains"
+			}
+			if s.HSTSPreload {
+				hsts += "; preload"
+			}
+		}
+		setOrDel(h, "Strict-Transport-Security", hsts)
+		nosniff := ""
+		if s.ContentTypeNosniff {
+			nosniff = "nosniff"
+		}
+		setOrDel(h, "X-Content-Type-Options", nosniff)
+		setOrDel(h, "X-Frame-Options", s.FrameOptions)
+		setOrDel(h, "Referrer-Policy", s.ReferrerPolicy)
+		setOrDel(h, "Permissions-Policy", s.PermissionsPolicy)
+
+		h.Del("Content-Security-Policy")
+		h.Del("Content-Security-Policy-Report-Only")
+		if policy := s.CSP.String(nonce); policy != "" {
+			if s.CSPReportOnly {
+				h.Set("Content-Security-Policy-Report-Only", policy)
+			} else {
+				h.Set("Content-Security-Policy", policy)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// isSecure reports whether r has been received over HTTPS, directly or by a trusted proxy
+func isSecure(r *http.Request, trusted []*net.IPNet) bool {
+	if r.TLS != nil {
+		return true
+	}
+	// RealIP has already checked the proxies and rewritten RemoteAddr
+	if info, ok := GetRealIPInfo(r.Context()); ok && info.Scheme != "" {
+		return info.Scheme == "https"
+	}
+	proto := r.Header.Get("X-Forwarded-Proto")
+	if proto == "" || !ipInCIDRs(r.RemoteAddr, trusted) {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(strings.Split(proto, ",")[0]), "https")
+}
+
+func (s *SecureHeaders) redirect(w http.ResponseWriter, r *http.Request) {
+	host := s.SSLHost
+	if host == "" {
+		host = r.Host
+	}
+	code := http.StatusMovedPermanently
+	if r.Method != "GET" && r.Method != "HEAD" {
+		// Keep the method and the body
+		code = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+}
+
+// CSPNonce returns the CSP nonce of the request, to be used in the nonce attribute of scripts and styles of templates
+func CSPNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(ctxSecureHeadersKey).(string)
+	return nonce
+}
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func setOrDel(h http.Header, key, value string) {
+	if value == "" {
+		h.Del(key)
+		return
+	}
+	h.Set(key, value)
+}
+
+// CSP is a Content-Security-Policy builder mapping directives to their sources
+type CSP map[string][]string
+
+// NewCSP creates an empty CSP
+func NewCSP() CSP {
+	return make(CSP)
+}
+
+// Set replaces the sources of directive
+func (c CSP) Set(directive string, sources ...string) CSP {
+	c[directive] = sources
+	return c
+}
+
+// Add adds sources to directive
+func (c CSP) Add(directive string, sources ...string) CSP {
+	c[directive] = append(c[directive], sources...)
+	return c
+}
+
+// Remove removes directive
+func (c CSP) Remove(directive string) CSP {
+	delete(c, directive)
+	return c
+}
+
+// Clone returns a copy of c
+func (c CSP) Clone() CSP {
+	if c == nil {
+		return nil
+	}
+	clone := make(CSP, len(c))
+	for directive, sources := range c {
+		clone[directive] = append([]string(nil), sources...)
+	}
+	return clone
+}
+
+// String returns the policy, with CSPNonceSource replaced by nonce. Directives are sorted, default-src first.
+func (c CSP) String(nonce string) string {
+	directives := make([]string, 0, len(c))
+	for directive := range c {
+		directives = append(directives, directive)
+	}
+	sort.Slice(directives, func(i, j int) bool {
+		if directives[i] == "default-src" || directives[j] == "default-src" {
+			return directives[i] == "default-src"
+		}
+		return directives[i] < directives[j]
+	})
+
+	parts := make([]string, 0, len(directives))
+	for _, directive := range directives {
+		part := directive
+		for _, source := range c[directive] {
+			if source == CSPNonceSource {
+				source = "'nonce-" + nonce + "'"
+			}
+			part += " " + source
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// parseCIDRs parses IPs and CIDRs
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("lion: invalid IP %q", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("lion: invalid CIDR %q", cidr)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ipInCIDRs reports whether the IP of addr, with or without port, is in nets
+func ipInCIDRs(addr string, nets []*net.IPNet) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
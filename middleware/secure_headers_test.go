@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	secure := NewSecureHeaders()
+	secure.HSTSIncludeSubdomains = true
+	secure.HSTSPreload = true
+	secure.PermissionsPolicy = "camera=()"
+	secure.TrustedProxies = []string{"10.0.0.0/8"}
+	secure.CSP = NewCSP().Set("script-src", "'self'", CSPNonceSource).Set("default-src", "'self'")
+
+	var nonce string
+	l := lion.New(secure)
+	l.GetFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		nonce = CSPNonce(r)
+	})
+	admin := l.Group("/admin", secure.With(func(s *SecureHeaders) {
+		s.FrameOptions = "DENY"
+		s.PermissionsPolicy = ""
+		s.CSP.Add("script-src", "https://cdn.example.com")
+	}))
+	admin.GetFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		nonce = CSPNonce(r)
+	})
+
+	test := htest.New(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = "10.0.0.1:1234"
+		l.ServeHTTP(w, r)
+	}))
+
+	res := test.Get("/").Do().
+		ExpectHeader("X-Content-Type-Options", "nosniff").
+		ExpectHeader("X-Frame-Options", "SAMEORIGIN").
+		ExpectHeader("Referrer-Policy", "strict-origin-when-cross-origin").
+		ExpectHeader("Permissions-Policy", "camera=()").
+		// HSTS is only sent over HTTPS
+		ExpectHeader("Strict-Transport-Security", "")
+	if nonce == "" {
+		t.Fatal("No CSP nonce")
+	}
+	if csp := res.Recorder().Header().Get("Content-Security-Policy"); csp != "default-src 'self'; script-src 'self' 'nonce-"+nonce+"'" {
+		t.Errorf("Incorrect CSP: %s", csp)
+	}
+
+	test.Get("/").SetHeader("X-Forwarded-Proto", "https").Do().
+		ExpectHeader("Strict-Transport-Security", "max-age=31536000; includeSubDomains; preload")
+
+	res = test.Get("/admin").Do().
+		ExpectHeader("X-Frame-Options", "DENY").
+		ExpectHeader("Permissions-Policy", "")
+	if csp := res.Recorder().Header().Get("Content-Security-Policy"); csp != "default-src 'self'; script-src 'self' 'nonce-"+nonce+"' https://cdn.example.com" {
+		t.Errorf("Incorrect CSP for group: %s", csp)
+	}
+	// The parent policy is not modified
+	if len(secure.CSP["script-src"]) != 2 {
+		t.Errorf("With should not modify the parent policy")
+	}
+}
+
+func TestSecureHeadersSSLRedirect(t *testing.T) {
+	secure := &SecureHeaders{SSLRedirect: true, TrustedProxies: []string{"10.0.0.1"}, HSTSMaxAge: time.Hour}
+	handler := secure.ServeNext(fakeHandler())
+	test := htest.New(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Host = "example.com"
+		if r.Header.Get("X-Test-Proxy") != "" {
+			r.RemoteAddr = r.Header.Get("X-Test-Proxy")
+		}
+		handler.ServeHTTP(w, r)
+	}))
+
+	test.Get("/path?q=1").Do().
+		ExpectStatus(http.StatusMovedPermanently).
+		ExpectHeader("Location", "https://example.com/path?q=1")
+	test.Post("/path").Do().
+		ExpectStatus(http.StatusPermanentRedirect)
+	test.Get("/").SetHeader("X-Forwarded-Proto", "https").SetHeader("X-Test-Proxy", "10.0.0.1:1234").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Strict-Transport-Security", "max-age=3600").
+		ExpectHeader("Content-Security-Policy", "")
+	// X-Forwarded-Proto from untrusted clients is ignored
+	test.Get("/").SetHeader("X-Forwarded-Proto", "https").SetHeader("X-Test-Proxy", "192.168.1.1:1234").Do().
+		ExpectStatus(http.StatusMovedPermanently)
+
+	// A group can require HTTPS when the root does not
+	sh := NewSecureHeaders()
+	l := lion.New(sh)
+	l.GetFunc("/x", func(w http.ResponseWriter, r *http.Request) {})
+	l.Group("/admin", sh.With(func(s *SecureHeaders) {
+		s.SSLRedirect = true
+		s.SSLHost = "example.com"
+	})).GetFunc("/x", func(w http.ResponseWriter, r *http.Request) {})
+	test = htest.New(t, l)
+	test.Get("/x").Do().
+		ExpectStatus(http.StatusOK)
+	test.Get("/admin/x").Do().
+		ExpectStatus(http.StatusMovedPermanently).
+		ExpectHeader("Location", "https://example.com/admin/x")
+}
+
+func TestCSP(t *testing.T) {
+	csp := NewCSP().Set("img-src", "*").Set("default-src", "'none'").Add("img-src", "data:").Remove("img-src").Set("connect-src", "'self'")
+	if s := csp.String(""); s != "default-src 'none'; connect-src 'self'" {
+		t.Errorf("Incorrect policy: %s", s)
+	}
+	if !strings.Contains(NewCSP().Set("style-src", CSPNonceSource).String("abc"), "'nonce-abc'") {
+		t.Errorf("The nonce should be replaced")
+	}
+}
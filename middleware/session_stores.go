@@ -0,0 +1,222 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"sync"
+	"time"
+)
+
+var errSessionCookieTooLarge = errors.New("Session cookie too large, use a server-side store")
+
+// maxSessionCookieSize is the maximum size of a cookie value accepted by most browsers
+const maxSessionCookieSize = 4000
+
+// CookieSessionStore is a SessionStore keeping sessions in the session cookie, encrypted and authenticated with AES-GCM.
+//
+// Keys can be rotated by prepending a new key: sessions are sealed with the first key and opened with any of them.
+type CookieSessionStore struct {
+	aeads []cipher.AEAD
+}
+
+// NewCookieSessionStore creates a CookieSessionStore using keys, the first one being used to seal new sessions.
+// Keys should be at least 32 random bytes; they are derived to AES-256 keys with SHA-256.
+func NewCookieSessionStore(keys ...[]byte) *CookieSessionStore {
+	if len(keys) == 0 {
+		panic("lion: NewCookieSessionStore requires at least one key")
+	}
+	s := &CookieSessionStore{}
+	for _, key := range keys {
+		k := sha256.Sum256(key)
+		block, err := aes.NewCipher(k[:])
+		if err != nil {
+			panic(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			panic(err)
+		}
+		s.aeads = append(s.aeads, aead)
+	}
+	return s
+}
+
+// cookieSession is the content of a session cookie
+type cookieSession struct {
+	ID      string
+	Values  map[string]interface{}
+	Expires int64
+}
+
+// Load implements SessionStore
+func (s *CookieSessionStore) Load(ctx context.Context, cookie string) (string, map[string]interface{}, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err != nil {
+		return "", nil, ErrSessionNotFound
+	}
+
+	for _, aead := range s.aeads {
+		if len(sealed) < aead.NonceSize() {
+			return "", nil, ErrSessionNotFound
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			continue
+		}
+
+		var session cookieSession
+		if err := gob.NewDecoder(bytes.NewReader(plaintext)).Decode(&session); err != nil {
+			return "", nil, err
+		}
+		if session.Expires != 0 && time.Now().Unix() > session.Expires {
+			return "", nil, ErrSessionNotFound
+		}
+		return session.ID, session.Values, nil
+	}
+	return "", nil, ErrSessionNotFound
+}
+
+// Save implements SessionStore
+func (s *CookieSessionStore) Save(ctx context.Context, id string, values map[string]interface{}, maxAge time.Duration) (string, error) {
+	session := cookieSession{ID: id, Values: values}
+	if maxAge > 0 {
+		session.Expires = time.Now().Add(maxAge).Unix()
+	}
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(session); err != nil {
+		return "", err
+	}
+
+	aead := s.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+buf.Len()+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	cookie := base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, buf.Bytes(), nil))
+	if len(cookie) > maxSessionCookieSize {
+		return "", errSessionCookieTooLarge
+	}
+	return cookie, nil
+}
+
+// Delete implements SessionStore. Cookie sessions are deleted by expiring the cookie.
+func (s *CookieSessionStore) Delete(ctx context.Context, id string) error {
+	return nil
+}
+
+// SessionBackend stores encoded sessions on the server side
+type SessionBackend interface {
+	// Get returns the data of the session identified by id, or ErrSessionNotFound
+	Get(ctx context.Context, id string) ([]byte, error)
+	// Set stores the data of the session identified by id for ttl
+	Set(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	// Delete deletes the session identified by id
+	Delete(ctx context.Context, id string) error
+}
+
+// ServerSessionStore is a SessionStore keeping sessions in a SessionBackend, the session cookie only containing their ID
+type ServerSessionStore struct {
+	Backend SessionBackend
+}
+
+// NewServerSessionStore creates a ServerSessionStore using backend
+func NewServerSessionStore(backend SessionBackend) *ServerSessionStore {
+	return &ServerSessionStore{Backend: backend}
+}
+
+// Load implements SessionStore
+func (s *ServerSessionStore) Load(ctx context.Context, cookie string) (string, map[string]interface{}, error) {
+	data, err := s.Backend.Get(ctx, cookie)
+	if err != nil {
+		return "", nil, err
+	}
+	var values map[string]interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+		return "", nil, err
+	}
+	return cookie, values, nil
+}
+
+// Save implements SessionStore
+func (s *ServerSessionStore) Save(ctx context.Context, id string, values map[string]interface{}, maxAge time.Duration) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(values); err != nil {
+		return "", err
+	}
+	if err := s.Backend.Set(ctx, id, buf.Bytes(), maxAge); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Delete implements SessionStore
+func (s *ServerSessionStore) Delete(ctx context.Context, id string) error {
+	return s.Backend.Delete(ctx, id)
+}
+
+// MemorySessionBackend is a SessionBackend keeping sessions in memory until they expire
+type MemorySessionBackend struct {
+	mu        sync.RWMutex
+	sessions  map[string]memorySession
+	nextSweep time.Time
+}
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewMemorySessionBackend creates an empty MemorySessionBackend
+func NewMemorySessionBackend() *MemorySessionBackend {
+	return &MemorySessionBackend{sessions: make(map[string]memorySession)}
+}
+
+// Get implements SessionBackend
+func (b *MemorySessionBackend) Get(ctx context.Context, id string) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	s, ok := b.sessions[id]
+	if !ok || (!s.expires.IsZero() && time.Now().After(s.expires)) {
+		return nil, ErrSessionNotFound
+	}
+	return s.data, nil
+}
+
+// Set implements SessionBackend
+func (b *MemorySessionBackend) Set(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.After(b.nextSweep) {
+		for id, s := range b.sessions {
+			if !s.expires.IsZero() && now.After(s.expires) {
+				delete(b.sessions, id)
+			}
+		}
+		b.nextSweep = now.Add(time.Minute)
+	}
+
+	s := memorySession{data: append([]byte(nil), data...)}
+	if ttl > 0 {
+		s.expires = now.Add(ttl)
+	}
+	b.sessions[id] = s
+	return nil
+}
+
+// Delete implements SessionBackend
+func (b *MemorySessionBackend) Delete(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.sessions, id)
+	return nil
+}
@@ -0,0 +1,338 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type ctxSessionKeyType int
+
+const ctxSessionKey ctxSessionKeyType = 0
+
+const sessionFlashesKey = "_flashes"
+
+var (
+	// ErrSessionNotFound should be returned by a SessionStore when a session does not exist, has expired or cannot be authenticated
+	ErrSessionNotFound = errors.New("Session not found")
+)
+
+func init() {
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+}
+
+// SessionStore loads and saves sessions
+type SessionStore interface {
+	// Load returns the ID and the values of the session referenced by the value of the session cookie, or ErrSessionNotFound
+	Load(ctx context.Context, cookie string) (id string, values map[string]interface{}, err error)
+	// Save saves the values of the session identified by id for maxAge and returns the value of the session cookie
+	Save(ctx context.Context, id string, values map[string]interface{}, maxAge time.Duration) (cookie string, err error)
+	// Delete deletes the session identified by id
+	Delete(ctx context.Context, id string) error
+}
+
+// Sessions is a middleware providing a session to each request, retrieved with GetSession.
+//
+// Sessions are loaded on first use and saved, along with the session cookie, only if they have been modified.
+// Values are encoded with encoding/gob: custom types must be registered with gob.Register.
+//
+//	l.Use(middleware.NewSessions(middleware.NewCookieSessionStore(key)))
+//	l.GetFunc("/", func(w http.ResponseWriter, r *http.Request) {
+//		session := middleware.GetSession(r)
+//		visits, _ := session.Get("visits").(int)
+//		session.Set("visits", visits+1)
+//	})
+type Sessions struct {
+	Store SessionStore
+
+	// Cookie attributes
+	CookieName string
+	Path       string
+	Domain     string
+	MaxAge     time.Duration
+	Secure     bool
+	HTTPOnly   bool
+	SameSite   http.SameSite
+
+	// ErrorHandler is called when a session cannot be loaded or saved. Errors are logged by default.
+	ErrorHandler func(r *http.Request, err error)
+}
+
+// NewSessions creates a Sessions middleware using store, with a cookie named "lion_session" valid for 24 hours
+func NewSessions(store SessionStore) *Sessions {
+	return &Sessions{
+		Store:      store,
+		CookieName: "lion_session",
+		Path:       "/",
+		MaxAge:     24 * time.Hour,
+		HTTPOnly:   true,
+		SameSite:   http.SameSiteLaxMode,
+	}
+}
+
+// ServeNext implements the Middleware interface for Sessions.
+func (s *Sessions) ServeNext(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		st := &sessionState{sessions: s}
+		r = r.WithContext(context.WithValue(r.Context(), ctxSessionKey, st))
+		st.req = r
+
+		sw := &sessionWriter{ResponseWriter: wrapResponseWriter(w), state: st}
+		next.ServeHTTP(sw, r)
+		st.save(sw)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// GetSession returns the session of the request, or nil if the Sessions middleware is not used
+func GetSession(r *http.Request) *Session {
+	st, ok := r.Context().Value(ctxSessionKey).(*sessionState)
+	if !ok {
+		return nil
+	}
+	return st.session()
+}
+
+func (s *Sessions) error(r *http.Request, err error) {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(r, err)
+		return
+	}
+	lionLogger.Printf("sessions: %v", err)
+}
+
+func (s *Sessions) cookie(value string, maxAge time.Duration) *http.Cookie {
+	c := &http.Cookie{
+		Name:     s.CookieName,
+		Value:    value,
+		Path:     s.Path,
+		Domain:   s.Domain,
+		Secure:   s.Secure,
+		HttpOnly: s.HTTPOnly,
+		SameSite: s.SameSite,
+	}
+	if maxAge < 0 {
+		c.MaxAge = -1
+		c.Expires = time.Unix(1, 0)
+	} else if maxAge > 0 {
+		c.MaxAge = int(maxAge / time.Second)
+		c.Expires = time.Now().Add(maxAge)
+	}
+	return c
+}
+
+// sessionState loads the session of a request lazily and saves it before the response is written
+type sessionState struct {
+	sessions *Sessions
+	req      *http.Request
+
+	once  sync.Once
+	s     *Session
+	saved bool
+}
+
+func (st *sessionState) session() *Session {
+	st.once.Do(func() {
+		st.s = &Session{id: newSessionID(), values: make(map[string]interface{}), isNew: true}
+		c, err := st.req.Cookie(st.sessions.CookieName)
+		if err != nil || c.Value == "" {
+			return
+		}
+		id, values, err := st.sessions.Store.Load(st.req.Context(), c.Value)
+		if err != nil {
+			if err != ErrSessionNotFound {
+				st.sessions.error(st.req, err)
+			}
+			return
+		}
+		if values == nil {
+			values = make(map[string]interface{})
+		}
+		st.s.id = id
+		st.s.values = values
+		st.s.isNew = false
+	})
+	return st.s
+}
+
+func (st *sessionState) save(w http.ResponseWriter) {
+	if st.saved || st.s == nil {
+		return
+	}
+	st.saved = true
+
+	s := st.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx := st.req.Context()
+	store := st.sessions.Store
+	if s.previousID != "" {
+		if err := store.Delete(ctx, s.previousID); err != nil {
+			st.sessions.error(st.req, err)
+		}
+	}
+
+	if s.destroyed {
+		if !s.isNew {
+			if err := store.Delete(ctx, s.id); err != nil {
+				st.sessions.error(st.req, err)
+			}
+		}
+		http.SetCookie(w, st.sessions.cookie("", -1))
+		return
+	}
+	if !s.modified {
+		return
+	}
+
+	value, err := store.Save(ctx, s.id, s.values, st.sessions.MaxAge)
+	if err != nil {
+		st.sessions.error(st.req, err)
+		return
+	}
+	http.SetCookie(w, st.sessions.cookie(value, st.sessions.MaxAge))
+}
+
+var _ ResponseWriter = (*sessionWriter)(nil)
+
+// sessionWriter saves the session before the header is written
+type sessionWriter struct {
+	ResponseWriter
+	state *sessionState
+}
+
+func (sw *sessionWriter) WriteHeader(code int) {
+	if sw.Status() == 0 {
+		sw.state.save(sw.ResponseWriter)
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *sessionWriter) Write(b []byte) (int, error) {
+	if sw.Status() == 0 {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *sessionWriter) Flush() {
+	if sw.Status() == 0 {
+		sw.WriteHeader(http.StatusOK)
+	}
+	sw.ResponseWriter.Flush()
+}
+
+// Session holds the values of a user session
+type Session struct {
+	mu         sync.Mutex
+	id         string
+	previousID string
+	values     map[string]interface{}
+	isNew      bool
+	modified   bool
+	destroyed  bool
+}
+
+// ID returns the ID of the session
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew reports whether the session did not exist before the request
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// Get returns the value associated to key
+func (s *Session) Get(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// Set associates value to key
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.modified = true
+}
+
+// Delete deletes the value associated to key
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.modified = true
+	}
+}
+
+// Clear deletes all the values of the session
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.values) > 0 {
+		s.values = make(map[string]interface{})
+		s.modified = true
+	}
+}
+
+// AddFlash adds a flash message, kept until it is read with Flashes
+func (s *Session) AddFlash(value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes, _ := s.values[sessionFlashesKey].([]interface{})
+	s.values[sessionFlashesKey] = append(flashes, value)
+	s.modified = true
+}
+
+// Flashes returns and removes the flash messages
+func (s *Session) Flashes() []interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes, ok := s.values[sessionFlashesKey].([]interface{})
+	if ok {
+		delete(s.values, sessionFlashesKey)
+		s.modified = true
+	}
+	return flashes
+}
+
+// RegenerateID gives a new ID to the session, keeping its values.
+// It should be called when the privileges of the user change, e.g. on login, to prevent session fixation.
+func (s *Session) RegenerateID() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isNew && s.previousID == "" {
+		s.previousID = s.id
+	}
+	s.id = newSessionID()
+	s.modified = true
+}
+
+// Destroy deletes the session and its cookie
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = make(map[string]interface{})
+	s.destroyed = true
+}
+
+func newSessionID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+)
+
+func newTestSessionsRouter(sessions *Sessions) *lion.Router {
+	l := lion.New(sessions)
+	l.GetFunc("/visit", func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		visits, _ := session.Get("visits").(int)
+		session.Set("visits", visits+1)
+		fmt.Fprintf(w, "%d", visits+1)
+	})
+	l.GetFunc("/read", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%v", GetSession(r).Get("visits"))
+	})
+	l.GetFunc("/flash", func(w http.ResponseWriter, r *http.Request) {
+		GetSession(r).AddFlash("saved")
+	})
+	l.GetFunc("/flashes", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%v", GetSession(r).Flashes())
+	})
+	l.GetFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		session.RegenerateID()
+		session.Set("user", "lion")
+		w.Write([]byte(session.ID()))
+	})
+	l.GetFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		GetSession(r).Destroy()
+	})
+	l.GetFunc("/none", func(w http.ResponseWriter, r *http.Request) {})
+	return l
+}
+
+func sessionCookie(t *testing.T, res htest.ResponseAsserter) string {
+	for _, c := range res.Recorder().Result().Cookies() {
+		if c.Name == "lion_session" {
+			return c.Name + "=" + c.Value
+		}
+	}
+	t.Fatalf("No session cookie")
+	return ""
+}
+
+func TestSessions(t *testing.T) {
+	stores := map[string]SessionStore{
+		"cookie": NewCookieSessionStore([]byte("a very secret key")),
+		"server": NewServerSessionStore(NewMemorySessionBackend()),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			test := htest.New(t, newTestSessionsRouter(NewSessions(store)))
+
+			// Sessions are saved only when modified
+			if h := test.Get("/none").Do().Recorder().Header().Get("Set-Cookie"); h != "" {
+				t.Errorf("Unmodified sessions should not be saved: %s", h)
+			}
+
+			res := test.Get("/visit").Do().ExpectBody("1")
+			if h := res.Recorder().Header().Get("Set-Cookie"); !strings.Contains(h, "HttpOnly") || !strings.Contains(h, "SameSite=Lax") || !strings.Contains(h, "Path=/") {
+				t.Errorf("Incorrect cookie attributes: %s", h)
+			}
+			cookie := sessionCookie(t, res)
+			cookie = sessionCookie(t, test.Get("/visit").SetHeader("Cookie", cookie).Do().ExpectBody("2"))
+			test.Get("/read").SetHeader("Cookie", cookie).Do().
+				ExpectHeader("Set-Cookie", "").
+				ExpectBody("2")
+
+			// Flashes are read once
+			cookie = sessionCookie(t, test.Get("/flash").SetHeader("Cookie", cookie).Do())
+			cookie = sessionCookie(t, test.Get("/flashes").SetHeader("Cookie", cookie).Do().ExpectBody("[saved]"))
+			test.Get("/flashes").SetHeader("Cookie", cookie).Do().ExpectBody("[]")
+
+			// Tampered cookies start a new session
+			test.Get("/read").SetHeader("Cookie", cookie+"x").Do().ExpectBody("<nil>")
+
+			// Logout expires the cookie
+			res = test.Get("/logout").SetHeader("Cookie", cookie).Do()
+			if h := res.Recorder().Header().Get("Set-Cookie"); !strings.Contains(h, "Max-Age=0") {
+				t.Errorf("The session cookie should be expired: %s", h)
+			}
+		})
+	}
+}
+
+func TestSessionsRegenerateID(t *testing.T) {
+	backend := NewMemorySessionBackend()
+	test := htest.New(t, newTestSessionsRouter(NewSessions(NewServerSessionStore(backend))))
+
+	before := sessionCookie(t, test.Get("/visit").Do())
+	res := test.Get("/login").SetHeader("Cookie", before).Do()
+	after := sessionCookie(t, res)
+	if after == before || after != "lion_session="+res.Recorder().Body.String() {
+		t.Fatalf("The session ID should have been regenerated: %s, %s", before, after)
+	}
+
+	// The old ID is no longer valid and the values are kept
+	test.Get("/read").SetHeader("Cookie", before).Do().ExpectBody("<nil>")
+	test.Get("/read").SetHeader("Cookie", after).Do().ExpectBody("1")
+
+	test.Get("/logout").SetHeader("Cookie", after).Do()
+	test.Get("/read").SetHeader("Cookie", after).Do().ExpectBody("<nil>")
+}
+
+func TestCookieSessionStoreKeyRotation(t *testing.T) {
+	oldStore := NewCookieSessionStore([]byte("old key"))
+	cookie, err := oldStore.Save(context.Background(), "id", map[string]interface{}{"user": "lion"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := NewCookieSessionStore([]byte("new key"), []byte("old key"))
+	id, values, err := rotated.Load(context.Background(), cookie)
+	if err != nil || id != "id" || values["user"] != "lion" {
+		t.Fatalf("Sessions sealed with an old key should be loaded: %v %v %v", id, values, err)
+	}
+	if _, _, err := NewCookieSessionStore([]byte("new key")).Load(context.Background(), cookie); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound but got %v", err)
+	}
+}
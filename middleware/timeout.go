@@ -0,0 +1,281 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/celrenheit/lion"
+)
+
+type ctxTimeoutKeyType int
+
+const ctxTimeoutKey ctxTimeoutKeyType = 0
+
+// timeoutWriteGrace is added to the write deadline of the connection to leave time to respond after a timeout
+const timeoutWriteGrace = time.Second
+
+var errTimeoutHijacked = errors.New("Connection cannot be hijacked after a timeout")
+
+// Timeout is a middleware that cancels the context of requests taking longer than Duration
+// and responds through Handler, with 503 Service Unavailable by default.
+// Writes from the handler after the timeout return http.ErrHandlerTimeout.
+//
+// A Timeout used in a group or for a route overrides the one applied by a parent router.
+// A zero Duration disables the timeout:
+//
+//	l := lion.New(middleware.NewTimeout(5 * time.Second))
+//	exports := l.Group("/exports", middleware.NewTimeout(10 * time.Minute))
+//
+// The write deadline of the connection is pushed back accordingly so that the WriteTimeout of the server does not cut long responses.
+type Timeout struct {
+	Duration time.Duration
+	// Handler responds when the timeout expires and nothing has been written yet
+	Handler http.Handler
+}
+
+// NewTimeout creates a Timeout middleware responding with 503 Service Unavailable after d
+func NewTimeout(d time.Duration) *Timeout {
+	return &Timeout{Duration: d}
+}
+
+// ServeNext implements the Middleware interface for Timeout.
+func (t *Timeout) ServeNext(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		// Override the timeout of a parent router
+		if st, ok := r.Context().Value(ctxTimeoutKey).(*timeoutState); ok {
+			st.reset(t.Duration, t.Handler)
+			extendWriteDeadline(w, st.deadline())
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		st := newTimeoutState(t.Duration, t.Handler)
+		defer st.stop()
+		ctx = &timeoutContext{Context: ctx, state: st}
+		ctx = context.WithValue(ctx, ctxTimeoutKey, st)
+		extendWriteDeadline(w, st.deadline())
+
+		tw := &timeoutWriter{ResponseWriter: wrapResponseWriter(w), h: make(http.Header)}
+		done := make(chan struct{})
+		panicChan := make(chan interface{}, 1)
+		// The handler can outlive the request after a timeout
+		release := lion.RetainContext(r)
+		go func() {
+			defer release()
+			defer func() {
+				if p := recover(); p != nil {
+					panicChan <- p
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicChan:
+			panic(p)
+		case <-done:
+			tw.finish()
+		case <-st.expired:
+			cancel()
+			tw.timeout(func(w http.ResponseWriter) {
+				if h := st.timeoutHandler(); h != nil {
+					h.ServeHTTP(w, r)
+					return
+				}
+				writeError(w, lion.ErrorServiceUnavailable)
+			})
+		}
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func extendWriteDeadline(w http.ResponseWriter, deadline time.Time) {
+	if deadline.IsZero() {
+		return
+	}
+	// The error is ignored since the ResponseWriter might not support it
+	http.NewResponseController(w).SetWriteDeadline(deadline.Add(timeoutWriteGrace))
+}
+
+// timeoutState is the deadline of a request and the handler called when it expires.
+// They can be changed by nested Timeout middlewares.
+type timeoutState struct {
+	mu      sync.Mutex
+	start   time.Time
+	end     time.Time
+	handler http.Handler
+	timer   *time.Timer
+	expired chan struct{}
+}
+
+func newTimeoutState(d time.Duration, handler http.Handler) *timeoutState {
+	st := &timeoutState{
+		start:   time.Now(),
+		expired: make(chan struct{}, 1),
+	}
+	st.reset(d, handler)
+	return st
+}
+
+// reset sets the deadline to d after the beginning of the request
+func (st *timeoutState) reset(d time.Duration, handler http.Handler) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.timer != nil && !st.timer.Stop() {
+		// Already expired
+		return
+	}
+	st.handler = handler
+	st.timer = nil
+	st.end = time.Time{}
+	if d <= 0 {
+		return
+	}
+
+	st.end = st.start.Add(d)
+	st.timer = time.AfterFunc(time.Until(st.end), func() {
+		select {
+		case st.expired <- struct{}{}:
+		default:
+		}
+	})
+}
+
+func (st *timeoutState) stop() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.timer != nil {
+		st.timer.Stop()
+	}
+}
+
+func (st *timeoutState) timeoutHandler() http.Handler {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.handler
+}
+
+func (st *timeoutState) deadline() time.Time {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.end
+}
+
+// timeoutContext reports the current deadline of the request
+type timeoutContext struct {
+	context.Context
+	state *timeoutState
+}
+
+func (c *timeoutContext) Deadline() (time.Time, bool) {
+	parent, ok := c.Context.Deadline()
+	deadline := c.state.deadline()
+	if deadline.IsZero() || (ok && parent.Before(deadline)) {
+		return parent, ok
+	}
+	return deadline, true
+}
+
+var _ ResponseWriter = (*timeoutWriter)(nil)
+
+// timeoutWriter prevents the handler from writing to the response after a timeout
+type timeoutWriter struct {
+	ResponseWriter
+
+	mu          sync.Mutex
+	h           http.Header
+	wroteHeader bool
+	timedOut    bool
+}
+
+// Header returns the headers of the handler. They are copied to the response when the header is written,
+// so that the handler cannot modify them while the timeout response is written.
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeader(code)
+}
+
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	dst := tw.ResponseWriter.Header()
+	for k, v := range tw.h {
+		dst[k] = v
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeader(http.StatusOK)
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeader(http.StatusOK)
+	tw.ResponseWriter.Flush()
+}
+
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return nil, nil, errTimeoutHijacked
+	}
+	// Hijacked connections are managed by the handler
+	tw.timedOut = true
+	tw.wroteHeader = true
+	return tw.ResponseWriter.Hijack()
+}
+
+// finish writes the headers set by a handler that did not write anything
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.timedOut {
+		tw.writeHeader(http.StatusOK)
+	}
+}
+
+// timeout prevents further writes and calls respond if nothing has been written yet
+func (tw *timeoutWriter) timeout(respond func(w http.ResponseWriter)) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.timedOut = true
+	if !tw.wroteHeader {
+		respond(tw.ResponseWriter)
+	}
+}
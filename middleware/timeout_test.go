@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+)
+
+func TestTimeout(t *testing.T) {
+	written := make(chan error, 1)
+	slow := func(d time.Duration) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(d):
+				io.WriteString(w, "done")
+			case <-r.Context().Done():
+				w.Header().Set("X-Late", "true")
+				_, err := io.WriteString(w, "too late")
+				written <- err
+			}
+		})
+	}
+
+	l := lion.New(NewTimeout(20 * time.Millisecond))
+	l.Get("/slow", slow(time.Second))
+	l.Get("/fast", slow(0))
+	l.Get("/deadline", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); !ok {
+			t.Errorf("Request context should have a deadline")
+		}
+		w.Header().Set("X-Header", "only")
+	}))
+
+	exports := l.Group("/exports", &Timeout{
+		Duration: 200 * time.Millisecond,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}),
+	})
+	exports.Get("/long", slow(50*time.Millisecond))
+	exports.Get("/slow", slow(time.Second))
+
+	unlimited := l.Group("/unlimited", NewTimeout(0))
+	unlimited.Get("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			t.Errorf("Request context should not have a deadline")
+		}
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(w, "done")
+	}))
+
+	test := htest.New(t, l)
+	test.Get("/slow").Do().
+		ExpectStatus(http.StatusServiceUnavailable).
+		ExpectHeader("X-Late", "").
+		ExpectBody("Service Unavailable")
+	if err := <-written; err != http.ErrHandlerTimeout {
+		t.Errorf("Writes after the timeout should fail: %v", err)
+	}
+
+	test.Get("/fast").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("done")
+	test.Get("/deadline").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("X-Header", "only")
+
+	// Groups override the timeout
+	test.Get("/exports/long").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("done")
+	test.Get("/exports/slow").Do().
+		ExpectStatus(http.StatusGatewayTimeout)
+	<-written
+	test.Get("/unlimited").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectBody("done")
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	handler := NewTimeout(time.Second).ServeNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("Panics should be propagated: %v", p)
+		}
+	}()
+	htest.New(t, handler).Get("/").Do()
+}
+
+func TestTimeoutRetainsContext(t *testing.T) {
+	var handlers sync.WaitGroup
+	var mu sync.Mutex
+	changed := 0
+
+	l := lion.New(NewTimeout(10 * time.Millisecond))
+	l.GET("/slow/:id", func(c lion.Context) {
+		defer handlers.Done()
+		id := c.Param("id")
+		time.Sleep(40 * time.Millisecond)
+		// The context must not have been reused by another request after the timeout
+		if c.Param("id") != id {
+			mu.Lock()
+			changed++
+			mu.Unlock()
+		}
+	})
+	l.GET("/fast/:id", func(c lion.Context) {
+		c.WithStatus(http.StatusOK).String("ok")
+	})
+
+	var requests sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		handlers.Add(1)
+		requests.Add(2)
+		go func() {
+			defer requests.Done()
+			l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow/a", nil))
+		}()
+		go func() {
+			defer requests.Done()
+			time.Sleep(15 * time.Millisecond)
+			l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fast/b", nil))
+		}()
+	}
+	requests.Wait()
+	handlers.Wait()
+
+	if changed > 0 {
+		t.Errorf("%d handlers saw the parameters of another request", changed)
+	}
+}
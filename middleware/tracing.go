@@ -0,0 +1,544 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celrenheit/lion"
+)
+
+type ctxSpanKeyType int
+
+const ctxSpanKey ctxSpanKeyType = 0
+
+const (
+	headerTraceParent = "traceparent"
+	headerTraceState  = "tracestate"
+)
+
+// ErrInvalidTraceParent is returned when parsing an invalid traceparent header
+var ErrInvalidTraceParent = errors.New("invalid traceparent")
+
+var (
+	// DefaultTracingQueueSize is the default number of span batches waiting to be exported
+	DefaultTracingQueueSize = 1000
+	// DefaultTracingExportTimeout is the default time given to the exporter to export a batch of spans
+	DefaultTracingExportTimeout = 10 * time.Second
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether id is not zero
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID identifies a span
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether id is not zero
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext is the part of a span propagated across process boundaries with the W3C Trace Context headers
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+	// Remote reports whether the span context has been received from another process
+	Remote bool
+}
+
+// ParseTraceParent parses a traceparent header, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceParent(header string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, ErrInvalidTraceParent
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, ErrInvalidTraceParent
+	}
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(parts[0])); err != nil {
+		return sc, ErrInvalidTraceParent
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil || strings.ToLower(parts[1]) != parts[1] {
+		return sc, ErrInvalidTraceParent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil || strings.ToLower(parts[2]) != parts[2] {
+		return sc, ErrInvalidTraceParent
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, ErrInvalidTraceParent
+	}
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return sc, ErrInvalidTraceParent
+	}
+	sc.Sampled = flags[0]&1 == 1
+	sc.Remote = true
+	return sc, nil
+}
+
+// TraceParent returns the traceparent header of sc
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// InjectTraceContext sets the traceparent and tracestate headers of the span of ctx, if any, to h
+func InjectTraceContext(ctx context.Context, h http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	h.Set(headerTraceParent, span.SpanContext.TraceParent())
+	if span.SpanContext.TraceState != "" {
+		h.Set(headerTraceState, span.SpanContext.TraceState)
+	} else {
+		h.Del(headerTraceState)
+	}
+}
+
+// SpanKind describes the relationship of a span with its parent and children
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota
+	SpanKindServer
+	SpanKindClient
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+// SpanStatus is the status of a span
+type SpanStatus int
+
+const (
+	SpanStatusUnset SpanStatus = iota
+	SpanStatusOK
+	SpanStatusError
+)
+
+func (s SpanStatus) String() string {
+	switch s {
+	case SpanStatusOK:
+		return "ok"
+	case SpanStatusError:
+		return "error"
+	}
+	return "unset"
+}
+
+// SpanEvent is an event which occurred during a span
+type SpanEvent struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]interface{}
+}
+
+// Span is a timed operation of a trace.
+// Its fields must not be modified after End.
+// The methods of a nil *Span do nothing, so that spans can be used without checking whether tracing is enabled.
+type Span struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	Parent        SpanID
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    map[string]interface{}
+	Events        []SpanEvent
+	Status        SpanStatus
+	StatusMessage string
+
+	mu    sync.Mutex
+	ended bool
+	batch *spanBatch
+}
+
+// SpanFromContext returns the current span of ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(ctxSpanKey).(*Span)
+	return span
+}
+
+// StartSpan starts a child span of the current span of ctx and returns a context containing it.
+// It returns ctx and a nil span if ctx has no span, i.e. if the Tracing middleware is not used.
+// The span must be ended with End.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	return startSpan(ctx, name, SpanKindInternal)
+}
+
+func startSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		Name: name,
+		Kind: kind,
+		SpanContext: SpanContext{
+			TraceID:    parent.SpanContext.TraceID,
+			SpanID:     newSpanID(),
+			Sampled:    parent.SpanContext.Sampled,
+			TraceState: parent.SpanContext.TraceState,
+		},
+		Parent:    parent.SpanContext.SpanID,
+		StartTime: time.Now(),
+		batch:     parent.batch,
+	}
+	return context.WithValue(ctx, ctxSpanKey, span), span
+}
+
+// SetAttribute sets an attribute of s
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]interface{})
+	}
+	s.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// AddEvent adds an event to s
+func (s *Span) AddEvent(name string, attributes map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Events = append(s.Events, SpanEvent{Name: name, Time: time.Now(), Attributes: attributes})
+	s.mu.Unlock()
+}
+
+// RecordError adds an exception event for err and sets the status of s to SpanStatusError
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.AddEvent("exception", map[string]interface{}{"exception.message": err.Error()})
+	s.SetStatus(SpanStatusError, err.Error())
+}
+
+// SetStatus sets the status of s
+func (s *Span) SetStatus(status SpanStatus, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Status = status
+	s.StatusMessage = message
+	s.mu.Unlock()
+}
+
+// End ends s and exports it if it is sampled
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+
+	if s.SpanContext.Sampled && s.batch != nil {
+		s.batch.add(s)
+	}
+}
+
+// spanBatch collects the spans of a request so that they are exported together when the server span ends
+type spanBatch struct {
+	t    *Tracing
+	root *Span
+
+	mu    sync.Mutex
+	spans []*Span
+	done  bool
+}
+
+func (b *spanBatch) add(s *Span) {
+	b.mu.Lock()
+	if b.done {
+		// Ended after the server span, e.g. in a goroutine
+		b.mu.Unlock()
+		b.t.export([]*Span{s})
+		return
+	}
+	b.spans = append(b.spans, s)
+	if s != b.root {
+		b.mu.Unlock()
+		return
+	}
+	b.done = true
+	spans := b.spans
+	b.spans = nil
+	b.mu.Unlock()
+	b.t.export(spans)
+}
+
+// Tracing is a middleware tracing requests with the W3C Trace Context propagation.
+//
+// It continues the trace of the traceparent and tracestate headers of the request, or starts a new one,
+// and starts a server span named after the route pattern.
+// Handlers can start child spans with StartSpan(r.Context(), name) and propagate the trace to other services with InjectTraceContext or TracingTransport.
+// The spans of a request are queued when the server span ends and exported by a background goroutine,
+// so that requests do not wait for the exporter. Batches are dropped if QueueSize batches are already waiting.
+// Use Flush to wait for them, e.g. on shutdown.
+type Tracing struct {
+	// Exporter exports the sampled spans
+	Exporter SpanExporter
+	// Verbose starts a child span for each of the middlewares following Tracing.
+	// It requires the router to be configured with lion.WithLayerObservation.
+	Verbose bool
+	// Sampler, if set, reports whether a new trace is sampled. The sampling decision of the caller is used otherwise.
+	Sampler func(r *http.Request) bool
+	// ErrorHandler handles export errors and dropped batches, from the goroutine exporting the spans. They are logged by default.
+	ErrorHandler func(err error)
+	// QueueSize is the number of span batches that can wait to be exported, DefaultTracingQueueSize if zero
+	QueueSize int
+	// ExportTimeout bounds the time given to the Exporter to export a batch. There is no limit if zero.
+	ExportTimeout time.Duration
+
+	start sync.Once
+	queue chan spanJob
+}
+
+// spanJob is a batch of spans to export or, if flushed is set, a request to be notified once the previous batches are exported
+type spanJob struct {
+	spans   []*Span
+	flushed chan struct{}
+}
+
+// NewTracing creates a Tracing middleware exporting spans to exporter
+func NewTracing(exporter SpanExporter) *Tracing {
+	return &Tracing{Exporter: exporter, ExportTimeout: DefaultTracingExportTimeout}
+}
+
+// ServeNext implements the Middleware interface for Tracing.
+func (t *Tracing) ServeNext(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		sc, err := ParseTraceParent(r.Header.Get(headerTraceParent))
+		parent := sc.SpanID
+		if err != nil {
+			sc = SpanContext{TraceID: newTraceID(), Sampled: t.Sampler == nil || t.Sampler(r)}
+			parent = SpanID{}
+		} else {
+			sc.TraceState = r.Header.Get(headerTraceState)
+		}
+		sc.SpanID = newSpanID()
+		sc.Remote = false
+
+		name := r.Method
+		span := &Span{
+			Kind:        SpanKindServer,
+			SpanContext: sc,
+			Parent:      parent,
+			StartTime:   time.Now(),
+		}
+		span.batch = &spanBatch{t: t, root: span}
+		if c := lion.C(r); c != nil {
+			if rt := c.Route(); rt != nil {
+				name = rt.Pattern()
+				span.SetAttribute("http.route", rt.Pattern())
+			}
+			c.WithLogAttrs("trace_id", sc.TraceID.String(), "span_id", sc.SpanID.String())
+		}
+		span.Name = name
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("server.address", r.Host)
+		span.SetAttribute("client.address", r.RemoteAddr)
+		if ua := r.UserAgent(); ua != "" {
+			span.SetAttribute("user_agent.original", ua)
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), ctxSpanKey, span))
+		if t.Verbose {
+			lion.ObserveLayers(r, func(r *http.Request, layer string) (*http.Request, func()) {
+				ctx, child := StartSpan(r.Context(), layer)
+				return r.WithContext(ctx), child.End
+			})
+		}
+
+		res := wrapResponseWriter(w)
+		defer func() {
+			if rec := recover(); rec != nil {
+				span.RecordError(fmt.Errorf("panic: %v", rec))
+				span.End()
+				panic(rec)
+			}
+			status := res.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetAttribute("http.response.status_code", status)
+			span.mu.Lock()
+			if status >= 500 && span.Status == SpanStatusUnset {
+				span.Status, span.StatusMessage = SpanStatusError, http.StatusText(status)
+			}
+			span.mu.Unlock()
+			span.End()
+		}()
+
+		next.ServeHTTP(res, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// export queues spans for the Exporter, starting the goroutine exporting them on first use.
+// The spans are dropped if the queue is full.
+func (t *Tracing) export(spans []*Span) {
+	if t.Exporter == nil {
+		return
+	}
+	t.startQueue()
+	select {
+	case t.queue <- spanJob{spans: spans}:
+	default:
+		t.error(fmt.Errorf("%d span batches are already waiting, dropping %d spans", cap(t.queue), len(spans)))
+	}
+}
+
+func (t *Tracing) startQueue() {
+	t.start.Do(func() {
+		size := t.QueueSize
+		if size <= 0 {
+			size = DefaultTracingQueueSize
+		}
+		t.queue = make(chan spanJob, size)
+		go t.send(t.queue)
+	})
+}
+
+// send exports the batches of queue
+func (t *Tracing) send(queue chan spanJob) {
+	for job := range queue {
+		if job.flushed != nil {
+			close(job.flushed)
+			continue
+		}
+
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if t.ExportTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, t.ExportTimeout)
+		}
+		if err := t.Exporter.ExportSpans(ctx, job.spans); err != nil {
+			t.error(err)
+		}
+		cancel()
+	}
+}
+
+func (t *Tracing) error(err error) {
+	if t.ErrorHandler != nil {
+		t.ErrorHandler(err)
+		return
+	}
+	lionLogger.Printf("tracing: %v", err)
+}
+
+// Flush waits until the spans queued so far have been exported or ctx is done
+func (t *Tracing) Flush(ctx context.Context) error {
+	t.startQueue()
+	flushed := make(chan struct{})
+	select {
+	case t.queue <- spanJob{flushed: flushed}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TracingTransport is an http.RoundTripper starting a client span for each request
+// and propagating the trace of the request's context with the traceparent and tracestate headers.
+type TracingTransport struct {
+	// Base is the underlying RoundTripper, http.DefaultTransport by default
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *TracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx, span := startSpan(req.Context(), "HTTP "+req.Method, SpanKindClient)
+	if span == nil {
+		return base.RoundTrip(req)
+	}
+	defer span.End()
+
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("url.full", req.URL.String())
+	req = req.Clone(ctx)
+	InjectTraceContext(ctx, req.Header)
+
+	res, err := base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.response.status_code", res.StatusCode)
+	if res.StatusCode >= 400 {
+		span.SetStatus(SpanStatusError, http.StatusText(res.StatusCode))
+	}
+	return res, nil
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
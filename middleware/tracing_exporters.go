@@ -0,0 +1,307 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultOTLPEndpoint is the default endpoint of OTLP collectors receiving traces over HTTP
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// SpanExporter exports ended spans
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, spans []*Span) error
+}
+
+// SpanExporterFunc is an adapter to use a function as a SpanExporter
+type SpanExporterFunc func(ctx context.Context, spans []*Span) error
+
+// ExportSpans calls fn(ctx, spans)
+func (fn SpanExporterFunc) ExportSpans(ctx context.Context, spans []*Span) error {
+	return fn(ctx, spans)
+}
+
+// JSONSpanExporter writes spans as JSON objects, one per line
+type JSONSpanExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONSpanExporter creates a JSONSpanExporter writing to w
+func NewJSONSpanExporter(w io.Writer) *JSONSpanExporter {
+	return &JSONSpanExporter{w: w}
+}
+
+// NewStdoutSpanExporter creates a JSONSpanExporter writing to os.Stdout
+func NewStdoutSpanExporter() *JSONSpanExporter {
+	return NewJSONSpanExporter(os.Stdout)
+}
+
+// NewJSONFileSpanExporter creates a JSONSpanExporter appending to the file at path.
+// The file should be closed with Close.
+func NewJSONFileSpanExporter(path string) (*JSONSpanExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONSpanExporter(f), nil
+}
+
+// ExportSpans implements SpanExporter
+func (e *JSONSpanExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for _, s := range spans {
+		if err := enc.Encode(jsonSpan(s)); err != nil {
+			return err
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer other than os.Stdout
+func (e *JSONSpanExporter) Close() error {
+	if c, ok := e.w.(io.Closer); ok && e.w != os.Stdout {
+		return c.Close()
+	}
+	return nil
+}
+
+type jsonSpanEvent struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+type jsonSpanRecord struct {
+	TraceID       string                 `json:"trace_id"`
+	SpanID        string                 `json:"span_id"`
+	ParentSpanID  string                 `json:"parent_span_id,omitempty"`
+	Name          string                 `json:"name"`
+	Kind          string                 `json:"kind"`
+	Start         time.Time              `json:"start"`
+	End           time.Time              `json:"end"`
+	Duration      string                 `json:"duration"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Events        []jsonSpanEvent        `json:"events,omitempty"`
+	Status        string                 `json:"status"`
+	StatusMessage string                 `json:"status_message,omitempty"`
+}
+
+func jsonSpan(s *Span) jsonSpanRecord {
+	rec := jsonSpanRecord{
+		TraceID:       s.SpanContext.TraceID.String(),
+		SpanID:        s.SpanContext.SpanID.String(),
+		Name:          s.Name,
+		Kind:          s.Kind.String(),
+		Start:         s.StartTime,
+		End:           s.EndTime,
+		Duration:      s.EndTime.Sub(s.StartTime).String(),
+		Attributes:    s.Attributes,
+		Status:        s.Status.String(),
+		StatusMessage: s.StatusMessage,
+	}
+	if s.Parent.IsValid() {
+		rec.ParentSpanID = s.Parent.String()
+	}
+	for _, e := range s.Events {
+		rec.Events = append(rec.Events, jsonSpanEvent{Name: e.Name, Time: e.Time, Attributes: e.Attributes})
+	}
+	return rec
+}
+
+// OTLPExporter exports spans to an OpenTelemetry collector with the OTLP/HTTP protocol, using its JSON encoding
+type OTLPExporter struct {
+	// Endpoint is the URL receiving the traces, DefaultOTLPEndpoint by default
+	Endpoint string
+	// ServiceName is the service.name attribute of the resource
+	ServiceName string
+	// Headers are added to the export requests, e.g. for authentication
+	Headers map[string]string
+	// Client sends the export requests. It defaults to an http.Client with a 10 seconds timeout.
+	Client *http.Client
+}
+
+// NewOTLPExporter creates an OTLPExporter sending the spans of serviceName to endpoint
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	return &OTLPExporter{Endpoint: endpoint, ServiceName: serviceName}
+}
+
+// ExportSpans implements SpanExporter
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	endpoint := e.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultOTLPEndpoint
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("otlp: collector responded with %s", res.Status)
+	}
+	return nil
+}
+
+// The types below follow the JSON encoding of the OTLP trace service request
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func (e *OTLPExporter) request(spans []*Span) otlpRequest {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "github.com/celrenheit/lion"}}
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.SpanContext.TraceID.String(),
+			SpanID:            s.SpanContext.SpanID.String(),
+			TraceState:        s.SpanContext.TraceState,
+			Name:              s.Name,
+			Kind:              otlpSpanKind(s.Kind),
+			StartTimeUnixNano: unixNano(s.StartTime),
+			EndTimeUnixNano:   unixNano(s.EndTime),
+			Attributes:        otlpAttributes(s.Attributes),
+			// OTLP status codes: 0 unset, 1 ok, 2 error
+			Status: otlpStatus{Code: int(s.Status), Message: s.StatusMessage},
+		}
+		if s.Parent.IsValid() {
+			span.ParentSpanID = s.Parent.String()
+		}
+		for _, ev := range s.Events {
+			span.Events = append(span.Events, otlpEvent{TimeUnixNano: unixNano(ev.Time), Name: ev.Name, Attributes: otlpAttributes(ev.Attributes)})
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	resource := otlpResource{Attributes: []otlpKeyValue{}}
+	if e.ServiceName != "" {
+		resource.Attributes = otlpAttributes(map[string]interface{}{"service.name": e.ServiceName})
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{Resource: resource, ScopeSpans: []otlpScopeSpans{scope}}}}
+}
+
+func otlpSpanKind(k SpanKind) int {
+	switch k {
+	case SpanKindServer:
+		return 2
+	case SpanKindClient:
+		return 3
+	}
+	return 1
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		// 64-bit integers are encoded as strings
+		var value map[string]interface{}
+		switch v := attrs[k].(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: value})
+	}
+	return kvs
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,281 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celrenheit/htest"
+	"github.com/celrenheit/lion"
+)
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (rec *spanRecorder) ExportSpans(ctx context.Context, spans []*Span) error {
+	rec.mu.Lock()
+	rec.spans = append(rec.spans, spans...)
+	rec.mu.Unlock()
+	return nil
+}
+
+func (rec *spanRecorder) byName(t *testing.T, name string) *Span {
+	for _, s := range rec.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("No span named %s", name)
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	rec := &spanRecorder{}
+	tracing := NewTracing(rec)
+	tracing.Verbose = true
+
+	l := lion.New(tracing, NewRequestID())
+	l.Configure(lion.WithLayerObservation())
+	l.GetFunc("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		_, span := StartSpan(r.Context(), "db.query")
+		span.SetAttribute("db.statement", "SELECT 1")
+		span.RecordError(errors.New("no rows"))
+		span.End()
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	test := htest.New(t, l)
+
+	test.Get("/users/42").
+		SetHeader("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01").
+		SetHeader("tracestate", "vendor=value").
+		Do()
+	if err := tracing.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rec.spans) != 3 {
+		t.Fatalf("Expected 3 spans but got %d", len(rec.spans))
+	}
+	server := rec.byName(t, "/users/:id")
+	if server.Kind != SpanKindServer || server.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		server.Parent.String() != "00f067aa0ba902b7" || server.SpanContext.TraceState != "vendor=value" {
+		t.Errorf("The server span should continue the trace of the request: %+v", server)
+	}
+	if server.Status != SpanStatusError || server.Attributes["http.response.status_code"] != 500 || server.Attributes["http.route"] != "/users/:id" {
+		t.Errorf("Incorrect server span: %+v", server)
+	}
+
+	layer := rec.byName(t, "*middleware.RequestID")
+	if layer.Parent != server.SpanContext.SpanID {
+		t.Errorf("Middleware spans should be children of the server span")
+	}
+	query := rec.byName(t, "db.query")
+	if query.Parent != layer.SpanContext.SpanID || query.Status != SpanStatusError || query.Events[0].Name != "exception" {
+		t.Errorf("Incorrect handler span: %+v", query)
+	}
+
+	// Unsampled traces are not exported
+	rec.spans = nil
+	test.Get("/users/42").
+		SetHeader("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00").
+		Do()
+	if len(rec.spans) != 0 {
+		t.Errorf("Unsampled traces should not be exported")
+	}
+
+	// New traces are started for invalid headers
+	test.Get("/users/42").SetHeader("traceparent", "00-00000000000000000000000000000000-00f067aa0ba902b7-01").Do()
+	if err := tracing.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if server := rec.byName(t, "/users/:id"); server.Parent.IsValid() || server.SpanContext.TraceID.String() == "00000000000000000000000000000000" {
+		t.Errorf("A new trace should be started: %+v", server)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	sc, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil || !sc.Sampled || !sc.Remote {
+		t.Fatalf("Incorrect span context %+v %v", sc, err)
+	}
+	if h := sc.TraceParent(); h != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("Incorrect traceparent %s", h)
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+	for _, h := range invalid {
+		if _, err := ParseTraceParent(h); err != ErrInvalidTraceParent {
+			t.Errorf("Expected %q to be invalid", h)
+		}
+	}
+	// Future versions may have more fields
+	if _, err := ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Errorf("Future versions should be accepted: %v", err)
+	}
+}
+
+func TestTracingTransport(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("traceparent")))
+	}))
+	defer upstream.Close()
+
+	rec := &spanRecorder{}
+	client := &http.Client{Transport: &TracingTransport{}}
+	var traceparent string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequest("GET", upstream.URL, nil)
+		res, err := client.Do(req.WithContext(r.Context()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		traceparent = string(b)
+	})
+	tracing := NewTracing(rec)
+	htest.New(t, tracing.ServeNext(handler)).Get("/").Do()
+	if err := tracing.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	clientSpan := rec.byName(t, "HTTP GET")
+	if clientSpan.Kind != SpanKindClient || traceparent != clientSpan.SpanContext.TraceParent() {
+		t.Errorf("The client span should be propagated: %s, %+v", traceparent, clientSpan)
+	}
+}
+
+func TestJSONFileSpanExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	exporter, err := NewJSONFileSpanExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracing := NewTracing(exporter)
+	htest.New(t, tracing.ServeNext(fakeHandler())).Get("/").Do()
+	if err := tracing.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	exporter.Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var span map[string]interface{}
+	if err := json.Unmarshal(b, &span); err != nil {
+		t.Fatalf("Expected a JSON span: %v %s", err, b)
+	}
+	if span["name"] != "GET" || span["kind"] != "server" || len(span["trace_id"].(string)) != 32 {
+		t.Errorf("Incorrect span: %s", b)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]interface{}
+	var contentType, auth string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		contentType, auth = r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL+"/v1/traces", "api")
+	exporter.Headers = map[string]string{"Authorization": "Bearer token"}
+	var exportErr error
+	tracing := NewTracing(exporter)
+	tracing.ErrorHandler = func(err error) { exportErr = err }
+	htest.New(t, tracing.ServeNext(fakeHandler())).Get("/").
+		SetHeader("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01").
+		Do()
+	if err := tracing.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if exportErr != nil {
+		t.Fatal(exportErr)
+	}
+	if contentType != "application/json" || auth != "Bearer token" {
+		t.Errorf("Incorrect export request headers: %s %s", contentType, auth)
+	}
+	resourceSpans := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	service := resourceSpans["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if service["key"] != "service.name" || service["value"].(map[string]interface{})["stringValue"] != "api" {
+		t.Errorf("Incorrect resource: %v", service)
+	}
+	span := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	if span["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || span["parentSpanId"] != "00f067aa0ba902b7" || span["kind"] != float64(2) {
+		t.Errorf("Incorrect span: %v", span)
+	}
+
+	exporter.Endpoint = collector.URL + "/unknown"
+	htest.New(t, tracing.ServeNext(fakeHandler())).Get("/").Do()
+	if err := tracing.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if exportErr == nil || !strings.Contains(exportErr.Error(), "404") {
+		t.Errorf("Export errors should be reported: %v", exportErr)
+	}
+}
+
+func TestTracingSlowExporter(t *testing.T) {
+	unblock := make(chan struct{})
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer collector.Close()
+	defer close(unblock)
+
+	errs := make(chan error, 10)
+	tracing := NewTracing(NewOTLPExporter(collector.URL, "api"))
+	tracing.ExportTimeout = 20 * time.Millisecond
+	tracing.ErrorHandler = func(err error) { errs <- err }
+	test := htest.New(t, tracing.ServeNext(fakeHandler()))
+
+	// Requests do not wait for the collector
+	done := make(chan struct{})
+	go func() {
+		test.Get("/").Do().ExpectStatus(http.StatusOK)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Requests should not wait for the exporter")
+	}
+
+	// The export is abandoned after ExportTimeout
+	if err := tracing.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected a timeout but got %v", err)
+		}
+	default:
+		t.Errorf("The export should time out")
+	}
+}
@@ -22,6 +22,7 @@ func (r *Router) Module(modules ...Module) {
 
 func (r *Router) registerModule(m Module) {
 	g := r.Group(m.Base())
+	g.addOrigin("module", m)
 	if req, ok := m.(moduleRequirements); ok {
 		for _, dep := range req.Requires() {
 			if !r.hasNamed(dep) {
@@ -31,7 +32,7 @@ func (r *Router) registerModule(m Module) {
 		}
 	}
 
-	g.Resource("/", m)
+	g.Group("/").registerResource(m)
 
 	m.Routes(g)
 }
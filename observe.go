@@ -0,0 +1,55 @@
+package lion
+
+import "net/http"
+
+// WithLayerObservation makes the middlewares of the routes registered afterwards observable with ObserveLayers.
+// Layers are not observable by default, as wrapping each middleware has a cost on every request.
+// WithInstrumentation also enables it.
+func WithLayerObservation() RouterOption {
+	return func(router *Router) {
+		router.observeLayers = true
+	}
+}
+
+// LayerObserver observes the layers of the chain of a route, i.e. each of its middlewares in order
+// and, if the instrumentation is enabled with WithInstrumentation, its handler.
+// The middlewares are only observable if WithLayerObservation or WithInstrumentation is enabled.
+// It is called before a layer serves r with the name of the layer, as shown by Dump, or "handler".
+// It returns the request passed to the layer and a function called when the layer returns.
+type LayerObserver func(r *http.Request, layer string) (*http.Request, func())
+
+// ObserveLayers registers obs for the layers following the current one in the chain of the route matched for r.
+// It is meant to be called by middlewares, such as tracing or timing middlewares, and has no effect outside of a Router.
+func ObserveLayers(r *http.Request, obs LayerObserver) {
+	if c, ok := C(r).(*ctx); ok {
+		c.observers = append(c.observers, obs)
+	}
+}
+
+// observeLayer wraps the handler of a layer so that it is reported to the observers of the request
+func observeLayer(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := C(r).(*ctx)
+		if !ok || len(c.observers) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// Observers registered by this layer do not observe it
+		observers := c.observers
+		dones := make([]func(), 0, len(observers))
+		for _, obs := range observers {
+			var done func()
+			r, done = obs(r, name)
+			dones = append(dones, done)
+		}
+		defer func() {
+			for i := len(dones) - 1; i >= 0; i-- {
+				if dones[i] != nil {
+					dones[i]()
+				}
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
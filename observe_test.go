@@ -0,0 +1,64 @@
+package lion
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type observedMiddleware struct{}
+
+func (observedMiddleware) ServeNext(next http.Handler) http.Handler { return next }
+
+func TestObserveLayers(t *testing.T) {
+	var events []string
+	l := New(MiddlewareFunc(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ObserveLayers(r, func(r *http.Request, layer string) (*http.Request, func()) {
+				events = append(events, "start "+layer)
+				return r, func() { events = append(events, "end "+layer) }
+			})
+			next.ServeHTTP(w, r)
+		})
+	}))
+	l.Configure(WithLayerObservation())
+	l.Define("auth", observedMiddleware{})
+	g := l.Group("/admin", Middlewares{observedMiddleware{}})
+	g.UseNamed("auth")
+	g.GetFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		events = append(events, "handler")
+	})
+
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/admin", nil))
+
+	expected := []string{
+		"start lion.observedMiddleware",
+		"start auth:lion.observedMiddleware",
+		"handler",
+		"end auth:lion.observedMiddleware",
+		"end lion.observedMiddleware",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected %v but got %v", expected, events)
+	}
+}
+
+func TestObserveLayersDisabled(t *testing.T) {
+	var events []string
+	l := New(MiddlewareFunc(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ObserveLayers(r, func(r *http.Request, layer string) (*http.Request, func()) {
+				events = append(events, "start "+layer)
+				return r, nil
+			})
+			next.ServeHTTP(w, r)
+		})
+	}), observedMiddleware{})
+	l.GetFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if len(events) != 0 {
+		t.Errorf("Layers should not be observed without WithLayerObservation: %v", events)
+	}
+}
@@ -17,22 +17,32 @@ type resourceUses interface {
 // Resource registers a Resource with the corresponding pattern
 func (r *Router) Resource(pattern string, resource Resource) {
 	sub := r.Group(pattern)
+	sub.addOrigin("resource", resource)
+	sub.registerResource(resource)
+}
 
+func (r *Router) registerResource(resource Resource) {
 	if usesRes, ok := resource.(resourceUses); ok {
 		if len(usesRes.Uses()) > 0 {
-			sub.Use(usesRes.Uses()...)
+			r.Use(usesRes.Uses()...)
 		}
 	}
 
+	var rt Route
 	for _, m := range allowedHTTPMethods {
 		if hfn, ok := isHandlerFuncInResource(m, resource); ok {
-			s := sub.Subrouter()
+			s := r.Subrouter()
 			if mws, ok := isMiddlewareInResource(m, resource); ok {
 				s.Use(mws()...)
 			}
-			s.HandleFunc(m, "/", http.HandlerFunc(hfn))
+			rt = s.HandleFunc(m, "/", http.HandlerFunc(hfn))
 		}
 	}
+
+	// Method specific middlewares should not apply to automatic OPTIONS requests
+	if rt, ok := rt.(*route); ok {
+		r.setAutomaticOptions(rt)
+	}
 }
 
 // checks if there is a Name(w http.ResponseWriter, r *http.Request) method available on the Resource r
@@ -99,6 +99,13 @@ type route struct {
 
 	pathMatcher registerMatcher
 
+	// infos keeps the middleware chain and origin of each method's handler
+	infos map[string]routeMethodInfo
+
+	// automaticOptions answers OPTIONS requests when no OPTIONS handler has been registered.
+	// It goes through the middlewares of the router that registered the route.
+	automaticOptions http.Handler
+
 	get     http.Handler
 	head    http.Handler
 	post    http.Handler
@@ -131,6 +138,35 @@ func (r *route) withMethods(handler http.Handler, methods ...string) Route {
 	return r
 }
 
+func (r *route) setInfo(method string, middlewares []string, origin string) {
+	if r.infos == nil {
+		r.infos = make(map[string]routeMethodInfo)
+	}
+	r.infos[method] = routeMethodInfo{
+		middlewares:   middlewares,
+		origin:        origin,
+		registrations: r.infos[method].registrations + 1,
+	}
+}
+
+func (r *route) info(method string) (middlewares []string, origin string) {
+	i := r.infos[method]
+	return i.middlewares, i.origin
+}
+
+func (r *route) copyInfo(from string, methods ...string) {
+	mws, origin := r.info(from)
+	for _, method := range methods {
+		r.setInfo(method, mws, origin)
+	}
+}
+
+type routeMethodInfo struct {
+	middlewares   []string
+	origin        string
+	registrations int
+}
+
 func (r *route) Methods() (methods []string) {
 	for _, m := range allowedHTTPMethods {
 		if r.getHandler(m) != nil {
@@ -11,6 +11,8 @@ func TestRouteGeneratePath(t *testing.T) {
 		{"/a/:name/:n([0-9]+)", "a_name_n"},
 		{"/a/b/:dest/*path", "a_b_dest_path"},
 		{"/e/:file.:ext", "e_file_ext"},
+		{"/repos/:owner/*path/blob", "repos_owner_path_blob"},
+		{"/static/*file.map", "static_file_map"},
 	}
 	for _, r := range register {
 		l.Get(r.pattern, fakeHandler()).WithName(r.name)
@@ -27,6 +29,9 @@ func TestRouteGeneratePath(t *testing.T) {
 		{routename: "a_name_n", params: mss{"name": "batman", "n": "1d23"}, expectedErr: true},
 		{routename: "a_b_dest_path", params: mss{"dest": "batman", "path": "subfolder/test/hello.jpeg"}, expectedPath: "/a/b/batman/subfolder/test/hello.jpeg"},
 		{routename: "e_file_ext", params: mss{"file": "test", "ext": "mp4"}, expectedPath: "/e/test.mp4"},
+		{routename: "repos_owner_path_blob", params: mss{"owner": "lion", "path": "src/matcher"}, expectedPath: "/repos/lion/src/matcher/blob"},
+		{routename: "repos_owner_path_blob", params: mss{"owner": "lion"}, expectedErr: true},
+		{routename: "static_file_map", params: mss{"file": "js/app.js"}, expectedPath: "/static/js/app.js.map"},
 	}
 
 	for _, test := range tests {
@@ -2,11 +2,13 @@ package lion
 
 import (
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,7 +31,9 @@ var allowedHTTPMethods = [...]string{GET, HEAD, POST, PUT, DELETE, TRACE, OPTION
 type Router struct {
 	pattern          string
 	middlewares      Middlewares
+	middlewareLabels []string // name given through Define for each middleware, empty otherwise
 	namedMiddlewares map[string]Middlewares
+	origin           string // module or resource that created this router
 
 	parent     *Router
 	subrouters []*Router
@@ -42,6 +46,10 @@ type Router struct {
 
 	// Configuration
 	logger          *log.Logger
+	requestLogger   *slog.Logger
+	instrumentation *layerStatsRecorder
+	serverTiming    bool
+	observeLayers   bool
 	server          *http.Server
 	notFoundHandler http.Handler
 }
@@ -78,6 +86,7 @@ func (r *Router) Subrouter(mws ...Middleware) *Router {
 		middlewares:      Middlewares{},
 		namedMiddlewares: make(map[string]Middlewares),
 		host:             r.host,
+		origin:           r.origin,
 		pool:             newCtxPool(),
 		routes:           []*route{},
 		subrouters:       []*Router{},
@@ -102,6 +111,20 @@ func (r *Router) Group(pattern string, mws ...Middleware) *Router {
 
 // Handle is the underling method responsible for registering a handler for a specific method and pattern.
 func (r *Router) Handle(method, pattern string, handler http.Handler) Route {
+	return r.handle(method, pattern, r.observeHandler(handler), nil, "")
+}
+
+// observeHandler wraps handler so that it can be observed if the instrumentation is enabled
+func (r *Router) observeHandler(handler http.Handler) http.Handler {
+	if r.root().instrumentation != nil {
+		return observeLayer("handler", handler)
+	}
+	return handler
+}
+
+// handle registers handler and records the middleware chain applied to it.
+// inner and origin describe a handler that was already built elsewhere (see Mount).
+func (r *Router) handle(method, pattern string, handler http.Handler, inner []string, origin string) Route {
 	var p string
 	if pattern == "/" && r.pattern != "" {
 		p = r.pattern
@@ -121,28 +144,57 @@ func (r *Router) Handle(method, pattern string, handler http.Handler) Route {
 		rt.pathMatcher = rm
 		r.routes = append(r.routes, rt)
 	}
+	if rt.automaticOptions == nil {
+		r.setAutomaticOptions(rt)
+	}
+
+	if origin == "" {
+		origin = r.origin
+	}
+	rt.setInfo(method, append(r.chain(), inner...), origin)
 	return rt
 }
 
+// setAutomaticOptions builds the automatic OPTIONS handler of rt with this router's middlewares.
+// This allows middlewares such as CORS to answer preflight requests.
+func (r *Router) setAutomaticOptions(rt *route) {
+	rt.automaticOptions = r.buildMiddlewares(automaticOptionsHandler(rt))
+}
+
 // ServeHTTP finds the handler associated with the request's path.
 // If it is not found it calls the NotFound handler
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	ctx := r.pool.Get().(*ctx)
 	ctx.Reset()
+	ctx.pool = &r.pool
+	atomic.StoreInt32(&ctx.refs, 1)
 	ctx.parent = req.Context()
 	ctx.ResponseWriter = w
 	ctx.req = req
+	ctx.baseLogger = r.root().requestLogger
 
 	if h := r.root().hostrm.Match(ctx, req); h != nil {
 		// We set the context only if there is a match
 		req = setParamContext(req, ctx)
 
-		h.ServeHTTP(w, req)
+		if rec := r.root().instrumentation; rec != nil && ctx.route != nil {
+			timer := &layerTimer{}
+			ctx.observers = append(ctx.observers, timer.observe)
+			if r.root().serverTiming {
+				w = &serverTimingWriter{ResponseWriter: w, timer: timer}
+				ctx.ResponseWriter = w
+			}
+			h.ServeHTTP(w, req)
+			rec.record(req.Method, ctx.route.Pattern(), timer)
+		} else {
+			h.ServeHTTP(w, req)
+		}
 	} else {
 		r.notFound(w, req) // r.middlewares.BuildHandler(HandlerFunc(r.NotFound)).ServeHTTPC
 	}
 
-	r.pool.Put(ctx)
+	// The context might still be retained by the goroutine of a middleware
+	ctx.release()
 }
 
 // Mount mounts a subrouter at the provided pattern
@@ -161,7 +213,8 @@ func (r *Router) Mount(pattern string, sub *Router, mws ...Middleware) {
 	for _, route := range sub.routes {
 		r.Host(route.Host())
 		for _, method := range route.Methods() {
-			r.Handle(method, route.Pattern(), route.Handler(method))
+			mws, origin := route.info(method)
+			r.handle(method, route.Pattern(), route.Handler(method), mws, origin)
 		}
 	}
 	// Restore previous host and pattern
@@ -200,7 +253,8 @@ func (r *Router) Host(hostpattern string) *Router {
 // Any registers the provided Handler for all of the allowed http methods: GET, HEAD, POST, PUT, DELETE, TRACE, OPTIONS, CONNECT, PATCH
 func (r *Router) Any(pattern string, handler http.Handler) Route {
 	rt := r.Handle(allowedHTTPMethods[0], pattern, handler).(*route)
-	rt.withMethods(r.middlewares.BuildHandler(handler), allowedHTTPMethods[1:]...)
+	rt.withMethods(r.buildMiddlewares(r.observeHandler(handler)), allowedHTTPMethods[1:]...)
+	rt.copyInfo(allowedHTTPMethods[0], allowedHTTPMethods[1:]...)
 	return rt
 }
 
@@ -252,7 +306,8 @@ func (r *Router) Patch(pattern string, handler http.Handler) Route {
 // ANY registers the provided contextual Handler for all of the allowed http methods: GET, HEAD, POST, PUT, DELETE, TRACE, OPTIONS, CONNECT, PATCH
 func (r *Router) ANY(pattern string, handler func(Context)) Route {
 	rt := r.Handle(allowedHTTPMethods[0], pattern, wrap(handler)).(*route)
-	rt.withMethods(r.middlewares.BuildHandler(wrap(handler)), allowedHTTPMethods[1:]...)
+	rt.withMethods(r.buildMiddlewares(r.observeHandler(wrap(handler))), allowedHTTPMethods[1:]...)
+	rt.copyInfo(allowedHTTPMethods[0], allowedHTTPMethods[1:]...)
 	return rt
 }
 
@@ -353,7 +408,14 @@ func (r *Router) PatchFunc(pattern string, fn http.HandlerFunc) Route {
 
 // Use registers middlewares to be used
 func (r *Router) Use(middlewares ...Middleware) {
+	r.use("", middlewares...)
+}
+
+func (r *Router) use(label string, middlewares ...Middleware) {
 	r.middlewares = append(r.middlewares, middlewares...)
+	for range middlewares {
+		r.middlewareLabels = append(r.middlewareLabels, label)
+	}
 }
 
 // UseFunc wraps a MiddlewareFunc as a Middleware and registers it middlewares to be used
@@ -414,8 +476,28 @@ func (r *Router) findRoute(rt *route) (*route, bool) {
 	return nil, false
 }
 
+// buildMiddlewares wraps handler with the middlewares of r and of its parents.
+// If layer observation or instrumentation is enabled, each middleware is wrapped so that it can be observed, see ObserveLayers.
 func (r *Router) buildMiddlewares(handler http.Handler) http.Handler {
-	handler = r.middlewares.BuildHandler(handler)
+	observed := r.root().observeLayers || r.root().instrumentation != nil
+	var mws Middlewares
+	var names []string
+	for i, mw := range r.middlewares {
+		for _, m := range flattenMiddleware(mw) {
+			name := describeMiddleware(m)[0]
+			if label := r.middlewareLabels[i]; label != "" {
+				name = label + ":" + name
+			}
+			mws = append(mws, m)
+			names = append(names, name)
+		}
+	}
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i].ServeNext(handler)
+		if observed {
+			handler = observeLayer(names[i], handler)
+		}
+	}
 	if !r.isRoot() {
 		handler = r.parent.buildMiddlewares(handler)
 	}
@@ -529,13 +611,20 @@ func (r *Router) DefineFunc(name string, mws ...MiddlewareFunc) {
 // UseNamed adds a middleware already defined using Define method.
 // If it cannot find it in the current router, it will look for it in the parent router.
 func (r *Router) UseNamed(name string) {
+	mws, ok := r.lookupNamed(name)
+	if !ok {
+		panic("Unknow named middlewares: " + name)
+	}
+	r.use(name, mws...)
+}
+
+func (r *Router) lookupNamed(name string) (Middlewares, bool) {
 	if r.hasNamed(name) { // Find if it this is registered in the current router
-		r.Use(r.namedMiddlewares[name]...)
+		return r.namedMiddlewares[name], true
 	} else if !r.isRoot() { // Otherwise, look for it in parent router.
-		r.parent.UseNamed(name)
-	} else { // not found
-		panic("Unknow named middlewares: " + name)
+		return r.parent.lookupNamed(name)
 	}
+	return nil, false
 }
 
 func (r *Router) hasNamed(name string) bool {
@@ -583,6 +672,14 @@ func WithLogger(logger *log.Logger) RouterOption {
 	}
 }
 
+// WithRequestLogger sets the logger from which the logger of each request, returned by Context.Logger, is created.
+// It defaults to slog.Default().
+func WithRequestLogger(logger *slog.Logger) RouterOption {
+	return func(router *Router) {
+		router.requestLogger = logger
+	}
+}
+
 // WithServer allows to customize the underlying http.Server
 // Note: when using Run() the handler and the address will change
 func WithServer(server *http.Server) RouterOption {
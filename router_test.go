@@ -439,6 +439,147 @@ func TestConflictingParamNames(t *testing.T) {
 	}
 }
 
+func TestMultipleParamNodes(t *testing.T) {
+	usernameH := fakeHandler()
+	idH := fakeHandler()
+	idPostsH := fakeHandler()
+	usernameProfileH := fakeHandler()
+	slugH := fakeHandler()
+	uuidH := fakeHandler()
+	codeH := fakeHandler()
+
+	mux := New()
+	// Unconstrained params are registered first to check that constrained ones are tried before
+	mux.Get("/users/:username", usernameH)
+	mux.Get("/users/:username/profile", usernameProfileH)
+	mux.Get("/users/:id([0-9]+)", idH)
+	mux.Get("/users/:id([0-9]+)/posts", idPostsH)
+	mux.Get("/files/:slug", slugH)
+	mux.Get("/files/:uuid([0-9a-f]{8}-[0-9a-f]{4})", uuidH)
+	mux.Get("/files/:code([A-Z]{3})", codeH)
+
+	tests := []struct {
+		input           string
+		expectedHandler http.Handler
+		expectedParams  mss
+	}{
+		{input: "/users/123", expectedHandler: idH, expectedParams: mss{"id": "123"}},
+		{input: "/users/batman", expectedHandler: usernameH, expectedParams: mss{"username": "batman"}},
+		{input: "/users/batman123", expectedHandler: usernameH, expectedParams: mss{"username": "batman123"}},
+		{input: "/users/123/posts", expectedHandler: idPostsH, expectedParams: mss{"id": "123"}},
+		{input: "/users/batman/profile", expectedHandler: usernameProfileH, expectedParams: mss{"username": "batman"}},
+		// Backtracking from the constrained param to the unconstrained one
+		{input: "/users/123/profile", expectedHandler: usernameProfileH, expectedParams: mss{"username": "123"}},
+		{input: "/users/batman/posts", expectedHandler: nil, expectedParams: mss{}},
+		{input: "/files/0123abcd-ef01", expectedHandler: uuidH, expectedParams: mss{"uuid": "0123abcd-ef01"}},
+		{input: "/files/ABC", expectedHandler: codeH, expectedParams: mss{"code": "ABC"}},
+		{input: "/files/ABCD", expectedHandler: slugH, expectedParams: mss{"slug": "ABCD"}},
+		{input: "/files/my-file", expectedHandler: slugH, expectedParams: mss{"slug": "my-file"}},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.input, nil)
+		c := newContext()
+		h := mux.hostrm.Match(c, req)
+		req = setParamContext(req, c)
+
+		if len(test.expectedParams) != len(c.params) {
+			t.Errorf("Length missmatch: expected %d but got %d (%v) for path %s", len(test.expectedParams), len(c.params), c.toMap(), test.input)
+		}
+
+		for k, v := range test.expectedParams {
+			actual := Param(req, k)
+			if actual != v {
+				t.Errorf("Expected key %s to equal %s but got %s for url: %s", cyan(k), green(v), red(actual), test.input)
+			}
+		}
+
+		if fmt.Sprintf("%v", h) != fmt.Sprintf("%v", test.expectedHandler) {
+			t.Errorf("Handler not match for %s", test.input)
+		}
+	}
+
+	if ds := mux.Validate(); len(ds) > 0 {
+		t.Errorf("Should not report any problem but got:\n%s", ds)
+	}
+
+	path, err := mux.Get("/users/:id([0-9]+)", idH).Path(mss{"id": "42"})
+	if err != nil || path != "/users/42" {
+		t.Errorf("Incorrect path: got '%s' (%v) want '/users/42'", path, err)
+	}
+
+	recv := catchPanic(func() {
+		mux.Get("/users/:number([0-9]+)/comments", fakeHandler())
+	})
+	if recv == nil {
+		t.Error("Should have detected a conflicting parameter name for the same regex")
+	}
+}
+
+func TestWildcardSuffix(t *testing.T) {
+	blobH := fakeHandler()
+	treeH := fakeHandler()
+	repoH := fakeHandler()
+	mapH := fakeHandler()
+	staticH := fakeHandler()
+	rawH := fakeHandler()
+
+	mux := New()
+	mux.Get("/repos/:owner/*path/blob", blobH)
+	mux.Get("/repos/:owner/*path/tree", treeH)
+	mux.Get("/repos/:owner/*path", repoH)
+	mux.Get("/static/*file.map", mapH)
+	mux.Get("/static/*file", staticH)
+	mux.Get("/raw/*/content", rawH)
+	mux.Get("/git/*path/blob", blobH)
+
+	tests := []struct {
+		input           string
+		expectedHandler http.Handler
+		expectedParams  mss
+	}{
+		{input: "/repos/lion/src/matcher/blob", expectedHandler: blobH, expectedParams: mss{"owner": "lion", "path": "src/matcher"}},
+		{input: "/repos/lion/src/blob/blob", expectedHandler: blobH, expectedParams: mss{"owner": "lion", "path": "src/blob"}},
+		{input: "/repos/lion/src/tree", expectedHandler: treeH, expectedParams: mss{"owner": "lion", "path": "src"}},
+		{input: "/repos/lion/src/blob/main.go", expectedHandler: repoH, expectedParams: mss{"owner": "lion", "path": "src/blob/main.go"}},
+		{input: "/repos/lion/blobs", expectedHandler: repoH, expectedParams: mss{"owner": "lion", "path": "blobs"}},
+		{input: "/static/js/app.js.map", expectedHandler: mapH, expectedParams: mss{"file": "js/app.js"}},
+		{input: "/static/js/app.js", expectedHandler: staticH, expectedParams: mss{"file": "js/app.js"}},
+		{input: "/raw/a/b/content", expectedHandler: rawH, expectedParams: mss{"*": "a/b"}},
+		{input: "/raw/a/b", expectedHandler: nil, expectedParams: mss{}},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.input, nil)
+		c := newContext()
+		h := mux.hostrm.Match(c, req)
+		req = setParamContext(req, c)
+
+		if len(test.expectedParams) != len(c.params) {
+			t.Errorf("Length missmatch: expected %d but got %d (%v) for path %s", len(test.expectedParams), len(c.params), c.toMap(), test.input)
+		}
+
+		for k, v := range test.expectedParams {
+			actual := Param(req, k)
+			if actual != v {
+				t.Errorf("Expected key %s to equal %s but got %s for url: %s", cyan(k), green(v), red(actual), test.input)
+			}
+		}
+
+		if fmt.Sprintf("%v", h) != fmt.Sprintf("%v", test.expectedHandler) {
+			t.Errorf("Handler not match for %s", test.input)
+		}
+	}
+
+	htest.New(t, mux).Get("/git/src/blob/").Do().
+		ExpectStatus(http.StatusMovedPermanently).
+		ExpectHeader("Location", "/git/src/blob")
+
+	if ds := mux.Validate(); len(ds) > 0 {
+		t.Errorf("Should not report any problem but got:\n%s", ds)
+	}
+}
+
 func TestServeFiles(t *testing.T) {
 	cwd, _ := os.Getwd()
 	// Temporary directory
@@ -761,3 +902,22 @@ func randToken() string {
 var red = color.New(color.FgRed).SprintFunc()
 var green = color.New(color.FgGreen).SprintFunc()
 var cyan = color.New(color.FgCyan).SprintFunc()
+
+func TestAutomaticOptionsMiddlewares(t *testing.T) {
+	l := New()
+	api := l.Group("/api", fakeMW("group", "true"))
+	api.Get("/users/:id", wrap(func(c Context) {
+		rt := c.Route()
+		if rt == nil || rt.Pattern() != "/api/users/:id" {
+			t.Errorf("Incorrect route in context: %v", rt)
+		}
+	}))
+
+	test := htest.New(t, l)
+	test.Options("/api/users/123").Do().
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("Accept", "GET,OPTIONS").
+		ExpectHeader("group", "true")
+	test.Get("/api/users/123").Do().
+		ExpectStatus(http.StatusOK)
+}
@@ -36,6 +36,16 @@ func reverseHostStdLib(pattern string) string {
 func wrap(ctxHandler func(Context)) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		c := C(r)
+		if lc, ok := c.(*ctx); ok {
+			// Write through the ResponseWriters of the middlewares
+			if w != http.ResponseWriter(lc) && w != lc.ResponseWriter {
+				lc.ResponseWriter = w
+				lc.code = 0
+				lc.statusWritten = false
+			}
+			// Use the request modified by the middlewares
+			lc.req = r
+		}
 		ctxHandler(c)
 	}
 	return http.HandlerFunc(fn)
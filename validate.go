@@ -0,0 +1,237 @@
+package lion
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/celrenheit/lion/internal/matcher"
+)
+
+// DiagnosticKind identifies the kind of problem reported by Router.Validate
+type DiagnosticKind string
+
+// Kinds of problems reported by Router.Validate
+const (
+	// DiagnosticConflict is reported when the same method and pattern are registered more than once.
+	// Only the last registered handler is served.
+	DiagnosticConflict DiagnosticKind = "conflict"
+	// DiagnosticShadowed is reported when requests for a pattern are served by another route.
+	DiagnosticShadowed DiagnosticKind = "shadowed"
+	// DiagnosticUnreachable is reported when requests for a pattern are not served by any route.
+	DiagnosticUnreachable DiagnosticKind = "unreachable"
+	// DiagnosticOverlap is reported when a regex parameter matches a static segment registered at the same position.
+	// The static route takes precedence.
+	DiagnosticOverlap DiagnosticKind = "overlap"
+	// DiagnosticDuplicateName is reported when several routes share the same name.
+	// Routes.ByName only returns the first one.
+	DiagnosticDuplicateName DiagnosticKind = "duplicate-name"
+)
+
+// Diagnostic describes a problem found by Router.Validate
+type Diagnostic struct {
+	Kind    DiagnosticKind `json:"kind"`
+	Host    string         `json:"host,omitempty"`
+	Method  string         `json:"method,omitempty"`
+	Pattern string         `json:"pattern"`
+	// Other is the other pattern involved, if any
+	Other   string `json:"other,omitempty"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Kind, d.Message)
+}
+
+// Diagnostics is a list of Diagnostic
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) String() string {
+	sa := make([]string, 0, len(ds))
+	for _, d := range ds {
+		sa = append(sa, d.String())
+	}
+	return strings.Join(sa, "\n")
+}
+
+// ByKind returns the diagnostics of the kind specified
+func (ds Diagnostics) ByKind(kind DiagnosticKind) Diagnostics {
+	var out Diagnostics
+	for _, d := range ds {
+		if d.Kind == kind {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// Validate walks the host and path trees and reports conflicting registrations, shadowed or unreachable routes,
+// regex parameters overlapping static segments and duplicate route names.
+// It is meant to be run in unit tests:
+//
+//	if ds := router.Validate(); len(ds) > 0 {
+//		t.Fatal(ds)
+//	}
+func (r *Router) Validate() Diagnostics {
+	var ds Diagnostics
+	routes := r.Routes()
+
+	ds = append(ds, validateNames(routes)...)
+
+	seen := map[*route]bool{}
+	matchers := map[registerMatcher]string{}
+	for _, rr := range routes {
+		rt, ok := rr.(*route)
+		if !ok || seen[rt] {
+			continue
+		}
+		seen[rt] = true
+		matchers[rt.pathMatcher] = rt.host
+
+		for _, method := range rt.Methods() {
+			if info := rt.infos[method]; info.registrations > 1 {
+				ds = append(ds, Diagnostic{
+					Kind:    DiagnosticConflict,
+					Host:    rt.host,
+					Method:  method,
+					Pattern: rt.pattern,
+					Message: fmt.Sprintf("%s %s%s is registered %d times, only the last handler is used", method, rt.host, rt.pattern, info.registrations),
+				})
+			}
+		}
+
+		if d, ok := r.validateHost(rt); !ok {
+			ds = append(ds, d)
+		}
+		if d, ok := validatePath(rt); !ok {
+			ds = append(ds, d)
+		}
+	}
+
+	var overlaps Diagnostics
+	for rm, host := range matchers {
+		pm, ok := rm.(*pathMatcher)
+		if !ok {
+			continue
+		}
+		for _, o := range matcher.Overlaps(pm.matcher) {
+			overlaps = append(overlaps, Diagnostic{
+				Kind:    DiagnosticOverlap,
+				Host:    host,
+				Pattern: o.Param,
+				Other:   o.Static,
+				Message: fmt.Sprintf("%s%s also matches the static path %s which takes precedence", host, o.Param, o.Static),
+			})
+		}
+	}
+	// The matchers are iterated in random order
+	sort.Slice(overlaps, func(i, j int) bool {
+		a, b := overlaps[i], overlaps[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Pattern != b.Pattern {
+			return a.Pattern < b.Pattern
+		}
+		return a.Other < b.Other
+	})
+
+	return append(ds, overlaps...)
+}
+
+func validateNames(routes Routes) Diagnostics {
+	var ds Diagnostics
+	byName := map[string]Route{}
+	for _, rt := range routes {
+		name := rt.Name()
+		if name == "" {
+			continue
+		}
+		first, ok := byName[name]
+		if !ok {
+			byName[name] = rt
+			continue
+		}
+		if first == rt {
+			continue
+		}
+		ds = append(ds, Diagnostic{
+			Kind:    DiagnosticDuplicateName,
+			Host:    rt.Host(),
+			Pattern: rt.Pattern(),
+			Other:   first.Pattern(),
+			Message: fmt.Sprintf("name '%s' of %s%s is already used by %s%s", name, rt.Host(), rt.Pattern(), first.Host(), first.Pattern()),
+		})
+	}
+	return ds
+}
+
+// validateHost checks that a host matching the route's host pattern is served by the route's path matcher.
+func (r *Router) validateHost(rt *route) (Diagnostic, bool) {
+	hm := r.root().hostrm
+	if !hm.multihost {
+		return Diagnostic{}, true
+	}
+
+	host := rt.host
+	if host == "" {
+		host = defaultAnyHostPattern
+	}
+	example, err := matcher.Example(hm.matcher, reverseHost(host))
+	if err != nil {
+		return Diagnostic{
+			Kind:    DiagnosticUnreachable,
+			Host:    rt.host,
+			Pattern: rt.pattern,
+			Message: fmt.Sprintf("cannot build a host for %s: %s", host, err),
+		}, false
+	}
+
+	c := newContext()
+	value, _ := hm.matcher.GetWithContext(c, example, nil)
+	if rm, ok := value.(registerMatcher); ok && rm == rt.pathMatcher {
+		return Diagnostic{}, true
+	}
+
+	return Diagnostic{
+		Kind:    DiagnosticShadowed,
+		Host:    rt.host,
+		Pattern: rt.pattern,
+		Message: fmt.Sprintf("host %s of %s is matched by another host pattern", reverseHost(example), rt.host),
+	}, false
+}
+
+// validatePath checks that a path matching the route's pattern is served by the route itself.
+func validatePath(rt *route) (Diagnostic, bool) {
+	pm, ok := rt.pathMatcher.(*pathMatcher)
+	if !ok {
+		return Diagnostic{}, true
+	}
+
+	d := Diagnostic{
+		Kind:    DiagnosticUnreachable,
+		Host:    rt.host,
+		Pattern: rt.pattern,
+	}
+
+	example, err := matcher.Example(pm.matcher, rt.pattern)
+	if err != nil {
+		d.Message = fmt.Sprintf("cannot build a path for %s%s: %s", rt.host, rt.pattern, err)
+		return d, false
+	}
+
+	store, _ := matcher.Find(pm.matcher, newContext(), cleanPath(example))
+	if store == rt {
+		return Diagnostic{}, true
+	}
+
+	if other, ok := store.(*route); ok && other != nil {
+		d.Kind = DiagnosticShadowed
+		d.Other = other.pattern
+		d.Message = fmt.Sprintf("%s%s is shadowed by %s: %s is served by the latter", rt.host, rt.pattern, other.pattern, example)
+		return d, false
+	}
+
+	d.Message = fmt.Sprintf("%s%s is unreachable: no route serves %s", rt.host, rt.pattern, example)
+	return d, false
+}
@@ -0,0 +1,93 @@
+package lion
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	l := New()
+	patterns := []string{
+		"/hello", "/hello/contact", `/hello/\:name`, "/hello/:name", "/hello/:name/tweets",
+		"/hello/contact/named", "/hello/contact/:dest", "/hello/contact/:dest/static",
+		"/hello/contact/:dest/:from", "/hello/contact/:dest/*path",
+		"/extension/:file.:ext", "/@:username", "/static/*",
+		"/users/:userID/profile", "/users/super/*", "/users/*",
+		"/regexp/static", "/regexp/:param([a-z]{3})", "/regexp/n/:n([0-9]+)",
+		"/regexp/abc/:p(a|b/c)", "/regexp/abc/:p(a|b/c)/:n([0-9]+)", "/regexp/abc/*any",
+	}
+	for _, p := range patterns {
+		l.Get(p, fakeHandler())
+	}
+	l.Host("$sub.example.com").Get("/", fakeHandler())
+	l.Host("*.example.com").Get("/", fakeHandler())
+
+	if ds := l.Validate(); len(ds) > 0 {
+		t.Errorf("Should not report any problem but got:\n%s", ds)
+	}
+}
+
+func TestValidateDiagnostics(t *testing.T) {
+	l := New()
+	l.Get("/a", fakeHandler()).WithName("a")
+	l.Get("/b", fakeHandler()).WithName("a")
+	l.Get("/b", fakeHandler())
+	l.Get("/regexp/bbb", fakeHandler())
+	l.Get("/regexp/:param([a-z]{3})", fakeHandler())
+	l.Get("/x/:all(.*)", fakeHandler())
+	l.Get("/x/*rest", fakeHandler())
+	l.Get("/t/:p/", fakeHandler())
+
+	ds := l.Validate()
+
+	tests := []struct {
+		kind    DiagnosticKind
+		pattern string
+		other   string
+	}{
+		{kind: DiagnosticDuplicateName, pattern: "/b", other: "/a"},
+		{kind: DiagnosticConflict, pattern: "/b"},
+		{kind: DiagnosticOverlap, pattern: "/regexp/:param([a-z]{3})", other: "/regexp/bbb"},
+		{kind: DiagnosticShadowed, pattern: "/x/*rest", other: "/x/:all(.*)"},
+		{kind: DiagnosticUnreachable, pattern: "/t/:p/"},
+	}
+
+	if len(ds) != len(tests) {
+		t.Fatalf("Expected %d diagnostics but got %d:\n%s", len(tests), len(ds), ds)
+	}
+
+	for _, test := range tests {
+		found := ds.ByKind(test.kind)
+		if len(found) != 1 {
+			t.Errorf("Expected one %s diagnostic but got %d", test.kind, len(found))
+			continue
+		}
+		if found[0].Pattern != test.pattern || found[0].Other != test.other {
+			t.Errorf("Incorrect %s diagnostic: got (%s, %s) want (%s, %s)", test.kind, found[0].Pattern, found[0].Other, test.pattern, test.other)
+		}
+	}
+}
+
+func TestValidateOverlapsOrder(t *testing.T) {
+	l := New()
+	for _, host := range []string{"c.example.com", "a.example.com", "b.example.com", ""} {
+		h := l.Host(host)
+		h.Get("/regexp/bbb", fakeHandler())
+		h.Get("/regexp/:param([a-z]{3})", fakeHandler())
+		h.Get("/other/aaa", fakeHandler())
+		h.Get("/other/:param([a-z]{3})", fakeHandler())
+	}
+
+	expected := ""
+	for _, host := range []string{"", "a.example.com", "b.example.com", "c.example.com"} {
+		expected += host + "/other/:param([a-z]{3})\n" + host + "/regexp/:param([a-z]{3})\n"
+	}
+	for i := 0; i < 10; i++ {
+		got := ""
+		for _, d := range l.Validate().ByKind(DiagnosticOverlap) {
+			got += d.Host + d.Pattern + "\n"
+		}
+		if got != expected {
+			t.Fatalf("Overlaps should be sorted by host and pattern, got:\n%s", got)
+		}
+	}
+}